@@ -3,7 +3,10 @@ package driver
 import (
 	"ble-mesh/mesh"
 	"ble-mesh/utils"
+	"bytes"
 	"encoding/binary"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -29,26 +32,50 @@ type (
 		p    gatt.Peripheral
 	}
 
+	// ProxyNode is a provisioned node advertising the Mesh Proxy service
+	ProxyNode struct {
+		Name string
+		Mac  string
+		// proxyNetworkId or proxyNodeIdentity
+		IdentificationType byte
+		// network id, or hash and random of node identity
+		Data []byte
+		// unicast address of the node resolved from the node identity, 0 if unknown
+		Address  uint
+		Rssi     int
+		LastSeen time.Time
+		p        gatt.Peripheral
+	}
+
 	Session struct {
 		node    *UnprovisionedNode
 		driver  *Driver
 		p       gatt.Peripheral
 		uuidSvc string
 		ch      chan bool
+		// key of the session in Driver.sessions
+		key string
 
-		onDataRecvd func(data []byte)
+		onDataRecvd    func(data []byte)
+		onDisconnected func()
 
 		charaOut *gatt.Characteristic
 		charaIn  *gatt.Characteristic
 	}
 
 	Driver struct {
-		dev           gatt.Device
-		connecting    bool
-		sessions      map[string]*Session
-		activeSession *Session
+		dev gatt.Device
+		// sessions by device uuid
+		sessions map[string]*Session
+		// sessions waiting for the connection, by peripheral id
+		connecting   map[string]*Session
+		sessionsLock *sync.Mutex
 
 		unprovNodes map[string]*UnprovisionedNode
+		// proxy nodes by peripheral id
+		proxies map[string]*ProxyNode
+		// last connection failure of proxies, by peripheral id
+		proxyFailures map[string]time.Time
 
 		devicePoweredOn      func()
 		deviceUnavailable    func()
@@ -67,8 +94,23 @@ const (
 	UUID_MESH_PROXY_DATA_OUT = "2ade"
 )
 
+/* Identification types of Mesh Proxy service data */
+const (
+	proxyNetworkId    = 0x00
+	proxyNodeIdentity = 0x01
+)
+
+// number of devices which can be provisioned at the same time
+const maxSessions = 8
+
+// a proxy which failed is tried again after the other ones
+const proxyRetryInterval = 30 * time.Second
+
+// a proxy is considered unreachable when it is not seen for this duration
+const proxyStaleTimeout = 60 * time.Second
+
 var defaultClientOptions = []gatt.Option{
-	gatt.LnxMaxConnections(1),
+	gatt.LnxMaxConnections(maxSessions),
 	gatt.LnxDeviceID(-1, true),
 }
 var logger *logrus.Entry
@@ -92,21 +134,24 @@ func (d *Driver) onStateChanged(dev gatt.Device, s gatt.State) {
 	}
 }
 
-func (d *Driver) saveUnprovNode(uuid, name, mac string, oob uint16, adv, gatt bool, p gatt.Peripheral) {
+func (d *Driver) saveUnprovNode(uuid, name, mac string, oob uint16, hash uint32, adv, gatt bool, p gatt.Peripheral) {
+	d.sessionsLock.Lock()
 	node, ok := d.unprovNodes[uuid]
 	if !ok {
 		node = &UnprovisionedNode{}
+		d.unprovNodes[uuid] = node
 	}
 
 	node.Name = name
 	node.Mac = mac
 	node.UUID = uuid
 	node.OOB = oob
+	node.Hash = hash
 	node.p = p
 	node.Adv = adv
 	node.Gatt = gatt
+	d.sessionsLock.Unlock()
 	if !ok {
-		d.unprovNodes[uuid] = node
 		logger.Debugf("unprovisoned node: %+#v", node)
 		if d.unProvNodeDiscovered != nil {
 			d.unProvNodeDiscovered(node)
@@ -117,23 +162,30 @@ func (d *Driver) saveUnprovNode(uuid, name, mac string, oob uint16, adv, gatt bo
 
 func (d *Driver) onPeriphDiscovered(p gatt.Peripheral, a *gatt.Advertisement, rssi int) {
 	if len(a.Raw) > 2 && a.Raw[1] == mesh.BT_LE_ADV_BEACON && a.Raw[2] == 0 {
-		// todo: hash
 		uuid, _ := uuid.FromBytes(a.Raw[3:19])
-		if n, ok := d.unprovNodes[uuid.String()]; ok && n.Adv {
+		if n := d.GetUnprovNode(uuid.String()); n != nil && n.Adv {
 			return
 		}
 		oob := binary.BigEndian.Uint16(a.Raw[19:21])
-		d.saveUnprovNode(uuid.String(), p.Name(), p.ID(), oob, true, false, p)
+		// URI Hash is optional
+		hash := uint32(0)
+		if a.Raw[0] >= 24 && len(a.Raw) >= 25 {
+			hash = binary.BigEndian.Uint32(a.Raw[21:25])
+		}
+		d.saveUnprovNode(uuid.String(), p.Name(), p.ID(), oob, hash, true, false, p)
 	}
 	for _, s := range a.ServiceData {
+		if s.UUID.String() == UUID_MESH_PROXY && len(s.Data) > 0 {
+			d.saveProxyNode(p, s.Data[0], s.Data[1:], rssi)
+		}
 		if s.UUID.String() == UUID_MESH_PROVISIONING {
 			uuid, _ := uuid.FromBytes(s.Data[:16])
-			if n, ok := d.unprovNodes[uuid.String()]; ok && n.Gatt {
+			if n := d.GetUnprovNode(uuid.String()); n != nil && n.Gatt {
 				return
 			}
 			oob := binary.BigEndian.Uint16(s.Data[16:])
 
-			d.saveUnprovNode(uuid.String(), p.Name(), p.ID(), oob, false, true, p)
+			d.saveUnprovNode(uuid.String(), p.Name(), p.ID(), oob, 0, false, true, p)
 		}
 	}
 	if d.advertismentReceived != nil {
@@ -141,11 +193,63 @@ func (d *Driver) onPeriphDiscovered(p gatt.Peripheral, a *gatt.Advertisement, rs
 	}
 }
 
+// saveProxyNode saves the mesh proxy service data, network id: 8 bytes,
+// node identity: 8 bytes hash and 8 bytes random
+func (d *Driver) saveProxyNode(p gatt.Peripheral, idType byte, data []byte, rssi int) {
+	switch {
+	case idType == proxyNetworkId && len(data) >= 8:
+		data = data[:8]
+	case idType == proxyNodeIdentity && len(data) >= 16:
+		data = data[:16]
+	default:
+		return
+	}
+	d.sessionsLock.Lock()
+	node, ok := d.proxies[p.ID()]
+	// the random of node identity changes from time to time, resolve it only when it changes
+	resolved := ok && node.IdentificationType == idType && bytes.Equal(node.Data, data)
+	var addr uint
+	if resolved {
+		addr = node.Address
+	}
+	d.sessionsLock.Unlock()
+	if !resolved && idType == proxyNodeIdentity {
+		addr, _ = mesh.ResolveNodeIdentity(data[:8], data[8:])
+	}
+
+	d.sessionsLock.Lock()
+	defer d.sessionsLock.Unlock()
+	if !ok {
+		node = &ProxyNode{}
+		d.proxies[p.ID()] = node
+	}
+	if !resolved {
+		logger.Debugf("proxy node: %s, type: %d, data: % 2x, address: %04x", p.ID(), idType, data, addr)
+	}
+	node.Name = p.Name()
+	node.Mac = p.ID()
+	node.IdentificationType = idType
+	node.Data = append([]byte{}, data...)
+	node.Address = addr
+	node.Rssi = rssi
+	node.LastSeen = time.Now()
+	node.p = p
+}
+
 func (d *Driver) onPeriphConnected(p gatt.Peripheral, err error) {
 	logger.Debug("Connected")
-	defer func() { d.connecting = false }()
-	// defer p.Device().CancelConnection(p)
-	session := d.activeSession
+	d.sessionsLock.Lock()
+	session, ok := d.connecting[p.ID()]
+	delete(d.connecting, p.ID())
+	d.sessionsLock.Unlock()
+	if !ok {
+		return
+	}
+	if err != nil {
+		logger.Errorf("Failed to connect, err: %s\n", err)
+		session.ch <- false
+		return
+	}
 	if err := p.SetMTU(69); err != nil {
 		logger.Errorf("Failed to set MTU, err: %s\n", err)
 		session.ch <- false
@@ -220,9 +324,25 @@ func (d *Driver) onPeriphConnected(p gatt.Peripheral, err error) {
 }
 
 func (d *Driver) onPeriphDisconnected(p gatt.Peripheral, err error) {
+	d.sessionsLock.Lock()
+	defer d.sessionsLock.Unlock()
+	for key, s := range d.sessions {
+		if s.p != nil && s.p.ID() == p.ID() {
+			logger.Debugf("session of %s disconnected", key)
+			delete(d.sessions, key)
+			if s.uuidSvc == UUID_MESH_PROXY {
+				d.proxyFailures[key] = time.Now()
+			}
+			if s.onDisconnected != nil {
+				go s.onDisconnected()
+			}
+		}
+	}
 }
 
 func (d *Driver) GetUnprovNodes() []*UnprovisionedNode {
+	d.sessionsLock.Lock()
+	defer d.sessionsLock.Unlock()
 	nodes := []*UnprovisionedNode{}
 	for _, n := range d.unprovNodes {
 		nodes = append(nodes, n)
@@ -230,49 +350,173 @@ func (d *Driver) GetUnprovNodes() []*UnprovisionedNode {
 	return nodes
 }
 
+func (d *Driver) GetUnprovNode(uuid string) *UnprovisionedNode {
+	d.sessionsLock.Lock()
+	defer d.sessionsLock.Unlock()
+	return d.unprovNodes[uuid]
+}
+
+func (d *Driver) RemoveUnprovNode(uuid string) {
+	d.sessionsLock.Lock()
+	defer d.sessionsLock.Unlock()
+	delete(d.unprovNodes, uuid)
+}
+
+// OpenProvisionGatt connects to the provisioning service of the device, it can be
+// called for several devices in parallel
 func (d *Driver) OpenProvisionGatt(uuid string) *Session {
 	// d.dev.StopScanning()
 	// d.dev.StopAdvertising()
-	defer func() { d.activeSession = nil }()
-	if d.connecting {
-		logger.Error("connecting to other device is not finished, please retry later")
+	d.sessionsLock.Lock()
+	n, ok := d.unprovNodes[uuid]
+	if !ok {
+		d.sessionsLock.Unlock()
 		return nil
 	}
-	if n, ok := d.unprovNodes[uuid]; ok {
-		d.connecting = true
-		session := &Session{uuidSvc: UUID_MESH_PROVISIONING, ch: make(chan bool)}
-		d.activeSession = session
-		d.dev.Connect(n.p)
-
-		var success bool
-		to := time.NewTimer(time.Second * 5)
-		select {
-		case success = <-d.activeSession.ch:
-			if !success {
-				return nil
-			}
-		case <-to.C:
-			logger.Error("connect timeout")
-			return nil
+	session := &Session{uuidSvc: UUID_MESH_PROVISIONING, ch: make(chan bool, 1), node: n, driver: d, key: uuid}
+	d.sessionsLock.Unlock()
+	if !d.connect(n.p, session) {
+		return nil
+	}
+	return session
+}
+
+// connect connects to the peripheral and opens the service of the session
+func (d *Driver) connect(p gatt.Peripheral, session *Session) bool {
+	d.sessionsLock.Lock()
+	if _, ok := d.sessions[session.key]; ok {
+		d.sessionsLock.Unlock()
+		logger.Errorf("session of %s is already opened", session.key)
+		return false
+	}
+	if _, ok := d.connecting[p.ID()]; ok {
+		d.sessionsLock.Unlock()
+		logger.Errorf("connecting to %s is not finished, please retry later", session.key)
+		return false
+	}
+	if len(d.sessions)+len(d.connecting) >= maxSessions {
+		d.sessionsLock.Unlock()
+		logger.Error("too many sessions, please retry later")
+		return false
+	}
+	d.connecting[p.ID()] = session
+	d.sessionsLock.Unlock()
+
+	d.dev.Connect(p)
+
+	var success bool
+	to := time.NewTimer(time.Second * 5)
+	select {
+	case success = <-session.ch:
+	case <-to.C:
+		logger.Error("connect timeout")
+	}
+	d.sessionsLock.Lock()
+	defer d.sessionsLock.Unlock()
+	delete(d.connecting, p.ID())
+	if !success {
+		d.dev.CancelConnection(p)
+		return false
+	}
+	d.sessions[session.key] = session
+	return true
+}
+
+// removeStaleProxies removes the proxies which are not seen for a while, sessionsLock must be held
+func (d *Driver) removeStaleProxies() {
+	for id, n := range d.proxies {
+		if time.Since(n.LastSeen) > proxyStaleTimeout {
+			delete(d.proxies, id)
 		}
+	}
+}
+
+// GetProxyNodes returns the reachable proxies, the strongest signal first
+func (d *Driver) GetProxyNodes() []ProxyNode {
+	d.sessionsLock.Lock()
+	defer d.sessionsLock.Unlock()
+	d.removeStaleProxies()
+	nodes := []ProxyNode{}
+	for _, n := range d.proxies {
+		nodes = append(nodes, *n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Rssi > nodes[j].Rssi })
+	return nodes
+}
 
-		if d.sessions == nil {
-			d.sessions = map[string]*Session{}
+// FindProxyByAddress returns the proxy advertising the node identity of the node
+func (d *Driver) FindProxyByAddress(addr uint) *ProxyNode {
+	for _, n := range d.GetProxyNodes() {
+		if n.IdentificationType == proxyNodeIdentity && n.Address == addr {
+			return &n
 		}
-		session.node = n
-		session.driver = d
-		d.sessions[uuid] = session
-		return session
 	}
 	return nil
 }
 
+// proxyCandidates returns the reachable proxies of our network, the ones advertising
+// a known network id first, then our nodes advertising their node identity,
+// the ones which failed recently last
+func (d *Driver) proxyCandidates() []*ProxyNode {
+	d.sessionsLock.Lock()
+	defer d.sessionsLock.Unlock()
+	d.removeStaleProxies()
+	var networkIds, identities, failed []*ProxyNode
+	for id, n := range d.proxies {
+		if n.IdentificationType == proxyNetworkId && !mesh.IsKnownNetworkId(n.Data) {
+			continue
+		}
+		if n.IdentificationType == proxyNodeIdentity && n.Address == 0 {
+			continue
+		}
+		if t, ok := d.proxyFailures[id]; ok && time.Since(t) < proxyRetryInterval {
+			failed = append(failed, n)
+		} else if n.IdentificationType == proxyNetworkId {
+			networkIds = append(networkIds, n)
+		} else {
+			identities = append(identities, n)
+		}
+	}
+	for _, l := range [][]*ProxyNode{networkIds, identities, failed} {
+		sort.Slice(l, func(i, j int) bool { return l[i].Rssi > l[j].Rssi })
+	}
+	return append(append(networkIds, identities...), failed...)
+}
+
+// OpenProxyGatt connects to a proxy node of our network, onDisconnected is
+// called when the connection drops so that another proxy can be selected
+func (d *Driver) OpenProxyGatt(onDisconnected func()) *Session {
+	for _, n := range d.proxyCandidates() {
+		session := &Session{uuidSvc: UUID_MESH_PROXY, ch: make(chan bool, 1), driver: d, key: n.p.ID(), onDisconnected: onDisconnected}
+		if d.connect(n.p, session) {
+			logger.Infof("connected to proxy %s", n.Mac)
+			return session
+		}
+		d.sessionsLock.Lock()
+		d.proxyFailures[n.p.ID()] = time.Now()
+		d.sessionsLock.Unlock()
+	}
+	return nil
+}
+
+// Close disconnects the proxy session
+func (s *Session) Close() {
+	s.driver.sessionsLock.Lock()
+	s.onDisconnected = nil
+	s.driver.sessionsLock.Unlock()
+	if s.p != nil {
+		s.p.Device().CancelConnection(s.p)
+	}
+}
+
 func (s *Session) OnProvisionFinished() {
 	if s.p != nil {
 		s.p.Device().CancelConnection(s.p)
 	}
+	s.driver.sessionsLock.Lock()
 	delete(s.driver.unprovNodes, s.node.UUID)
 	delete(s.driver.sessions, s.node.UUID)
+	s.driver.sessionsLock.Unlock()
 }
 
 func (s *Session) GetMTU() uint {
@@ -325,6 +569,11 @@ func StartDiscovery() (*Driver, error) {
 	logger = utils.CreateLogger("driver")
 	driver := &Driver{}
 	driver.unprovNodes = map[string]*UnprovisionedNode{}
+	driver.sessions = map[string]*Session{}
+	driver.connecting = map[string]*Session{}
+	driver.proxies = map[string]*ProxyNode{}
+	driver.proxyFailures = map[string]time.Time{}
+	driver.sessionsLock = new(sync.Mutex)
 	d, err := gatt.NewDevice(defaultClientOptions...)
 	if err != nil {
 		return nil, err
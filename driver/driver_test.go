@@ -0,0 +1,68 @@
+package driver
+
+import (
+	"ble-mesh/utils"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bettercap/gatt"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakePeripheral struct {
+	gatt.Peripheral
+	id string
+}
+
+func (p *fakePeripheral) ID() string { return p.id }
+
+// fakeDevice completes every connection after a while
+type fakeDevice struct {
+	gatt.Device
+	d *Driver
+}
+
+func (f *fakeDevice) Connect(p gatt.Peripheral) {
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		f.d.sessionsLock.Lock()
+		s := f.d.connecting[p.ID()]
+		f.d.sessionsLock.Unlock()
+		s.ch <- true
+	}()
+}
+
+func (f *fakeDevice) CancelConnection(p gatt.Peripheral) {}
+
+func Test_connectMaxSessions(t *testing.T) {
+	logger = utils.CreateLogger("driver")
+	d := &Driver{
+		sessions:     map[string]*Session{},
+		connecting:   map[string]*Session{},
+		sessionsLock: new(sync.Mutex),
+	}
+	d.dev = &fakeDevice{d: d}
+	connected := make(chan bool, maxSessions+4)
+	wg := sync.WaitGroup{}
+	for i := 0; i < maxSessions+4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s := &Session{uuidSvc: UUID_MESH_PROVISIONING, ch: make(chan bool, 1), driver: d, key: fmt.Sprintf("dev%d", i)}
+			connected <- d.connect(&fakePeripheral{id: fmt.Sprintf("p%d", i)}, s)
+		}(i)
+	}
+	wg.Wait()
+	close(connected)
+	n := 0
+	for ok := range connected {
+		if ok {
+			n++
+		}
+	}
+	assert.Equal(t, maxSessions, n)
+	assert.Equal(t, maxSessions, len(d.sessions))
+	assert.Equal(t, 0, len(d.connecting))
+}
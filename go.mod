@@ -24,5 +24,5 @@ require (
 	github.com/ugorji/go v1.1.4 // indirect
 	golang.org/x/sys v0.0.0-20190429094411-2cc0cad0ac78 // indirect
 	gopkg.in/go-playground/validator.v8 v8.18.2 // indirect
-	gopkg.in/yaml.v2 v2.2.2 // indirect
+	gopkg.in/yaml.v2 v2.2.2
 )
@@ -1,6 +1,11 @@
 package main
 
 import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"os/signal"
@@ -8,9 +13,11 @@ import (
 	"syscall"
 
 	"ble-mesh/utils"
+	"ble-mesh/utils/errors"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 
 	"ble-mesh/driver"
 	"ble-mesh/mesh"
@@ -32,6 +39,11 @@ type (
 
 const adapterID = "hci1"
 
+const (
+	advMode = iota
+	gattMode
+)
+
 func createCmd() {
 
 }
@@ -40,6 +52,17 @@ var (
 	app    Application
 	drv    *driver.Driver
 	logger = utils.CreateLogger("main")
+	// static oob keys of devices, uuid -> key in hex
+	oobKeys = map[string]string{}
+	// oob public keys of devices, uuid -> X || Y in hex
+	oobPublicKeys = map[string]string{}
+	// URIs serving the oob public keys as hex, URI hash of the beacon in hex -> URI
+	oobUris = map[string]string{}
+	// output oob values waited for by the provisioning sessions, uuid -> value
+	oobPending     = map[string]chan string{}
+	oobPendingLock sync.Mutex
+	// configuration applied to every newly provisioned node
+	onboardingProfile *mesh.OnboardingProfile
 )
 
 func cleanup() {
@@ -47,6 +70,10 @@ func cleanup() {
 }
 
 func startMesh() {
+	if app.mode == gattMode {
+		startProxyMesh()
+		return
+	}
 	var bear mesh.Bear
 	bear = &mesh.AdvertisingBear{}
 	drv.Handle(driver.AdvertisementReceived(func(d []byte) {
@@ -57,7 +84,44 @@ func startMesh() {
 	mesh.StartMeshNetwork()
 }
 
+// startProxyMesh uses a connection to a proxy node as the network bearer
+func startProxyMesh() {
+	var bear mesh.Bear
+	bear = &mesh.GattProxyBear{}
+	bear.SetMTU(69)
+	mesh.SetNetworkBear(bear)
+	mesh.StartMeshNetwork()
+	go connectProxy(bear)
+}
+
+// connectProxy keeps a proxy connected, another proxy is selected when the connection drops
+func connectProxy(bear mesh.Bear) {
+	for {
+		disconnected := make(chan struct{})
+		session := drv.OpenProxyGatt(func() { close(disconnected) })
+		if session == nil {
+			logger.Warn("no proxy node is available, retry later")
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		session.RegisterGattDataEventHandler(bear.OnPduReceived)
+		bear.SetWriteHandle(session.Write)
+		go func() {
+			if err := mesh.ProxyConnected(); err != nil {
+				logger.Errorf("failed to set up the proxy filter, error: %s", err)
+			}
+		}()
+		<-disconnected
+		bear.SetWriteHandle(nil)
+		logger.Warn("proxy disconnected, reconnecting")
+	}
+}
+
 func provision(node string) {
+	if n := drv.GetUnprovNode(node); n != nil && !n.Gatt {
+		provisionAdv(node)
+		return
+	}
 	var bear mesh.Bear
 	bear = &mesh.GattProxyBear{}
 	session := drv.OpenProvisionGatt(node)
@@ -67,21 +131,198 @@ func provision(node string) {
 	session.RegisterGattDataEventHandler(bear.OnPduReceived)
 	bear.SetWriteHandle(session.Write)
 	bear.SetMTU(69)
-	mesh.SetProvisionBear(bear)
 	time.Sleep(time.Second)
-	mesh.StartMeshProvision(node, func() {
-		logger.Debug("provision finished")
+	s := mesh.StartMeshProvision(unprovisionedDevice(node), bear, func(r *mesh.ProvisionResult) {
+		logProvisionResult(r)
 		session.OnProvisionFinished()
 	})
+	if s == nil {
+		session.OnProvisionFinished()
+	}
+}
+
+func logProvisionResult(r *mesh.ProvisionResult) {
+	if r.Err != nil {
+		if r.Reason != mesh.ProvFailPdu {
+			logger.Errorf("provision %s failed, state: %d, err: %s", r.UUID, r.State, r.Err)
+			return
+		}
+		logger.Errorf("provision %s failed, state: %d, error code: %d, reported by device: %t, err: %s",
+			r.UUID, r.State, r.ErrorCode, r.ReportedByDevice, r.Err)
+		return
+	}
+	logger.Infof("provision %s finished, address: %04x", r.UUID, r.Node.UnicastAddress)
+	if onboardingProfile != nil {
+		go onboard(r.Node.UnicastAddress)
+	}
+}
+
+func onboard(addr uint) {
+	report := mesh.OnboardNode(addr, onboardingProfile)
+	if report.Succeeded() {
+		logger.Infof("onboarding %04x with profile %s finished", addr, report.Profile)
+		return
+	}
+	for _, s := range report.Failed() {
+		logger.Errorf("onboarding %04x: %s failed, err: %s", addr, s.Name, s.Err)
+	}
+}
+
+func provisionAdv(node string) {
+	var bear mesh.Bear
+	bear = &mesh.PbAdvBear{UUID: node}
+	bear.SetWriteHandle(drv.Advertise)
+	mesh.StartMeshProvision(unprovisionedDevice(node), bear, func(r *mesh.ProvisionResult) {
+		logProvisionResult(r)
+		drv.RemoveUnprovNode(node)
+	})
+}
+
+// unprovisionedDevice returns the beacon information of the discovered device
+func unprovisionedDevice(node string) mesh.UnprovisionedDevice {
+	dev := mesh.UnprovisionedDevice{UUID: node}
+	if n := drv.GetUnprovNode(node); n != nil {
+		dev.OOB = n.OOB
+		dev.UriHash = n.Hash
+	}
+	return dev
+}
+
+func loadKeyTable(path string, table *map[string]string) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(data, table); err != nil {
+		logger.Errorf("Failed to load %s, err: %s\n", path, err)
+	}
+}
+
+// publicKeySource looks the key up by the uuid of the device, then fetches it
+// from the URI matching the URI hash of its beacon
+func publicKeySource(dev *mesh.UnprovisionedDevice) ([]byte, error) {
+	if key, ok := oobPublicKeys[dev.UUID]; ok {
+		return hex.DecodeString(key)
+	}
+	uri, ok := oobUris[fmt.Sprintf("%08x", dev.UriHash)]
+	if dev.UriHash == 0 || !ok {
+		return nil, errors.NotFound.New().AddContextF("oob public key of %s, uri hash %08x", dev.UUID, dev.UriHash)
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.NotFound.New().AddContextF("oob public key of %s at %s, status: %s", dev.UUID, uri, resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(strings.TrimSpace(string(body)))
+}
+
+func authValueHandler(req *mesh.AuthRequest) (string, error) {
+	switch req.Method {
+	case mesh.AuthStaticOob:
+		if key, ok := oobKeys[req.UUID]; ok {
+			return key, nil
+		}
+		return "", errors.NotFound.New().AddContextF("static oob key of %s", req.UUID)
+	case mesh.AuthInputOob:
+		logger.Infof("please input %s on device %s", req.Value, req.UUID)
+		return req.Value, nil
+	case mesh.AuthOutputOob:
+		input := make(chan string, 1)
+		oobPendingLock.Lock()
+		oobPending[req.UUID] = input
+		oobPendingLock.Unlock()
+		defer func() {
+			oobPendingLock.Lock()
+			delete(oobPending, req.UUID)
+			oobPendingLock.Unlock()
+		}()
+		logger.Infof("please enter the value output by device %s with: oob %s <value>", req.UUID, req.UUID)
+		select {
+		case v := <-input:
+			return v, nil
+		case <-time.After(time.Minute):
+			return "", errors.Timeout.New().AddContextF("waiting for oob value of %s", req.UUID)
+		}
+	}
+	return "", nil
+}
+
+var authMethodNames = map[string]mesh.AuthMethod{
+	"static": mesh.AuthStaticOob,
+	"output": mesh.AuthOutputOob,
+	"input":  mesh.AuthInputOob,
+	"none":   mesh.AuthNoOob,
+}
+
+// parseAuthPolicy parses the comma separated auth methods, e.g. "static,output"
+func parseAuthPolicy(methods string) (mesh.AuthPolicy, error) {
+	policy := mesh.AuthPolicy{Methods: []mesh.AuthMethod{}}
+	for _, name := range strings.Split(methods, ",") {
+		m, ok := authMethodNames[strings.TrimSpace(name)]
+		if !ok {
+			return policy, errors.NotFound.New().AddContextF("auth method %q", name)
+		}
+		policy.Methods = append(policy.Methods, m)
+	}
+	return policy, nil
+}
+
+// inputOob passes the value output by the device to its provisioning session
+func inputOob(uuid, value string) error {
+	oobPendingLock.Lock()
+	defer oobPendingLock.Unlock()
+	input, ok := oobPending[uuid]
+	if !ok {
+		return errors.NotFound.New().AddContextF("provisioning of %s waiting for an oob value", uuid)
+	}
+	select {
+	case input <- value:
+		return nil
+	default:
+		return errors.InvalidAuthValue.New().AddContextF("oob value of %s is already entered", uuid)
+	}
 }
 
 func main() {
 	var err error
+	proxy := flag.Bool("proxy", false, "use a GATT proxy node as the network bearer")
+	auth := flag.String("auth", "static,output,input",
+		"acceptable provisioning auth methods in order of preference: static, output, input, none (No OOB)")
+	flag.Parse()
+	if *proxy {
+		app.mode = gattMode
+	}
+	policy, err := parseAuthPolicy(*auth)
+	if err != nil {
+		logger.Fatalf("Invalid -auth, err: %s\n", err)
+	}
+	mesh.SetProvisionAuthPolicy(policy)
 	homeDir, _ := homedir.Dir()
 	if homeDir == "/root" {
 		homeDir = "/home/xxx"
 	}
 	mesh.Init(homeDir + "/.config/ble-mesh")
+	loadKeyTable(homeDir+"/.config/ble-mesh/oob.json", &oobKeys)
+	loadKeyTable(homeDir+"/.config/ble-mesh/pubkeys.json", &oobPublicKeys)
+	loadKeyTable(homeDir+"/.config/ble-mesh/oob-uris.json", &oobUris)
+	for _, name := range []string{"onboarding.json", "onboarding.yaml", "onboarding.yml"} {
+		if p, err := mesh.LoadOnboardingProfile(homeDir + "/.config/ble-mesh/" + name); err == nil {
+			onboardingProfile = p
+			break
+		} else if !os.IsNotExist(err) {
+			logger.Errorf("Failed to load onboarding profile %s, err: %s\n", name, err)
+		}
+	}
+	mesh.SetAuthValueHandler(authValueHandler)
+	mesh.SetPublicKeySource(publicKeySource)
 	drv, err = driver.StartDiscovery()
 	if err != nil {
 		logger.Fatalf("Failed to open device, err: %s\n", err)
@@ -94,7 +335,7 @@ func main() {
 
 	}))
 	drv.Handle(driver.UnProvNodeDiscovered(func(n *driver.UnprovisionedNode) {
-		provision(n.UUID)
+		go provision(n.UUID)
 	}))
 
 	c := make(chan os.Signal)
@@ -154,10 +395,15 @@ func callApi(fname string, args []string) {
 				n, _ := strconv.ParseInt(w, 16, 32)
 				res := uint(n)
 				value = reflect.ValueOf(res)
+			case reflect.Int:
+				n, _ := strconv.ParseInt(w, 16, 32)
+				value = reflect.ValueOf(int(n))
 			case reflect.Uint8:
 				n, _ := strconv.ParseInt(w, 16, 32)
 				res := byte(n)
 				value = reflect.ValueOf(res)
+			case reflect.String:
+				value = reflect.ValueOf(w)
 			case reflect.Float32:
 				n, _ := strconv.ParseFloat(w, 32)
 				res := float32(n)
@@ -179,6 +425,13 @@ func executor(t string) {
 	if t == "exit" {
 		cleanup()
 		os.Exit(0)
+	} else if words[0] == "oob" {
+		if len(words) < 3 {
+			logger.Error("usage: oob <uuid> <value>")
+		} else if err := inputOob(words[1], words[2]); err != nil {
+			logger.Error(err)
+		}
+		return
 	} else if t[:4] == "prov" {
 		provision(words[1])
 	}
@@ -219,6 +472,13 @@ func startRouter() {
 		callApi(c.Query("f"), strings.Split(params, ","))
 		c.Status(http.StatusOK)
 	})
+	router.GET("/oob", func(c *gin.Context) {
+		if err := inputOob(c.Query("uuid"), c.Query("v")); err != nil {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+		c.Status(http.StatusOK)
+	})
 	router.GET("/log", func(c *gin.Context) {
 		c.String(http.StatusOK, utils.ReadAllLogs())
 	})
@@ -243,6 +503,33 @@ func startRouter() {
 		}
 		c.JSON(http.StatusOK, db)
 	})
+	router.GET("/rpl", func(c *gin.Context) {
+		c.JSON(http.StatusOK, mesh.GetRplStats())
+	})
+	router.GET("/heartbeats", func(c *gin.Context) {
+		c.JSON(http.StatusOK, mesh.GetHeartbeats())
+	})
+	router.GET("/faults", func(c *gin.Context) {
+		c.JSON(http.StatusOK, mesh.GetFaultyNodes())
+	})
+	router.GET("/scenes", func(c *gin.Context) {
+		c.JSON(http.StatusOK, mesh.GetScenes())
+	})
+	router.GET("/timeauthority", func(c *gin.Context) {
+		c.JSON(http.StatusOK, mesh.GetTimeAuthorityStatus())
+	})
+	router.GET("/friends", func(c *gin.Context) {
+		c.JSON(http.StatusOK, mesh.GetFriendships())
+	})
+	router.GET("/lowpower", func(c *gin.Context) {
+		c.JSON(http.StatusOK, mesh.GetLowPowerStatus())
+	})
+	router.GET("/relay", func(c *gin.Context) {
+		c.JSON(http.StatusOK, mesh.GetRelayStats())
+	})
+	router.GET("/proxies", func(c *gin.Context) {
+		c.JSON(http.StatusOK, drv.GetProxyNodes())
+	})
 	router.GET("/getnode", func(c *gin.Context) {
 		node := utils.HexStringToUint(c.Query("n"))
 		if node != 0 {
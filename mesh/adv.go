@@ -39,7 +39,7 @@ func (b *AdvertisingBear) OnPduReceived(data []byte) {
 	payload := data[2 : lenMsg+1]
 	switch advType {
 	case BT_LE_ADV_PROVISION:
-		// PB-ADV
+		pbAdvReceive(payload)
 	case BT_LE_ADV_NETWORK:
 		networkReceive(payload)
 	case BT_LE_ADV_BEACON:
@@ -56,9 +56,17 @@ func (b *AdvertisingBear) SetMTU(mtu uint) {
 }
 
 func (b *AdvertisingBear) SendNetPdu(pdu []byte) {
+	b.send(BT_LE_ADV_NETWORK, pdu)
+}
+
+func (b *AdvertisingBear) SendBeaconPdu(pdu []byte) {
+	b.send(BT_LE_ADV_BEACON, pdu)
+}
+
+func (b *AdvertisingBear) send(advType byte, pdu []byte) {
 	if b.writeCb != nil {
 		lenAdv := len(pdu) + 1
-		packet := append([]byte{byte(lenAdv), BT_LE_ADV_NETWORK}, pdu...)
+		packet := append([]byte{byte(lenAdv), advType}, pdu...)
 		b.logger.Debugf("adv tx: % 2x", packet)
 		b.writeLock.Lock()
 
@@ -68,4 +76,6 @@ func (b *AdvertisingBear) SendNetPdu(pdu []byte) {
 }
 
 func (b *AdvertisingBear) SendProvPdu(pdu []byte) {
+	// a PB-ADV link is bound to one device, so provisioning over advertising
+	// is done through PbAdvBear, which shares this bearer for receiving
 }
@@ -0,0 +1,150 @@
+package mesh
+
+import (
+	"ble-mesh/mesh/crypto"
+	"ble-mesh/utils"
+	"sync"
+	"time"
+)
+
+// beaconState is the observation of the secure network beacons of a subnet
+type beaconState struct {
+	// beacons received in the current and the last observation period
+	observed     uint
+	lastObserved uint
+	lastSent     time.Time
+}
+
+const (
+	secureNetworkBeacon = 0x01
+
+	beaconMinInterval = 10 * time.Second
+	beaconMaxInterval = 600 * time.Second
+	// double the typical beacon interval
+	beaconObservationPeriod = 20 * time.Second
+	beaconAuthSize          = 8
+)
+
+var (
+	// key: net key index
+	beaconStates  = map[uint]*beaconState{}
+	beaconLock    sync.Mutex
+	beaconTrigger chan struct{}
+	beaconStop    chan struct{}
+)
+
+// genSecureNetworkBeacon generates the beacon of the subnet. The old key is used
+// in phase 1 of key refresh, the new key with the key refresh flag in phase 2.
+func genSecureNetworkBeacon(netKey *NetKey) ([]byte, error) {
+	key := netKey.txKey()
+	var keyRefresh uint
+	if netKey.KeyRefreshPhase == 2 {
+		keyRefresh = 1
+	}
+	beacon, err := utils.PackBE("06, 1, 1, 64, 32", meshDb.IVupdate, keyRefresh, key.NetworkId, meshDb.IVindex)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := crypto.AES_CMAC(key.BeaconKey, beacon)
+	if err != nil {
+		return nil, err
+	}
+	pdu := append([]byte{secureNetworkBeacon}, beacon...)
+	return append(pdu, auth[:beaconAuthSize]...), nil
+}
+
+// interval is the beacon interval: Observation Period * (Observed Number of Beacons + 1) / Expected Number of Beacons
+func (s *beaconState) interval() time.Duration {
+	expected := uint(beaconObservationPeriod / beaconMinInterval)
+	interval := beaconObservationPeriod * time.Duration(s.lastObserved+1) / time.Duration(expected)
+	if interval < beaconMinInterval {
+		return beaconMinInterval
+	}
+	if interval > beaconMaxInterval {
+		return beaconMaxInterval
+	}
+	return interval
+}
+
+func getBeaconState(index uint) *beaconState {
+	s, ok := beaconStates[index]
+	if !ok {
+		s = &beaconState{}
+		beaconStates[index] = s
+	}
+	return s
+}
+
+// beaconObserved counts an authenticated beacon of the subnet
+func beaconObserved(netKey *NetKey) {
+	beaconLock.Lock()
+	defer beaconLock.Unlock()
+	getBeaconState(netKey.Index).observed++
+}
+
+// sendBeacons sends the beacons of all subnets whose interval elapsed,
+// or all of them if force is set
+func sendBeacons(force bool) {
+	beaconLock.Lock()
+	defer beaconLock.Unlock()
+	now := time.Now()
+	for _, netKey := range meshDb.NetKeys {
+		s := getBeaconState(netKey.Index)
+		if !force && now.Sub(s.lastSent) < s.interval() {
+			continue
+		}
+		pdu, err := genSecureNetworkBeacon(netKey)
+		if err != nil {
+			loggerMesh.Error(err)
+			continue
+		}
+		loggerMesh.Debugf("beacon tx: % 2x", pdu)
+		netBear.SendBeaconPdu(pdu)
+		s.lastSent = now
+	}
+}
+
+func updateBeaconObservation() {
+	beaconLock.Lock()
+	defer beaconLock.Unlock()
+	for _, s := range beaconStates {
+		s.lastObserved, s.observed = s.observed, 0
+	}
+}
+
+// triggerBeacons sends the beacons immediately, e.g. when the IV index or
+// the key refresh phase changes
+func triggerBeacons() {
+	select {
+	case beaconTrigger <- struct{}{}:
+	default:
+	}
+}
+
+func startBeacon() {
+	beaconTrigger = make(chan struct{}, 1)
+	beaconStop = make(chan struct{})
+	go func(trigger, stop chan struct{}) {
+		tick := time.NewTicker(time.Second)
+		observation := time.NewTicker(beaconObservationPeriod)
+		defer tick.Stop()
+		defer observation.Stop()
+		sendBeacons(true)
+		for {
+			select {
+			case <-tick.C:
+				sendBeacons(false)
+			case <-observation.C:
+				updateBeaconObservation()
+			case <-trigger:
+				sendBeacons(true)
+			case <-stop:
+				return
+			}
+		}
+	}(beaconTrigger, beaconStop)
+}
+
+func stopBeacon() {
+	close(beaconStop)
+}
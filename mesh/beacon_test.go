@@ -0,0 +1,27 @@
+package mesh
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_genSecureNetworkBeacon(t *testing.T) {
+	// sample data of Mesh Profile 8.4.6.1
+	netKey := createNetKeyS("7dd7364cd842ad18c17c2b820c84c3d6", 0)
+	meshDb = &Mesh{IVindex: 0x12345678}
+	beacon, err := genSecureNetworkBeacon(netKey)
+	assert.Nil(t, err)
+	assert.Equal(t, "01003ecaff672f673370123456788ea261582f364f6f", hex.EncodeToString(beacon))
+}
+
+func Test_beaconInterval(t *testing.T) {
+	s := &beaconState{}
+	assert.Equal(t, beaconMinInterval, s.interval())
+	s.lastObserved = 3
+	assert.Equal(t, 40*time.Second, s.interval())
+	s.lastObserved = 100
+	assert.Equal(t, beaconMaxInterval, s.interval())
+}
@@ -9,5 +9,6 @@ type (
 		SetMTU(mtu uint)
 		SendNetPdu(pdu []byte)
 		SendProvPdu(pdu []byte)
+		SendBeaconPdu(pdu []byte)
 	}
 )
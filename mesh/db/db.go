@@ -6,14 +6,24 @@ import (
 )
 
 type Mesh struct {
-	MeshName       string      `json:"meshName"`
-	NetKeys        []NetKey    `json:"netKeys"`
-	AppKeys        []AppKey    `json:"appKeys"`
-	Groups         []Group     `json:"groups"`
-	Provisioner    Provisioner `json:"provisioner"`
-	IVindex        uint        `json:"IVindex"`
-	IVupdate       uint        `json:"IVupdate"`
-	SequenceNumber uint        `json:"sequenceNumber"`
+	MeshName                     string      `json:"meshName"`
+	NetKeys                      []NetKey    `json:"netKeys"`
+	AppKeys                      []AppKey    `json:"appKeys"`
+	Groups                       []Group     `json:"groups"`
+	Scenes                       []Scene     `json:"scenes"`
+	Provisioner                  Provisioner `json:"provisioner"`
+	IVindex                      uint        `json:"IVindex"`
+	IVupdate                     uint        `json:"IVupdate"`
+	IVupdateTime                 int64       `json:"IVupdateTime"`
+	IVrecoveryTime               int64       `json:"IVrecoveryTime"`
+	SequenceNumber               uint        `json:"sequenceNumber"`
+	Relay                        uint        `json:"relay"`
+	RelayRetransmitCount         uint        `json:"relayRetransmitCount"`
+	RelayRetransmitIntervalSteps uint        `json:"relayRetransmitIntervalSteps"`
+	NetworkTransmitCount         uint        `json:"networkTransmitCount"`
+	NetworkTransmitIntervalSteps uint        `json:"networkTransmitIntervalSteps"`
+	Friend                       uint        `json:"friend"`
+	FriendQueueSize              uint        `json:"friendQueueSize"`
 }
 type NetKey struct {
 	Index           uint   `json:"index"`
@@ -38,38 +48,59 @@ type Provisioner struct {
 }
 
 type Node struct {
-	DeviceKey                    string         `json:"deviceKey"`
-	BindedNetKeys                []BindedNetKey `json:"bindedNetKeys"`
-	UnicastAddress               string         `json:"unicastAddress"`
-	Elements                     []Element      `json:"elements"`
-	Cid                          int            `json:"cid"`
-	Pid                          int            `json:"pid"`
-	Vid                          int            `json:"vid"`
-	Crpl                         int            `json:"crpl"`
-	Features                     Features       `json:"features"`
-	SequenceNumber               uint           `json:"sequenceNumber"`
-	Mac                          string         `json:"mac"`
-	UUID                         string         `json:"uuid"`
-	LPN                          bool           `json:"lpn"`
-	Friend                       string         `json:"friend"`
-	TTL                          uint           `json:"ttl"`
-	Relay                        uint           `json:"relay"`
-	RelayRetransmitCount         uint           `json:"relayRetransmitCount"`
-	RelayRetransmitIntervalSteps uint           `json:"relayRetransmitIntervalSteps"`
-	AttentionTimer               uint           `json:"attentionTimer"`
-	SecureNetworkBeacon          bool           `json:"secureNetworkBeacon"`
-	GATTProxyState               uint           `json:"gattProxyState"`
-	FriendState                  uint           `json:"friendState"`
-	KeyRefreshPhaseState         uint           `json:"keyRefreshPhaseState"`
-	NetworkTransmitCount         uint           `json:"networkTransmitCount"`
-	NetworkTransmitIntervalSteps uint           `json:"networkTransmitIntervalSteps"`
-	CurrentFault                 uint           `json:"currentFault"`
+	DeviceKey                        string         `json:"deviceKey"`
+	BindedNetKeys                    []BindedNetKey `json:"bindedNetKeys"`
+	UnicastAddress                   string         `json:"unicastAddress"`
+	Elements                         []Element      `json:"elements"`
+	Cid                              int            `json:"cid"`
+	Pid                              int            `json:"pid"`
+	Vid                              int            `json:"vid"`
+	Crpl                             int            `json:"crpl"`
+	Features                         Features       `json:"features"`
+	SequenceNumber                   uint           `json:"sequenceNumber"`
+	Mac                              string         `json:"mac"`
+	UUID                             string         `json:"uuid"`
+	LPN                              bool           `json:"lpn"`
+	Friend                           string         `json:"friend"`
+	TTL                              uint           `json:"ttl"`
+	Relay                            uint           `json:"relay"`
+	RelayRetransmitCount             uint           `json:"relayRetransmitCount"`
+	RelayRetransmitIntervalSteps     uint           `json:"relayRetransmitIntervalSteps"`
+	AttentionTimer                   uint           `json:"attentionTimer"`
+	SecureNetworkBeacon              bool           `json:"secureNetworkBeacon"`
+	GATTProxyState                   uint           `json:"gattProxyState"`
+	FriendState                      uint           `json:"friendState"`
+	KeyRefreshPhaseState             uint           `json:"keyRefreshPhaseState"`
+	NetworkTransmitCount             uint           `json:"networkTransmitCount"`
+	NetworkTransmitIntervalSteps     uint           `json:"networkTransmitIntervalSteps"`
+	CurrentFault                     []FaultRecord  `json:"faultHistory"`
+	HeartbeatPublicationDestination  string         `json:"heartbeatPublicationDestination"`
+	HeartbeatPublicationCountLog     uint           `json:"heartbeatPublicationCountLog"`
+	HeartbeatPublicationPeriodLog    uint           `json:"heartbeatPublicationPeriodLog"`
+	HeartbeatPublicationTTL          uint           `json:"heartbeatPublicationTtl"`
+	HeartbeatPublicationFeatures     uint           `json:"heartbeatPublicationFeatures"`
+	HeartbeatPublicationNetKeyIndex  uint           `json:"heartbeatPublicationNetKeyIndex"`
+	HeartbeatSubscriptionSource      string         `json:"heartbeatSubscriptionSource"`
+	HeartbeatSubscriptionDestination string         `json:"heartbeatSubscriptionDestination"`
+	HeartbeatSubscriptionPeriodLog   uint           `json:"heartbeatSubscriptionPeriodLog"`
+}
+
+type FaultRecord struct {
+	Time      int64  `json:"time"`
+	TestID    uint   `json:"testId"`
+	CompanyID uint   `json:"companyId"`
+	Faults    []uint `json:"faults"`
 }
 
 type Group struct {
 	GroupAddress string `json:"groupAddress"`
 	Name         string `json:"name"`
 }
+type Scene struct {
+	Number    uint     `json:"number"`
+	Name      string   `json:"name"`
+	Addresses []string `json:"addresses"`
+}
 type BindedNetKey struct {
 	NetKeyIndex       uint   `json:"netKeyIndex"`
 	BindedAppKeys     []uint `json:"bindedAppKeys"`
@@ -105,6 +136,20 @@ type PubSetting struct {
 	PublishRetransmitIntervalSteps uint `json:"publishRetransmitIntervalSteps"`
 }
 
+type KeyRefresh struct {
+	NetKeyIndex   uint     `json:"netKeyIndex"`
+	AppKeyIndexes []uint   `json:"appKeyIndexes"`
+	Phase         uint     `json:"phase"`
+	Blacklist     []string `json:"blacklist"`
+	Done          []string `json:"done"`
+}
+
+type RplEntry struct {
+	Src     string `json:"src"`
+	IVindex uint   `json:"IVindex"`
+	Seq     uint   `json:"seq"`
+}
+
 func ReadFromDb(path string, obj interface{}) error {
 	jsonData, _ := ioutil.ReadFile(path)
 	err := json.Unmarshal(jsonData, obj)
@@ -725,9 +725,9 @@ type (
 
 	// Table 4.99: Health Fault Status message parameters
 	HealthFaultStatusMessageParameters struct {
-		TestID     uint `bits:"8"`  // Identifier of a most recently performed test
-		CompanyID  uint `bits:"16"` // 16-bit Bluetooth assigned Company Identifier
-		FaultArray uint `bits:"N"`  // The FaultArray field contains a sequence of 1-octet fault values
+		TestID     uint   `bits:"8"`  // Identifier of a most recently performed test
+		CompanyID  uint   `bits:"16"` // 16-bit Bluetooth assigned Company Identifier
+		FaultArray []uint `bits:"8"`  // The FaultArray field contains a sequence of 1-octet fault values
 	}
 
 	// Table 4.100: Health Period Set Unacknowledged message parameters
@@ -255,6 +255,16 @@ var (
 
 	// Table 4.21: Fault values
 	FaultValues = map[Range]string{
+		Range{0, 0}:     "No Fault",
+		Range{1, 1}:     "Battery Low Warning",
+		Range{2, 2}:     "Battery Low Error",
+		Range{3, 3}:     "Supply Voltage Too Low Warning",
+		Range{4, 4}:     "Supply Voltage Too Low Error",
+		Range{5, 5}:     "Supply Voltage Too High Warning",
+		Range{6, 6}:     "Supply Voltage Too High Error",
+		Range{7, 7}:     "Power Supply Interrupted Warning",
+		Range{8, 8}:     "Power Supply Interrupted Error",
+		Range{9, 9}:     "No Load Warning",
 		Range{10, 10}:   "No Load Error",
 		Range{11, 11}:   "Overload Warning",
 		Range{12, 12}:   "Overload Error",
@@ -0,0 +1,495 @@
+package mesh
+
+import (
+	"ble-mesh/mesh/crypto"
+	"ble-mesh/mesh/def"
+	"ble-mesh/utils"
+	"ble-mesh/utils/errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// friendship is the relationship with a Low Power node, the messages for it
+	// are stored in the friend queue until it polls
+	friendship struct {
+		lpn           uint
+		numElements   uint
+		lpnCounter    uint
+		friendCounter uint
+		netKey        *NetKey
+		// friendship credentials
+		cred         *NetKey
+		receiveDelay time.Duration
+		pollTimeout  time.Duration
+		timer        *time.Timer
+		established  bool
+		fsn          uint
+		// the response to the last poll, it is sent again until the low power
+		// node toggles the FSN
+		last          *friendPdu
+		queue         []*friendPdu
+		subscriptions map[uint]bool
+	}
+
+	// friendPdu is an entry of the friend queue, a stored message or a friend update
+	friendPdu struct {
+		msg *NetworkMessage
+		// parameters of the friend update, packed when it is taken from the queue
+		update []byte
+	}
+
+	FriendshipInfo struct {
+		LPN           uint
+		NumElements   uint
+		Established   bool
+		PollTimeout   time.Duration
+		QueueLength   int
+		Subscriptions []uint
+	}
+)
+
+const (
+	FRIEND_DISABLED = 0x00
+	FRIEND_ENABLED  = 0x01
+
+	friendPoll                    = 0x01
+	friendUpdate                  = 0x02
+	friendRequest                 = 0x03
+	friendOffer                   = 0x04
+	friendClear                   = 0x05
+	friendClearConfirm            = 0x06
+	friendSubscriptionListAdd     = 0x07
+	friendSubscriptionListRemove  = 0x08
+	friendSubscriptionListConfirm = 0x09
+
+	friendDefaultQueueSize = 16
+	// the time we listen for the friend poll of the low power node after the friend offer
+	friendReceiveWindow        = 100
+	friendSubscriptionListSize = 8
+	friendMinPollTimeout       = 0x00000A
+	friendMaxPollTimeout       = 0x34BC00
+	friendMinReceiveDelay      = 0x0A
+	// the low power node listens for the friend offer during 1 s, starting 100 ms after the request
+	friendMinOfferDelay      = 100 * time.Millisecond
+	friendEstablishmentDelay = time.Second
+)
+
+var (
+	loggerFriend = utils.CreateLogger("Friend")
+	// key: address of the low power node
+	friendships   = map[uint]*friendship{}
+	friendLock    sync.Mutex
+	friendCounter uint
+)
+
+// SetFriend sets the friend feature of the host node, queueSize is the number
+// of messages stored for every low power node
+func SetFriend(friend, queueSize uint) error {
+	if friend > FRIEND_ENABLED || queueSize < 2 || queueSize > 128 || queueSize&(queueSize-1) != 0 {
+		return errors.WrongFriendSetting.New().AddContextF("friend:%d, queue size:%d", friend, queueSize)
+	}
+	meshDb.Friend = friend
+	meshDb.FriendQueueSize = queueSize
+	writeMeshToDb()
+	if friend == FRIEND_DISABLED {
+		friendLock.Lock()
+		for _, f := range friendships {
+			f.terminate()
+		}
+		friendLock.Unlock()
+	}
+	return nil
+}
+
+func friendQueueSize() uint {
+	if meshDb.FriendQueueSize == 0 {
+		return friendDefaultQueueSize
+	}
+	return meshDb.FriendQueueSize
+}
+
+func GetFriendships() []FriendshipInfo {
+	friendLock.Lock()
+	defer friendLock.Unlock()
+	infos := []FriendshipInfo{}
+	for _, f := range friendships {
+		info := FriendshipInfo{LPN: f.lpn, NumElements: f.numElements, Established: f.established,
+			PollTimeout: f.pollTimeout, QueueLength: len(f.queue), Subscriptions: []uint{}}
+		for addr := range f.subscriptions {
+			info.Subscriptions = append(info.Subscriptions, addr)
+		}
+		sort.Slice(info.Subscriptions, func(i, j int) bool { return info.Subscriptions[i] < info.Subscriptions[j] })
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].LPN < infos[j].LPN })
+	return infos
+}
+
+// genFriendshipCredentials derives NID, EncryptionKey and PrivacyKey with
+// k2(NetKey, 0x01 || LPNAddress || FriendAddress || LPNCounter || FriendCounter)
+func genFriendshipCredentials(netKey *NetKey, lpn, friend, lpnCounter, friendCounter uint) (*NetKey, error) {
+	p, err := utils.PackBE("8, 16, 16, 16, 16", 0x01, lpn, friend, lpnCounter, friendCounter)
+	if err != nil {
+		return nil, err
+	}
+	nid, encryptionKey, privacyKey, err := crypto.K2(netKey.Bytes, p)
+	if err != nil {
+		return nil, err
+	}
+	return &NetKey{Index: netKey.Index, Bytes: netKey.Bytes, Nid: nid, EncryptionKey: encryptionKey, PrivacyKey: privacyKey}, nil
+}
+
+// friendshipsByNid returns the friendships whose credentials match the nid
+func friendshipsByNid(nid uint) []*friendship {
+	friendLock.Lock()
+	defer friendLock.Unlock()
+	ret := []*friendship{}
+	for _, f := range friendships {
+		if f.cred.Nid == nid {
+			ret = append(ret, f)
+		}
+	}
+	return ret
+}
+
+// friendOf returns the friendship of the low power node the address belongs to,
+// friendLock must be held
+func friendOf(addr uint) *friendship {
+	for _, f := range friendships {
+		if !f.established {
+			continue
+		}
+		if (addr >= f.lpn && addr < f.lpn+f.numElements) || f.subscriptions[addr] {
+			return f
+		}
+	}
+	return nil
+}
+
+func (f *friendship) restartTimer(d time.Duration) {
+	if f.timer != nil {
+		f.timer.Stop()
+	}
+	f.timer = time.AfterFunc(d, func() {
+		friendLock.Lock()
+		defer friendLock.Unlock()
+		if friendships[f.lpn] == f {
+			loggerFriend.Infof("friendship with %04x timed out", f.lpn)
+			f.terminate()
+		}
+	})
+}
+
+// terminate ends the friendship, friendLock must be held
+func (f *friendship) terminate() {
+	if f.timer != nil {
+		f.timer.Stop()
+	}
+	if friendships[f.lpn] == f {
+		delete(friendships, f.lpn)
+	}
+}
+
+// push stores the message in the friend queue, the oldest message is
+// discarded when the queue is full
+func (f *friendship) push(msg *NetworkMessage) {
+	if uint(len(f.queue)) >= friendQueueSize() {
+		for i, pdu := range f.queue {
+			// the friend updates are kept
+			if pdu.msg != nil {
+				f.queue = append(f.queue[:i], f.queue[i+1:]...)
+				break
+			}
+		}
+	}
+	f.queue = append(f.queue, &friendPdu{msg: msg})
+}
+
+// pushUpdate queues a friend update, it replaces the one not sent yet since
+// the update carries the flags when it is sent
+func (f *friendship) pushUpdate() {
+	for i, pdu := range f.queue {
+		if pdu.msg == nil {
+			f.queue = append(f.queue[:i], f.queue[i+1:]...)
+			break
+		}
+	}
+	f.queue = append(f.queue, &friendPdu{})
+}
+
+// friendQueueUpdates queues a friend update for the low power nodes of the
+// net keys, or of all the net keys if none is given, when the IV index or the
+// key refresh flags change
+func friendQueueUpdates(netKeyIndexes ...uint) {
+	friendLock.Lock()
+	defer friendLock.Unlock()
+	for _, f := range friendships {
+		if !f.established {
+			continue
+		}
+		if len(netKeyIndexes) == 0 {
+			f.pushUpdate()
+		}
+		for _, i := range netKeyIndexes {
+			if f.netKey.Index == i {
+				f.pushUpdate()
+			}
+		}
+	}
+}
+
+// friendQueue stores the message for a low power node, it returns false if the
+// destination is not one of our low power nodes
+func friendQueue(msg *NetworkMessage) bool {
+	if meshDb.Friend != FRIEND_ENABLED {
+		return false
+	}
+	friendLock.Lock()
+	defer friendLock.Unlock()
+	f := friendOf(msg.dst)
+	if f == nil || (msg.src >= f.lpn && msg.src < f.lpn+f.numElements) {
+		return false
+	}
+	m := *msg
+	f.push(&m)
+	return true
+}
+
+// friendRelay stores a received message for a low power node with TTL decremented
+func friendRelay(msg *NetworkMessage) {
+	if msg.ttl < 2 {
+		return
+	}
+	m := *msg
+	m.ttl--
+	if friendQueue(&m) {
+		loggerFriend.Debugf("stored message src:%04x, dst:%04x, seq:%06x", m.src, m.dst, m.seq)
+	}
+}
+
+// updateParams returns the friend update with the current IV index and key refresh
+// flags, friendLock must be held
+func (f *friendship) updateParams() ([]byte, error) {
+	var flags uint
+	// the key of the friendship is replaced during the key refresh
+	if netKey, err := findNetKeyByIndex(f.netKey.Index); err == nil && netKey.KeyRefreshPhase == 2 {
+		flags |= 0x01
+	}
+	if meshDb.IVupdate == ivUpdateInProgress {
+		flags |= 0x02
+	}
+	var md uint
+	if len(f.queue) > 0 {
+		md = 1
+	}
+	return utils.PackStructBE(&def.FriendUpdateMessageParameters{Flags: flags, IVIndex: meshDb.IVindex, MD: md})
+}
+
+// deliver sends the stored message with the friendship credentials, keeping its SEQ
+func (f *friendship) deliver(msg *NetworkMessage) error {
+	m := *msg
+	m.netKey = f.cred
+	m.nid = f.cred.Nid
+	pdu, err := networkPack(&m)
+	if err != nil {
+		return err
+	}
+	netBear.SendNetPdu(pdu)
+	return nil
+}
+
+// next takes the response to the poll from the queue, a friend update if the
+// queue is empty, friendLock must be held
+func (f *friendship) next() (*friendPdu, error) {
+	pdu := &friendPdu{}
+	if len(f.queue) > 0 {
+		pdu, f.queue = f.queue[0], f.queue[1:]
+	}
+	if pdu.msg != nil {
+		return pdu, nil
+	}
+	update, err := f.updateParams()
+	if err != nil {
+		return nil, err
+	}
+	return &friendPdu{update: update}, nil
+}
+
+func (f *friendship) send(pdu *friendPdu) error {
+	if pdu.msg != nil {
+		return f.deliver(pdu.msg)
+	}
+	return tpSendControlMsg(f.cred, friendUpdate, pdu.update, f.lpn, 0)
+}
+
+func (f *friendship) onPoll(fsn uint) {
+	first := !f.established
+	if first {
+		f.established = true
+		loggerFriend.Infof("friendship with %04x established", f.lpn)
+		if node, _ := findNodeByAddr(f.lpn); node != nil {
+			node.LPN = true
+		}
+	}
+	// the first poll is answered with a friend update, the last response is
+	// acknowledged when the FSN is toggled, it is sent again otherwise
+	if first || fsn != f.fsn || f.last == nil {
+		last, err := f.next()
+		if err != nil {
+			loggerFriend.Error(err)
+			return
+		}
+		f.last = last
+	}
+	f.fsn = fsn
+	f.restartTimer(f.pollTimeout)
+	last := f.last
+	time.AfterFunc(f.receiveDelay, func() {
+		if err := f.send(last); err != nil {
+			loggerFriend.Error(err)
+		}
+	})
+}
+
+// offerDelay is ReceiveWindowFactor * ReceiveWindow, at least 100 ms, the RSSI
+// of the request is not known
+func offerDelay(criteria uint) time.Duration {
+	factor := []time.Duration{10, 15, 20, 25}[(criteria>>3)&0x03]
+	d := factor * friendReceiveWindow * time.Millisecond / 10
+	if d < friendMinOfferDelay {
+		return friendMinOfferDelay
+	}
+	return d
+}
+
+func onFriendRequest(msg *NetworkMessage, params []byte) error {
+	req := &def.FriendRequestMessageParameters{}
+	if err := utils.UnpackStructBE(params, req); err != nil {
+		return err
+	}
+	if minQueueSize := uint(1) << (req.Criteria & 0x07); req.Criteria&0x07 == 0 || minQueueSize > friendQueueSize() {
+		return errors.InvalidResponse.New().AddContextF("friend request of %04x needs a queue of %d", msg.src, minQueueSize)
+	}
+	if req.ReceiveDelay < friendMinReceiveDelay || req.PollTimeout < friendMinPollTimeout || req.PollTimeout > friendMaxPollTimeout {
+		return errors.InvalidResponse.New().AddContextF("friend request: %+v", *req)
+	}
+	friendLock.Lock()
+	if f, ok := friendships[msg.src]; ok {
+		f.terminate()
+	}
+	counter := friendCounter
+	friendCounter = (friendCounter + 1) & 0xFFFF
+	friendLock.Unlock()
+	cred, err := genFriendshipCredentials(msg.netKey.txKey(), msg.src, meshDb.UnicastAddress, req.LPNCounter, counter)
+	if err != nil {
+		return err
+	}
+	f := &friendship{
+		lpn: msg.src, numElements: req.NumElements, lpnCounter: req.LPNCounter, friendCounter: counter,
+		netKey: msg.netKey, cred: cred, subscriptions: map[uint]bool{},
+		receiveDelay: time.Duration(req.ReceiveDelay) * time.Millisecond,
+		pollTimeout:  time.Duration(req.PollTimeout) * 100 * time.Millisecond,
+	}
+	delay := offerDelay(req.Criteria)
+	friendLock.Lock()
+	friendships[f.lpn] = f
+	// the friendship is dropped if the low power node doesn't poll
+	f.restartTimer(delay + friendEstablishmentDelay)
+	friendLock.Unlock()
+	offer, err := utils.PackStructBE(&def.FriendOfferParameters{
+		ReceiveWindow: friendReceiveWindow, QueueSize: friendQueueSize(),
+		SubscriptionListSize: friendSubscriptionListSize, FriendCounter: counter,
+	})
+	if err != nil {
+		return err
+	}
+	time.AfterFunc(delay, func() {
+		if err := tpSendControlMsg(f.netKey, friendOffer, offer, f.lpn, 0); err != nil {
+			loggerFriend.Error(err)
+		}
+	})
+	if req.PreviousAddress != UNASSIGNED_ADDRESS && req.PreviousAddress != meshDb.UnicastAddress {
+		clear, err := utils.PackStructBE(&def.FriendClearParameters{LPNAddress: f.lpn, LPNCounter: f.lpnCounter})
+		if err != nil {
+			return err
+		}
+		return tpSendControlMsg(f.netKey, friendClear, clear, req.PreviousAddress, 5)
+	}
+	return nil
+}
+
+// onFriendClear ends the friendship when the low power node found a new friend
+func onFriendClear(msg *NetworkMessage, params []byte) error {
+	clear := &def.FriendClearParameters{}
+	if err := utils.UnpackStructBE(params, clear); err != nil {
+		return err
+	}
+	friendLock.Lock()
+	f, ok := friendships[clear.LPNAddress]
+	// the counter of the new friendship must be within 255 of ours
+	if !ok || (clear.LPNCounter-f.lpnCounter)&0xFFFF > 255 {
+		friendLock.Unlock()
+		return nil
+	}
+	loggerFriend.Infof("friendship with %04x cleared by %04x", f.lpn, msg.src)
+	f.terminate()
+	friendLock.Unlock()
+	confirm, err := utils.PackStructBE(&def.FriendClearConfirmParameters{LPNAddress: clear.LPNAddress, LPNCounter: clear.LPNCounter})
+	if err != nil {
+		return err
+	}
+	return tpSendControlMsg(msg.netKey, friendClearConfirm, confirm, msg.src, 5)
+}
+
+func (f *friendship) onSubscriptionList(opcode uint, params []byte) error {
+	if len(params) < 1 || len(params)%2 != 1 {
+		return errors.DataLengthCheckFailed.New().AddContextF("subscription list: % 2x", params)
+	}
+	for i := 1; i < len(params); i += 2 {
+		addr := uint(params[i])<<8 | uint(params[i+1])
+		if opcode == friendSubscriptionListAdd && len(f.subscriptions) < friendSubscriptionListSize {
+			f.subscriptions[addr] = true
+		} else if opcode == friendSubscriptionListRemove {
+			delete(f.subscriptions, addr)
+		}
+	}
+	f.restartTimer(f.pollTimeout)
+	transaction := []byte{params[0]}
+	time.AfterFunc(f.receiveDelay, func() {
+		if err := tpSendControlMsg(f.cred, friendSubscriptionListConfirm, transaction, f.lpn, 0); err != nil {
+			loggerFriend.Error(err)
+		}
+	})
+	return nil
+}
+
+// friendControlReceive handles the transport control messages of the friendship
+func friendControlReceive(msg *NetworkMessage, opcode uint, params []byte) error {
+	if meshDb.Friend != FRIEND_ENABLED {
+		return nil
+	}
+	switch opcode {
+	case friendRequest:
+		return onFriendRequest(msg, params)
+	case friendClear:
+		return onFriendClear(msg, params)
+	case friendPoll, friendSubscriptionListAdd, friendSubscriptionListRemove:
+		friendLock.Lock()
+		defer friendLock.Unlock()
+		f, ok := friendships[msg.src]
+		if !ok {
+			return nil
+		}
+		if opcode == friendPoll {
+			if len(params) != 1 {
+				return errors.DataLengthCheckFailed.New().AddContextF("friend poll: % 2x", params)
+			}
+			f.onPoll(uint(params[0]) & 0x01)
+			return nil
+		}
+		return f.onSubscriptionList(opcode, params)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package mesh
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_genFriendshipCredentials(t *testing.T) {
+	// sample data of Mesh Profile 8.1.4, k2 function with friendship material
+	netKey := createNetKeyS("7dd7364cd842ad18c17c2b820c84c3d6", 0)
+	cred, err := genFriendshipCredentials(netKey, 0x1201, 0x2345, 0x0000, 0x072f)
+	assert.Nil(t, err)
+	assert.Equal(t, "be635105434859f484fc798e043ce40e", hex.EncodeToString(cred.EncryptionKey))
+	assert.Equal(t, "5d396d4b54d3cbafe943e051fe9a4eb8", hex.EncodeToString(cred.PrivacyKey))
+}
+
+func Test_friendQueue(t *testing.T) {
+	meshDb = &Mesh{UnicastAddress: 0x0001, Friend: FRIEND_ENABLED, FriendQueueSize: 2}
+	f := &friendship{lpn: 0x0100, numElements: 2, subscriptions: map[uint]bool{0xc000: true},
+		netKey: &NetKey{}, receiveDelay: time.Hour, pollTimeout: time.Hour}
+	friendships = map[uint]*friendship{f.lpn: f}
+	defer func() { friendships = map[uint]*friendship{} }()
+
+	// not established yet
+	assert.False(t, friendQueue(&NetworkMessage{src: 0x0200, dst: 0x0100}))
+	f.onPoll(0)
+	assert.True(t, f.established)
+	assert.Nil(t, f.last.msg)
+
+	assert.True(t, friendQueue(&NetworkMessage{src: 0x0200, dst: 0x0101, seq: 1}))
+	assert.True(t, friendQueue(&NetworkMessage{src: 0x0200, dst: 0xc000, seq: 2}))
+	assert.True(t, friendQueue(&NetworkMessage{src: 0x0200, dst: 0x0100, seq: 3}))
+	// from the low power node itself, or to another destination
+	assert.False(t, friendQueue(&NetworkMessage{src: 0x0101, dst: 0xc000}))
+	assert.False(t, friendQueue(&NetworkMessage{src: 0x0200, dst: 0x0102}))
+	// the oldest message is discarded
+	assert.Len(t, f.queue, 2)
+
+	f.onPoll(1)
+	assert.Equal(t, uint(2), f.last.msg.seq)
+	// not acknowledged, sent again
+	f.onPoll(1)
+	assert.Equal(t, uint(2), f.last.msg.seq)
+	f.onPoll(0)
+	assert.Equal(t, uint(3), f.last.msg.seq)
+	f.onPoll(1)
+	assert.Nil(t, f.last.msg)
+	update := f.last
+	// the friend update is lost, it is sent again instead of the next message
+	assert.True(t, friendQueue(&NetworkMessage{src: 0x0200, dst: 0x0100, seq: 4}))
+	f.onPoll(1)
+	assert.Equal(t, update, f.last)
+	f.onPoll(0)
+	assert.Equal(t, uint(4), f.last.msg.seq)
+	f.timer.Stop()
+}
+
+func Test_friendQueueUpdates(t *testing.T) {
+	meshDb = &Mesh{UnicastAddress: 0x0001, Friend: FRIEND_ENABLED, FriendQueueSize: 2, IVindex: 1}
+	f := &friendship{lpn: 0x0100, numElements: 1, subscriptions: map[uint]bool{},
+		netKey: &NetKey{}, receiveDelay: time.Hour, pollTimeout: time.Hour}
+	friendships = map[uint]*friendship{f.lpn: f}
+	defer func() { friendships = map[uint]*friendship{} }()
+	f.onPoll(0)
+
+	assert.True(t, friendQueue(&NetworkMessage{src: 0x0200, dst: 0x0100, seq: 1}))
+	// the IV update is queued after the stored message, a key refresh of another key is ignored
+	friendQueueUpdates(1)
+	assert.Len(t, f.queue, 1)
+	meshDb.IVindex, meshDb.IVupdate = 2, ivUpdateInProgress
+	friendQueueUpdates()
+	assert.Len(t, f.queue, 2)
+	// the friend update is kept when the queue is full
+	assert.True(t, friendQueue(&NetworkMessage{src: 0x0200, dst: 0x0100, seq: 2}))
+	assert.Len(t, f.queue, 2)
+	assert.Nil(t, f.queue[0].msg)
+
+	f.onPoll(1)
+	assert.Nil(t, f.last.msg)
+	// flags: IV update in progress, IV index 2, more data
+	assert.Equal(t, []byte{0x02, 0x00, 0x00, 0x00, 0x02, 0x01}, f.last.update)
+	f.onPoll(0)
+	assert.Equal(t, uint(2), f.last.msg.seq)
+	f.timer.Stop()
+}
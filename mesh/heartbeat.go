@@ -0,0 +1,193 @@
+package mesh
+
+import (
+	"ble-mesh/mesh/db"
+	"ble-mesh/mesh/def"
+	"ble-mesh/utils"
+	"ble-mesh/utils/errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// HeartbeatInfo is what we know about the heartbeats of a node
+	HeartbeatInfo struct {
+		Source uint
+		// hops of the last heartbeat, a neighbour is one hop away
+		Hops     uint
+		MinHops  uint
+		MaxHops  uint
+		Features db.Features
+		Count    uint
+		LastSeen time.Time
+		// the publication period configured on the node, 0 if unknown
+		Period time.Duration
+		Missed bool
+	}
+
+	HeartbeatEvent struct {
+		Type     uint
+		Source   uint
+		LastSeen time.Time
+	}
+)
+
+const (
+	// transport control opcode of the heartbeat message
+	heartbeatOpcode = 0x0A
+
+	HEARTBEAT_MISSED    = 0
+	HEARTBEAT_RECOVERED = 1
+
+	HEARTBEAT_FEATURE_RELAY     = 0x01
+	HEARTBEAT_FEATURE_PROXY     = 0x02
+	HEARTBEAT_FEATURE_FRIEND    = 0x04
+	HEARTBEAT_FEATURE_LOW_POWER = 0x08
+
+	// a node is missed after this many periods without heartbeat
+	heartbeatMissedPeriods = 3
+	heartbeatCheckInterval = time.Second
+)
+
+var (
+	loggerHeartbeat = utils.CreateLogger("Heartbeat")
+	heartbeatLock   sync.Mutex
+	heartbeats      = map[uint]*HeartbeatInfo{}
+	heartbeatStop   chan struct{}
+	heartbeatCb     func(*HeartbeatEvent)
+)
+
+// SetHeartbeatEventHandler sets the callback of the missed and recovered heartbeats
+func SetHeartbeatEventHandler(cb func(*HeartbeatEvent)) {
+	heartbeatLock.Lock()
+	defer heartbeatLock.Unlock()
+	heartbeatCb = cb
+}
+
+// GetHeartbeats returns the heartbeats received, sorted by source
+func GetHeartbeats() []HeartbeatInfo {
+	heartbeatLock.Lock()
+	defer heartbeatLock.Unlock()
+	infos := []HeartbeatInfo{}
+	for _, info := range heartbeats {
+		infos = append(infos, *info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Source < infos[j].Source })
+	return infos
+}
+
+// heartbeatPeriod decodes the PeriodLog of the heartbeat publication, 2^(n-1) seconds
+func heartbeatPeriod(periodLog uint) time.Duration {
+	if periodLog == 0 || periodLog > 0x11 {
+		return 0
+	}
+	return time.Duration(1<<(periodLog-1)) * time.Second
+}
+
+func heartbeatFeatures(features uint) db.Features {
+	return db.Features{
+		Relay:  features&HEARTBEAT_FEATURE_RELAY != 0,
+		Proxy:  features&HEARTBEAT_FEATURE_PROXY != 0,
+		Friend: features&HEARTBEAT_FEATURE_FRIEND != 0,
+		Lpn:    features&HEARTBEAT_FEATURE_LOW_POWER != 0,
+	}
+}
+
+// heartbeatReceive records the heartbeat control message
+func heartbeatReceive(msg *NetworkMessage, params []byte) error {
+	if len(params) != 3 {
+		return errors.DataLengthCheckFailed.New().AddContextF("heartbeat: % 2x", params)
+	}
+	hb := &def.HeartbeatParameters{}
+	if err := utils.UnpackStructBE(params, hb); err != nil {
+		return err
+	}
+	if hb.InitTTL < msg.ttl {
+		return errors.InvalidResponse.New().AddContextF("heartbeat from %04x: init TTL %d, TTL %d", msg.src, hb.InitTTL, msg.ttl)
+	}
+	hops := hb.InitTTL - msg.ttl + 1
+
+	var period time.Duration
+	if n, err := findNodeByAddr(msg.src); err == nil {
+		period = heartbeatPeriod(n.HeartbeatPublicationState.PeriodLog)
+	}
+
+	heartbeatLock.Lock()
+	info, ok := heartbeats[msg.src]
+	if !ok {
+		info = &HeartbeatInfo{Source: msg.src, MinHops: hops, MaxHops: hops}
+		heartbeats[msg.src] = info
+	}
+	if hops < info.MinHops {
+		info.MinHops = hops
+	}
+	if hops > info.MaxHops {
+		info.MaxHops = hops
+	}
+	info.Hops = hops
+	info.Features = heartbeatFeatures(hb.Features)
+	info.Count++
+	info.LastSeen = time.Now()
+	info.Period = period
+	var event *HeartbeatEvent
+	if info.Missed {
+		info.Missed = false
+		event = &HeartbeatEvent{Type: HEARTBEAT_RECOVERED, Source: msg.src, LastSeen: info.LastSeen}
+	}
+	cb := heartbeatCb
+	heartbeatLock.Unlock()
+
+	loggerHeartbeat.Debugf("heartbeat from %04x, hops %d, features %04x", msg.src, hops, hb.Features)
+	if event != nil {
+		loggerHeartbeat.Infof("heartbeat of %04x recovered", msg.src)
+		if cb != nil {
+			cb(event)
+		}
+	}
+	return nil
+}
+
+// heartbeatCheck raises an event for every node that missed its heartbeats
+func heartbeatCheck() {
+	now := time.Now()
+	events := []*HeartbeatEvent{}
+	heartbeatLock.Lock()
+	for _, info := range heartbeats {
+		if info.Missed || info.Period == 0 {
+			continue
+		}
+		if now.Sub(info.LastSeen) > heartbeatMissedPeriods*info.Period {
+			info.Missed = true
+			events = append(events, &HeartbeatEvent{Type: HEARTBEAT_MISSED, Source: info.Source, LastSeen: info.LastSeen})
+		}
+	}
+	cb := heartbeatCb
+	heartbeatLock.Unlock()
+	for _, event := range events {
+		loggerHeartbeat.Warnf("no heartbeat of %04x since %s", event.Source, event.LastSeen)
+		if cb != nil {
+			cb(event)
+		}
+	}
+}
+
+func startHeartbeat() {
+	heartbeatStop = make(chan struct{})
+	go func(stop chan struct{}) {
+		t := time.NewTicker(heartbeatCheckInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				heartbeatCheck()
+			case <-stop:
+				return
+			}
+		}
+	}(heartbeatStop)
+}
+
+func stopHeartbeat() {
+	close(heartbeatStop)
+}
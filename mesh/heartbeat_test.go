@@ -0,0 +1,39 @@
+package mesh
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_heartbeatReceive(t *testing.T) {
+	meshDb = &Mesh{Nodes: map[uint]*Node{}}
+	heartbeats = map[uint]*HeartbeatInfo{}
+	events := []*HeartbeatEvent{}
+	SetHeartbeatEventHandler(func(e *HeartbeatEvent) { events = append(events, e) })
+	defer SetHeartbeatEventHandler(nil)
+
+	// init TTL 5, relay and friend features
+	assert.Nil(t, heartbeatReceive(&NetworkMessage{src: 0x0100, ttl: 3}, []byte{0x05, 0x00, 0x05}))
+	assert.Nil(t, heartbeatReceive(&NetworkMessage{src: 0x0100, ttl: 5}, []byte{0x05, 0x00, 0x05}))
+	assert.NotNil(t, heartbeatReceive(&NetworkMessage{src: 0x0100, ttl: 6}, []byte{0x05, 0x00, 0x05}))
+	info := GetHeartbeats()[0]
+	assert.Equal(t, uint(1), info.Hops)
+	assert.Equal(t, uint(1), info.MinHops)
+	assert.Equal(t, uint(3), info.MaxHops)
+	assert.Equal(t, uint(2), info.Count)
+	assert.True(t, info.Features.Relay)
+	assert.True(t, info.Features.Friend)
+	assert.False(t, info.Features.Proxy)
+
+	heartbeats[0x0100].Period = heartbeatPeriod(2)
+	heartbeats[0x0100].LastSeen = time.Now().Add(-10 * time.Second)
+	heartbeatCheck()
+	heartbeatCheck()
+	assert.Len(t, events, 1)
+	assert.Equal(t, uint(HEARTBEAT_MISSED), events[0].Type)
+	assert.Nil(t, heartbeatReceive(&NetworkMessage{src: 0x0100, ttl: 5}, []byte{0x05, 0x00, 0x05}))
+	assert.Len(t, events, 2)
+	assert.Equal(t, uint(HEARTBEAT_RECOVERED), events[1].Type)
+}
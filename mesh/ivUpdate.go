@@ -0,0 +1,168 @@
+package mesh
+
+import (
+	"ble-mesh/utils/errors"
+	"sync"
+	"time"
+)
+
+const (
+	ivUpdateNormal     = 0
+	ivUpdateInProgress = 1
+
+	// minimum duration of both states of the IV Update procedure
+	ivUpdateMinDuration = 96 * time.Hour
+	// at most one IV Index Recovery within this period
+	ivRecoveryInterval = 192 * time.Hour
+	// IV Index Recovery is not allowed if the IV index is greater than ours plus this limit
+	ivRecoveryLimit = 42
+	// the IV Update procedure is initiated once our SEQ exceeds this threshold,
+	// leaving enough sequence numbers for the 96 hours in progress state
+	ivUpdateSeqThreshold  = 0x800000
+	ivUpdateCheckInterval = time.Minute
+)
+
+var (
+	ivUpdateLock sync.Mutex
+	ivUpdateStop chan struct{}
+	// the clock of the IV Update procedure, replaced by tests
+	ivClock = time.Now
+)
+
+// SetIvUpdateClock sets the clock used to check the duration of the IV Update states
+func SetIvUpdateClock(clock func() time.Time) {
+	ivUpdateLock.Lock()
+	defer ivUpdateLock.Unlock()
+	ivClock = clock
+}
+
+// txIvIndex returns the IV index used to transmit, during the IV Update
+// in progress state messages are still sent with the old IV index
+func txIvIndex() uint {
+	if meshDb.IVupdate == ivUpdateInProgress {
+		return meshDb.IVindex - 1
+	}
+	return meshDb.IVindex
+}
+
+// setIvIndex moves to the new IV index and IV Update state, SEQ starts over
+// when the IV index used to transmit changes
+func setIvIndex(ivIndex, ivUpdate uint) {
+	oldTxIvIndex := txIvIndex()
+	meshDb.IVindex = ivIndex
+	meshDb.IVupdate = ivUpdate
+	meshDb.IVupdateTime = ivClock()
+	if txIvIndex() != oldTxIvIndex {
+		seqLock.Lock()
+		meshDb.SequenceNumber = 0
+		seqLock.Unlock()
+	}
+	loggerMesh.Infof("IV index %d, IV update flag %d, SEQ %06x", meshDb.IVindex, meshDb.IVupdate, txSeq())
+	writeMeshToDb()
+	triggerBeacons()
+	friendQueueUpdates()
+}
+
+func ivUpdateStateDuration() time.Duration {
+	return ivClock().Sub(meshDb.IVupdateTime)
+}
+
+// StartIvUpdate initiates the IV Update procedure
+func StartIvUpdate() error {
+	ivUpdateLock.Lock()
+	defer ivUpdateLock.Unlock()
+	return ivUpdateInitiate()
+}
+
+func ivUpdateInitiate() error {
+	if meshDb.IVupdate == ivUpdateInProgress {
+		return errors.IvUpdateNotAllowed.New().AddContext("iv update is in progress")
+	}
+	if d := ivUpdateStateDuration(); d < ivUpdateMinDuration {
+		return errors.IvUpdateNotAllowed.New().AddContextF("normal operation for %s only", d)
+	}
+	setIvIndex(meshDb.IVindex+1, ivUpdateInProgress)
+	return nil
+}
+
+func ivUpdateComplete() error {
+	if meshDb.IVupdate == ivUpdateNormal {
+		return errors.IvUpdateNotAllowed.New().AddContext("iv update is not in progress")
+	}
+	if d := ivUpdateStateDuration(); d < ivUpdateMinDuration {
+		return errors.IvUpdateNotAllowed.New().AddContextF("iv update in progress for %s only", d)
+	}
+	setIvIndex(meshDb.IVindex, ivUpdateNormal)
+	return nil
+}
+
+// ivIndexRecovery takes the IV index of the network when we fell behind,
+// e.g. after being off for a long time
+func ivIndexRecovery(ivIndex, ivUpdate uint) error {
+	if ivIndex > meshDb.IVindex+ivRecoveryLimit {
+		return errors.IvUpdateNotAllowed.New().AddContextF("iv index %d is too far from %d", ivIndex, meshDb.IVindex)
+	}
+	if d := ivClock().Sub(meshDb.IVrecoveryTime); d < ivRecoveryInterval {
+		return errors.IvUpdateNotAllowed.New().AddContextF("last iv index recovery %s ago", d)
+	}
+	meshDb.IVrecoveryTime = ivClock()
+	loggerMesh.Infof("IV index recovery from %d to %d", meshDb.IVindex, ivIndex)
+	setIvIndex(ivIndex, ivUpdate)
+	return nil
+}
+
+// ivUpdateOnBeacon follows the IV index and IV Update flag of an authenticated secure network beacon
+func ivUpdateOnBeacon(ivIndex, ivUpdate uint) {
+	ivUpdateLock.Lock()
+	defer ivUpdateLock.Unlock()
+	var err error
+	switch {
+	case ivIndex < meshDb.IVindex:
+		// stale beacon
+	case ivIndex == meshDb.IVindex:
+		if meshDb.IVupdate == ivUpdateInProgress && ivUpdate == ivUpdateNormal {
+			err = ivUpdateComplete()
+		}
+	case ivIndex == meshDb.IVindex+1 && ivUpdate == ivUpdateInProgress && meshDb.IVupdate == ivUpdateNormal:
+		err = ivUpdateInitiate()
+	default:
+		err = ivIndexRecovery(ivIndex, ivUpdate)
+	}
+	if err != nil {
+		loggerMesh.Warnf("ignored beacon with IV index %d, IV update flag %d, error: %s", ivIndex, ivUpdate, err)
+	}
+}
+
+// ivUpdateCheck moves to the next state of the IV Update procedure when
+// our SEQ is running out or the in progress state lasted long enough
+func ivUpdateCheck() {
+	ivUpdateLock.Lock()
+	defer ivUpdateLock.Unlock()
+	if meshDb.IVupdate == ivUpdateInProgress {
+		ivUpdateComplete()
+	} else if seq := txSeq(); seq >= ivUpdateSeqThreshold {
+		if err := ivUpdateInitiate(); err != nil {
+			loggerMesh.Warnf("SEQ %06x exceeds threshold, but %s", seq, err)
+		}
+	}
+}
+
+func startIvUpdate() {
+	ivUpdateStop = make(chan struct{})
+	go func(stop chan struct{}) {
+		t := time.NewTicker(ivUpdateCheckInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				ivUpdateCheck()
+			case <-stop:
+				return
+			}
+		}
+	}(ivUpdateStop)
+}
+
+func stopIvUpdate() {
+	close(ivUpdateStop)
+}
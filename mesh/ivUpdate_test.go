@@ -0,0 +1,70 @@
+package mesh
+
+import (
+	"ble-mesh/mesh/db"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ivUpdate(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "ivupdate")
+	defer os.RemoveAll(dir)
+	confDir = dir
+	meshDbRaw = &db.Mesh{}
+	now := time.Unix(1000000, 0)
+	ivClock = func() time.Time { return now }
+	meshDb = &Mesh{IVindex: 5, IVupdateTime: now, SequenceNumber: ivUpdateSeqThreshold}
+
+	// too early after the last transition
+	ivUpdateCheck()
+	assert.Equal(t, uint(ivUpdateNormal), meshDb.IVupdate)
+
+	now = now.Add(ivUpdateMinDuration)
+	ivUpdateCheck()
+	assert.Equal(t, uint(6), meshDb.IVindex)
+	assert.Equal(t, uint(ivUpdateInProgress), meshDb.IVupdate)
+	assert.Equal(t, uint(5), txIvIndex())
+	// still sending with IV index 5
+	assert.Equal(t, uint(ivUpdateSeqThreshold), meshDb.SequenceNumber)
+
+	now = now.Add(time.Hour)
+	ivUpdateOnBeacon(6, ivUpdateNormal)
+	assert.Equal(t, uint(ivUpdateInProgress), meshDb.IVupdate)
+
+	now = now.Add(ivUpdateMinDuration)
+	ivUpdateOnBeacon(6, ivUpdateNormal)
+	assert.Equal(t, uint(ivUpdateNormal), meshDb.IVupdate)
+	assert.Equal(t, uint(6), txIvIndex())
+	assert.Equal(t, uint(0), meshDb.SequenceNumber)
+	assert.Equal(t, uint(6), meshDbRaw.IVindex)
+}
+
+func Test_ivIndexRecovery(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "ivupdate")
+	defer os.RemoveAll(dir)
+	confDir = dir
+	meshDbRaw = &db.Mesh{}
+	now := time.Unix(1000000, 0)
+	ivClock = func() time.Time { return now }
+	meshDb = &Mesh{IVindex: 5, IVupdateTime: now, SequenceNumber: 100}
+
+	ivUpdateOnBeacon(5+ivRecoveryLimit+1, ivUpdateNormal)
+	assert.Equal(t, uint(5), meshDb.IVindex)
+
+	ivUpdateOnBeacon(10, ivUpdateInProgress)
+	assert.Equal(t, uint(10), meshDb.IVindex)
+	assert.Equal(t, uint(9), txIvIndex())
+	assert.Equal(t, uint(0), meshDb.SequenceNumber)
+
+	// only one recovery within 192 hours
+	now = now.Add(ivUpdateMinDuration)
+	ivUpdateOnBeacon(20, ivUpdateNormal)
+	assert.Equal(t, uint(10), meshDb.IVindex)
+	now = now.Add(ivUpdateMinDuration)
+	ivUpdateOnBeacon(20, ivUpdateNormal)
+	assert.Equal(t, uint(20), meshDb.IVindex)
+}
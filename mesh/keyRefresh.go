@@ -0,0 +1,401 @@
+package mesh
+
+import (
+	"ble-mesh/mesh/db"
+	"ble-mesh/utils"
+	"ble-mesh/utils/errors"
+	"os"
+	"path"
+	"sort"
+	"sync"
+
+	funk "github.com/thoas/go-funk"
+)
+
+// keyRefresh is the progress of the Key Refresh procedure of a net key and
+// its app keys, it is persisted after every step so that it can be resumed
+type keyRefresh struct {
+	netKeyIndex   uint
+	appKeyIndexes []uint
+	// 1: distributing the new keys, 2: switching to the new keys, 3: revoking the old keys
+	phase uint
+	// nodes which don't get the new keys and are removed from the net key at the end
+	blacklist []uint
+	// nodes which finished the current phase
+	done    []uint
+	running bool
+}
+
+var (
+	// key: net key index
+	keyRefreshes   = map[uint]*keyRefresh{}
+	keyRefreshLock sync.Mutex
+)
+
+// txKey returns the key to transmit with, the old key is used until phase 2
+func (k *NetKey) txKey() *NetKey {
+	if k.KeyRefreshPhase == 1 && k.OldKey != nil {
+		return k.OldKey
+	}
+	return k
+}
+
+// rxKeys returns the keys to receive with, both keys are used during key refresh
+func (k *NetKey) rxKeys() []*NetKey {
+	if k.OldKey != nil {
+		return []*NetKey{k, k.OldKey}
+	}
+	return []*NetKey{k}
+}
+
+func (k *AppKey) txKey() *AppKey {
+	if k.KeyRefreshPhase == 1 && k.OldKey != nil {
+		return k.OldKey
+	}
+	return k
+}
+
+// rxKeys returns the keys matching the aid, both keys are used during key refresh
+func (k *AppKey) rxKeys(aid uint) []*AppKey {
+	keys := []*AppKey{}
+	if k.Aid == aid {
+		keys = append(keys, k)
+	}
+	if k.OldKey != nil && k.OldKey.Aid == aid {
+		keys = append(keys, k.OldKey)
+	}
+	return keys
+}
+
+func keyRefreshPath() string {
+	return path.Join(confDir, "keyRefresh.json")
+}
+
+func hexAddrs(addrs []uint) []string {
+	ret := []string{}
+	for _, a := range addrs {
+		ret = append(ret, utils.UintToHexString(a))
+	}
+	return ret
+}
+
+func uintAddrs(addrs []string) []uint {
+	ret := []uint{}
+	for _, a := range addrs {
+		ret = append(ret, utils.HexStringToUint(a))
+	}
+	return ret
+}
+
+func loadKeyRefreshes() {
+	raw := []db.KeyRefresh{}
+	if _, err := os.Stat(keyRefreshPath()); os.IsNotExist(err) {
+		return
+	}
+	if err := db.ReadFromDb(keyRefreshPath(), &raw); err != nil {
+		loggerMesh.Errorf("failed to read key refresh progress, error: %s", err)
+		return
+	}
+	keyRefreshLock.Lock()
+	defer keyRefreshLock.Unlock()
+	for _, r := range raw {
+		keyRefreshes[r.NetKeyIndex] = &keyRefresh{
+			netKeyIndex:   r.NetKeyIndex,
+			appKeyIndexes: r.AppKeyIndexes,
+			phase:         r.Phase,
+			blacklist:     uintAddrs(r.Blacklist),
+			done:          uintAddrs(r.Done),
+		}
+	}
+}
+
+// writeKeyRefreshesToDb should be called with keyRefreshLock held
+func writeKeyRefreshesToDb() {
+	raw := []db.KeyRefresh{}
+	for _, kr := range keyRefreshes {
+		raw = append(raw, db.KeyRefresh{
+			NetKeyIndex:   kr.netKeyIndex,
+			AppKeyIndexes: kr.appKeyIndexes,
+			Phase:         kr.phase,
+			Blacklist:     hexAddrs(kr.blacklist),
+			Done:          hexAddrs(kr.done),
+		})
+	}
+	if err := db.WriteToDb(keyRefreshPath(), raw); err != nil {
+		loggerMesh.Errorf("failed to write key refresh progress, error: %s", err)
+	}
+}
+
+func (kr *keyRefresh) save() {
+	keyRefreshLock.Lock()
+	defer keyRefreshLock.Unlock()
+	writeKeyRefreshesToDb()
+}
+
+// nodes returns the nodes which get the new keys, in order of address
+func (kr *keyRefresh) nodes() []*Node {
+	nodes := []*Node{}
+	for _, n := range meshDb.Nodes {
+		if _, err := n.findNodeNetKeyByIndex(kr.netKeyIndex); err != nil {
+			continue
+		}
+		if !funk.Contains(kr.blacklist, n.UnicastAddress) {
+			nodes = append(nodes, n)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].UnicastAddress < nodes[j].UnicastAddress })
+	return nodes
+}
+
+// setPhase sets the local phase of the net key and its app keys
+func (kr *keyRefresh) setPhase(phase uint) {
+	netKey, _ := findNetKeyByIndex(kr.netKeyIndex)
+	netKey.KeyRefreshPhase = phase
+	for _, i := range kr.appKeyIndexes {
+		if appKey, err := findAppKeyByIndex(i); err == nil {
+			appKey.KeyRefreshPhase = phase
+		}
+	}
+	writeMeshToDb()
+	triggerBeacons()
+	friendQueueUpdates(kr.netKeyIndex)
+}
+
+// createKeys generates the new net key and app keys, the old ones are kept
+// for receiving until phase 3
+func (kr *keyRefresh) createKeys() error {
+	oldNetKey, err := findNetKeyByIndex(kr.netKeyIndex)
+	if err != nil {
+		return err
+	}
+	newNetKey := createNetKey(kr.netKeyIndex)
+	newNetKey.OldKey = oldNetKey
+	oldNetKey.NewKey = newNetKey
+	meshDb.NetKeys[kr.netKeyIndex] = newNetKey
+	for _, i := range kr.appKeyIndexes {
+		oldAppKey, err := findAppKeyByIndex(i)
+		if err != nil {
+			return err
+		}
+		newAppKey := createAppKey(i)
+		newAppKey.OldKey = oldAppKey
+		oldAppKey.NewKey = newAppKey
+		meshDb.AppKeys[i] = newAppKey
+	}
+	kr.setPhase(1)
+	return nil
+}
+
+func (kr *keyRefresh) revokeOldKeys() {
+	netKey, _ := findNetKeyByIndex(kr.netKeyIndex)
+	netKey.OldKey = nil
+	for _, i := range kr.appKeyIndexes {
+		if appKey, err := findAppKeyByIndex(i); err == nil {
+			appKey.OldKey = nil
+		}
+	}
+	kr.setPhase(0)
+}
+
+// evict removes the net key and its app keys from the blacklisted nodes,
+// the nodes without any net key are removed from the network
+func (kr *keyRefresh) evict() {
+	for _, addr := range kr.blacklist {
+		node, err := findNodeByAddr(addr)
+		if err != nil {
+			continue
+		}
+		for i, b := range node.BindedKeys {
+			if b.NetKeyIndex == kr.netKeyIndex {
+				node.BindedKeys = append(node.BindedKeys[:i], node.BindedKeys[i+1:]...)
+				break
+			}
+		}
+		if len(node.BindedKeys) > 0 {
+			writeNodeToDb(node)
+			continue
+		}
+		loggerMesh.Infof("node %04x is evicted", addr)
+		delete(meshDb.Nodes, addr)
+		deleteNode(addr)
+		rplReset(addr, len(node.Elements))
+	}
+}
+
+// updateNode distributes the new net key and the app keys bound to the node
+func (kr *keyRefresh) updateNode(n *Node) error {
+	if err := ConfigNetKeyUpdate(n.UnicastAddress, kr.netKeyIndex); err != nil {
+		return err
+	}
+	binding, err := n.findNodeKeyBindingByNetKeyIndex(kr.netKeyIndex)
+	if err != nil {
+		return err
+	}
+	for _, i := range binding.BindedAppKeyIds {
+		if err := ConfigAppKeyUpdate(n.UnicastAddress, kr.netKeyIndex, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run drives the procedure from the persisted phase to the end.
+// All nodes must get the new keys in phase 1, otherwise the procedure stops
+// and can be resumed, e.g. after blacklisting the unreachable nodes. A node missing
+// the phase transitions follows the key refresh flag of the secure network beacons.
+func (kr *keyRefresh) run() error {
+	netKey, err := findNetKeyByIndex(kr.netKeyIndex)
+	if err != nil {
+		return err
+	}
+	if kr.phase == 1 && netKey.OldKey == nil {
+		if err := kr.createKeys(); err != nil {
+			return err
+		}
+	}
+	for {
+		failed := []uint{}
+		for _, n := range kr.nodes() {
+			if funk.Contains(kr.done, n.UnicastAddress) {
+				continue
+			}
+			var err error
+			if kr.phase == 1 {
+				err = kr.updateNode(n)
+			} else {
+				err = ConfigKeyRefreshPhaseSet(n.UnicastAddress, kr.netKeyIndex, kr.phase)
+			}
+			if err != nil {
+				loggerMesh.Errorf("key refresh phase %d of net key %d failed on node %04x, error: %s",
+					kr.phase, kr.netKeyIndex, n.UnicastAddress, err)
+				failed = append(failed, n.UnicastAddress)
+				continue
+			}
+			kr.done = append(kr.done, n.UnicastAddress)
+			kr.save()
+		}
+		if kr.phase == 1 && len(failed) > 0 {
+			return errors.KeyRefreshFailed.New().AddContextF("netkeyIndex:%d, nodes without new keys: %x", kr.netKeyIndex, failed)
+		}
+		loggerMesh.Infof("key refresh phase %d of net key %d end", kr.phase, kr.netKeyIndex)
+		kr.done = []uint{}
+		switch kr.phase {
+		case 1:
+			kr.phase = 2
+			kr.setPhase(2)
+		case 2:
+			kr.phase = 3
+		case 3:
+			kr.revokeOldKeys()
+			kr.evict()
+			keyRefreshLock.Lock()
+			delete(keyRefreshes, kr.netKeyIndex)
+			writeKeyRefreshesToDb()
+			keyRefreshLock.Unlock()
+			return nil
+		}
+		kr.save()
+	}
+}
+
+func (kr *keyRefresh) runOnce() error {
+	keyRefreshLock.Lock()
+	if kr.running {
+		keyRefreshLock.Unlock()
+		return errors.InvalidKeyRefreshPhase.New().AddContextF("key refresh of net key %d is running", kr.netKeyIndex)
+	}
+	kr.running = true
+	keyRefreshLock.Unlock()
+	defer func() {
+		keyRefreshLock.Lock()
+		kr.running = false
+		keyRefreshLock.Unlock()
+	}()
+	return kr.run()
+}
+
+// startKeyRefresh refreshes the net key and all app keys bound to it, the
+// blacklisted nodes lose access to the net key
+func startKeyRefresh(netKeyIndex uint, blacklist []uint) error {
+	if _, err := findNetKeyByIndex(netKeyIndex); err != nil {
+		return err
+	}
+	keyRefreshLock.Lock()
+	if kr, ok := keyRefreshes[netKeyIndex]; ok {
+		// nodes can still be blacklisted before they get the new keys
+		for _, addr := range blacklist {
+			if kr.phase != 1 || funk.Contains(kr.done, addr) {
+				keyRefreshLock.Unlock()
+				return errors.InvalidKeyRefreshPhase.New().AddContextF("node %04x already has the new keys of net key %d", addr, netKeyIndex)
+			}
+			if !funk.Contains(kr.blacklist, addr) {
+				kr.blacklist = append(kr.blacklist, addr)
+			}
+		}
+		writeKeyRefreshesToDb()
+		keyRefreshLock.Unlock()
+		return kr.runOnce()
+	}
+	kr := &keyRefresh{
+		netKeyIndex:   netKeyIndex,
+		appKeyIndexes: []uint{},
+		phase:         1,
+		blacklist:     blacklist,
+		done:          []uint{},
+	}
+	for _, n := range meshDb.Nodes {
+		if b, err := n.findNodeKeyBindingByNetKeyIndex(netKeyIndex); err == nil {
+			for _, i := range b.BindedAppKeyIds {
+				if !funk.Contains(kr.appKeyIndexes, i) {
+					kr.appKeyIndexes = append(kr.appKeyIndexes, i)
+				}
+			}
+		}
+	}
+	keyRefreshes[netKeyIndex] = kr
+	writeKeyRefreshesToDb()
+	keyRefreshLock.Unlock()
+	return kr.runOnce()
+}
+
+// RefreshNetKey runs the Key Refresh procedure for the net key and its app keys,
+// or resumes it if it is in progress
+func RefreshNetKey(index uint) error {
+	return startKeyRefresh(index, []uint{})
+}
+
+// EvictNode refreshes all net keys of the node without giving it the new keys,
+// e.g. to remove a decommissioned or compromised device from the network
+func EvictNode(addr uint) error {
+	node, err := findNodeByAddr(addr)
+	if err != nil {
+		return err
+	}
+	indexes := []uint{}
+	for _, b := range node.BindedKeys {
+		indexes = append(indexes, b.NetKeyIndex)
+	}
+	for _, i := range indexes {
+		if err := startKeyRefresh(i, []uint{addr}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResumeKeyRefresh continues the Key Refresh procedures interrupted, e.g. by a restart
+func ResumeKeyRefresh() error {
+	keyRefreshLock.Lock()
+	krs := []*keyRefresh{}
+	for _, kr := range keyRefreshes {
+		krs = append(krs, kr)
+	}
+	keyRefreshLock.Unlock()
+	for _, kr := range krs {
+		loggerMesh.Infof("resume key refresh of net key %d in phase %d", kr.netKeyIndex, kr.phase)
+		if err := kr.runOnce(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package mesh
+
+import (
+	"ble-mesh/mesh/db"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_keyRefreshNetworkKeys(t *testing.T) {
+	oldKey := createNetKey(0)
+	newKey := createNetKey(0)
+	newKey.OldKey = oldKey
+	oldKey.NewKey = newKey
+	newKey.KeyRefreshPhase = 1
+	meshDb = &Mesh{NetKeys: map[uint]*NetKey{0: newKey}, Nodes: map[uint]*Node{}, IVindex: 1}
+
+	msg := &NetworkMessage{ttl: 5, seq: 1, src: 0x0001, dst: 0x0100, plain: []byte{1, 2, 3, 4}, ivIndex: 1, netKey: newKey}
+	// sent with the old key in phase 1
+	pdu, err := networkPack(msg)
+	assert.Nil(t, err)
+	assert.Equal(t, oldKey.Nid, uint(pdu[0]&0x7F))
+	rx, err := networkUnpack(pdu)
+	assert.Nil(t, err)
+	assert.Equal(t, newKey, rx.netKey)
+	assert.Equal(t, msg.plain, rx.plain)
+
+	// sent with the new key from phase 2
+	newKey.KeyRefreshPhase = 2
+	msg.seq = 2
+	pdu, _ = networkPack(msg)
+	assert.Equal(t, newKey.Nid, uint(pdu[0]&0x7F))
+	rx, err = networkUnpack(pdu)
+	assert.Nil(t, err)
+	assert.Equal(t, msg.plain, rx.plain)
+}
+
+func Test_keyRefreshPersistence(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "keyrefresh")
+	defer os.RemoveAll(dir)
+	confDir = dir
+	keyRefreshes = map[uint]*keyRefresh{
+		0: {netKeyIndex: 0, appKeyIndexes: []uint{0, 1}, phase: 2, blacklist: []uint{0x0100}, done: []uint{0x0200}},
+	}
+	writeKeyRefreshesToDb()
+	raw := []db.KeyRefresh{}
+	db.ReadFromDb(keyRefreshPath(), &raw)
+	assert.Equal(t, []string{"100"}, raw[0].Blacklist)
+
+	keyRefreshes = map[uint]*keyRefresh{}
+	loadKeyRefreshes()
+	assert.Equal(t, &keyRefresh{netKeyIndex: 0, appKeyIndexes: []uint{0, 1}, phase: 2, blacklist: []uint{0x0100}, done: []uint{0x0200}}, keyRefreshes[0])
+}
@@ -0,0 +1,455 @@
+package mesh
+
+import (
+	"ble-mesh/mesh/def"
+	"ble-mesh/utils"
+	"ble-mesh/utils/errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// lowPower is the state of the host node running as a Low Power node
+	lowPower struct {
+		pollInterval time.Duration
+		netKey       *NetKey
+		lpnCounter   uint
+		// the friend and the friendship credentials, established if cred is set
+		friend        uint
+		friendCounter uint
+		receiveWindow time.Duration
+		cred          *NetKey
+		fsn           uint
+		subscriptions map[uint]bool
+		transaction   uint
+
+		offers    chan *lpnOffer
+		responses chan *lpnResponse
+		confirms  chan uint
+		stop      chan struct{}
+		done      chan struct{}
+	}
+
+	lpnOffer struct {
+		src uint
+		def.FriendOfferParameters
+	}
+
+	// lpnResponse is a pdu received with the friendship credentials
+	lpnResponse struct {
+		update bool
+		md     uint
+	}
+
+	LowPowerStatus struct {
+		Running       bool
+		Friend        uint
+		Established   bool
+		PollInterval  time.Duration
+		Subscriptions []uint
+	}
+)
+
+const (
+	// criteria: RSSI factor 1.5, receive window factor 1.5, at least 8 messages in the friend queue
+	lpnCriteria     = 0x01<<5 | 0x01<<3 | 0x03
+	lpnReceiveDelay = 100 // ms
+	// the friend offers are received from 100 ms to 1 s after the friend request
+	lpnOfferWindow = 1100 * time.Millisecond
+	// a poll is answered within the receive window, the margin covers the latency of the bearer
+	lpnResponseMargin = 200 * time.Millisecond
+	lpnPollRetries    = 3
+	lpnRequestRetry   = 5 * time.Second
+	// addresses per friend subscription list message, keeping it unsegmented
+	lpnSubscriptionsPerMsg = 5
+)
+
+var (
+	loggerLpn = utils.CreateLogger("LPN")
+	lpn       *lowPower
+	lpnLock   sync.Mutex
+	// the subscriptions are kept when the low power mode is stopped
+	lpnSubscriptions = map[uint]bool{}
+	lpnCounter       uint
+)
+
+// StartLowPower runs the host node as a Low Power node, it finds a friend and polls
+// it every pollInterval milliseconds
+func StartLowPower(pollInterval uint) error {
+	netKey, err := proxyConfigNetKey()
+	if err != nil {
+		return err
+	}
+	lpnLock.Lock()
+	defer lpnLock.Unlock()
+	if lpn != nil {
+		return errors.WrongFriendSetting.New().AddContext("low power mode is already running")
+	}
+	lpn = &lowPower{
+		pollInterval:  time.Duration(pollInterval) * time.Millisecond,
+		netKey:        netKey,
+		subscriptions: lpnSubscriptions,
+		offers:        make(chan *lpnOffer, 8),
+		responses:     make(chan *lpnResponse, 1),
+		confirms:      make(chan uint, 1),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	go lpn.run()
+	return nil
+}
+
+// StopLowPower ends the friendship and the low power mode
+func StopLowPower() error {
+	lpnLock.Lock()
+	l := lpn
+	lpnLock.Unlock()
+	if l == nil {
+		return nil
+	}
+	close(l.stop)
+	<-l.done
+	lpnLock.Lock()
+	lpn = nil
+	lpnLock.Unlock()
+	return nil
+}
+
+func GetLowPowerStatus() LowPowerStatus {
+	lpnLock.Lock()
+	defer lpnLock.Unlock()
+	status := LowPowerStatus{Running: lpn != nil, Subscriptions: []uint{}}
+	for addr := range lpnSubscriptions {
+		status.Subscriptions = append(status.Subscriptions, addr)
+	}
+	sort.Slice(status.Subscriptions, func(i, j int) bool { return status.Subscriptions[i] < status.Subscriptions[j] })
+	if lpn != nil {
+		status.Friend = lpn.friend
+		status.Established = lpn.cred != nil
+		status.PollInterval = lpn.pollInterval
+	}
+	return status
+}
+
+// LowPowerSubscriptionAdd adds the group or virtual address to the subscription
+// list kept by the friend
+func LowPowerSubscriptionAdd(addr uint) error {
+	return lpnUpdateSubscriptions(friendSubscriptionListAdd, addr)
+}
+
+func LowPowerSubscriptionRemove(addr uint) error {
+	return lpnUpdateSubscriptions(friendSubscriptionListRemove, addr)
+}
+
+func lpnUpdateSubscriptions(opcode, addr uint) error {
+	if isUnassigned(addr) || isUnicastAddr(addr) {
+		return errors.InvalidResponse.New().AddContextF("%04x is not a group address", addr)
+	}
+	lpnLock.Lock()
+	if opcode == friendSubscriptionListAdd {
+		lpnSubscriptions[addr] = true
+	} else {
+		delete(lpnSubscriptions, addr)
+	}
+	l := lpn
+	lpnLock.Unlock()
+	if l == nil || l.credentials() == nil {
+		// sent to the friend once the friendship is established
+		return nil
+	}
+	return l.sendSubscriptions(opcode, []uint{addr})
+}
+
+func (l *lowPower) credentials() *NetKey {
+	lpnLock.Lock()
+	defer lpnLock.Unlock()
+	return l.cred
+}
+
+// lpnTxCredentials returns the friendship credentials the message is sent with,
+// the friend relays it with the master credentials
+func lpnTxCredentials(msg *NetworkMessage) *NetKey {
+	lpnLock.Lock()
+	defer lpnLock.Unlock()
+	if lpn == nil || lpn.cred == nil || msg.proxyConfig || msg.dst == FRIENDS_ADDRESS || msg.netKey.Index != lpn.netKey.Index {
+		return nil
+	}
+	return lpn.cred
+}
+
+// lpnCredentialsByNid returns the friendship credentials and the master key if the nid matches
+func lpnCredentialsByNid(nid uint) (*NetKey, *NetKey) {
+	lpnLock.Lock()
+	defer lpnLock.Unlock()
+	if lpn == nil || lpn.cred == nil || lpn.cred.Nid != nid {
+		return nil, nil
+	}
+	return lpn.cred, lpn.netKey
+}
+
+// lpnReceived notifies the poll of a pdu from the friend
+func lpnReceived(msg *NetworkMessage) {
+	resp := &lpnResponse{}
+	if msg.ctl == 1 && len(msg.plain) == 7 && msg.plain[0] == friendUpdate {
+		resp.update, resp.md = true, uint(msg.plain[6])
+	}
+	lpnLock.Lock()
+	l := lpn
+	lpnLock.Unlock()
+	if l == nil {
+		return
+	}
+	select {
+	case l.responses <- resp:
+	default:
+	}
+}
+
+// lpnControlReceive handles the transport control messages sent by the friend
+func lpnControlReceive(msg *NetworkMessage, opcode uint, params []byte) error {
+	lpnLock.Lock()
+	l := lpn
+	lpnLock.Unlock()
+	if l == nil {
+		return nil
+	}
+	switch opcode {
+	case friendOffer:
+		offer := &lpnOffer{src: msg.src}
+		if err := utils.UnpackStructBE(params, &offer.FriendOfferParameters); err != nil {
+			return err
+		}
+		select {
+		case l.offers <- offer:
+		default:
+		}
+	case friendUpdate:
+		update := &def.FriendUpdateMessageParameters{}
+		if err := utils.UnpackStructBE(params, update); err != nil {
+			return err
+		}
+		ivUpdateOnBeacon(update.IVIndex, (update.Flags>>1)&0x01)
+	case friendSubscriptionListConfirm:
+		if len(params) != 1 {
+			return errors.DataLengthCheckFailed.New().AddContextF("subscription list confirm: % 2x", params)
+		}
+		select {
+		case l.confirms <- uint(params[0]):
+		default:
+		}
+	}
+	return nil
+}
+
+// pollTimeout is the PollTimeout of the friend request in 100 ms, 3 poll intervals
+func (l *lowPower) pollTimeout() uint {
+	timeout := uint(3 * l.pollInterval / (100 * time.Millisecond))
+	if timeout < friendMinPollTimeout {
+		return friendMinPollTimeout
+	}
+	if timeout > friendMaxPollTimeout {
+		return friendMaxPollTimeout
+	}
+	return timeout
+}
+
+func (l *lowPower) responseTimeout() time.Duration {
+	return lpnReceiveDelay*time.Millisecond + l.receiveWindow + lpnResponseMargin
+}
+
+// request sends a friend request and selects the offer with the largest queue
+func (l *lowPower) request(previous uint) (*lpnOffer, error) {
+	lpnLock.Lock()
+	l.lpnCounter = lpnCounter
+	lpnCounter = (lpnCounter + 1) & 0xFFFF
+	lpnLock.Unlock()
+	params, err := utils.PackStructBE(&def.FriendRequestMessageParameters{
+		Criteria: lpnCriteria, ReceiveDelay: lpnReceiveDelay, PollTimeout: l.pollTimeout(),
+		PreviousAddress: previous, NumElements: 1, LPNCounter: l.lpnCounter,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tpSendControlMsg(l.netKey, friendRequest, params, FRIENDS_ADDRESS, 0); err != nil {
+		return nil, err
+	}
+	var best *lpnOffer
+	timeout := time.After(lpnOfferWindow)
+	for {
+		select {
+		case offer := <-l.offers:
+			loggerLpn.Debugf("friend offer from %04x: %+v", offer.src, offer.FriendOfferParameters)
+			if best == nil || offer.QueueSize > best.QueueSize {
+				best = offer
+			}
+		case <-timeout:
+			if best == nil {
+				return nil, errors.Timeout.New().AddContext("no friend offer")
+			}
+			return best, nil
+		case <-l.stop:
+			return nil, errors.Timeout.New().AddContext("low power mode stopped")
+		}
+	}
+}
+
+// establish derives the friendship credentials from the offer
+func (l *lowPower) establish(offer *lpnOffer) error {
+	cred, err := genFriendshipCredentials(l.netKey.txKey(), meshDb.UnicastAddress, offer.src, l.lpnCounter, offer.FriendCounter)
+	if err != nil {
+		return err
+	}
+	lpnLock.Lock()
+	l.friend, l.friendCounter, l.cred, l.fsn = offer.src, offer.FriendCounter, cred, 0
+	l.receiveWindow = time.Duration(offer.ReceiveWindow) * time.Millisecond
+	lpnLock.Unlock()
+	return nil
+}
+
+func (l *lowPower) lost() {
+	lpnLock.Lock()
+	l.cred = nil
+	lpnLock.Unlock()
+}
+
+// poll sends a friend poll and waits for the response, the poll is sent again
+// with the same FSN if the response is lost
+func (l *lowPower) poll() (*lpnResponse, error) {
+	params := []byte{byte(l.fsn)}
+	for i := 0; i < lpnPollRetries; i++ {
+		select {
+		case <-l.responses:
+		default:
+		}
+		if err := tpSendControlMsg(l.cred, friendPoll, params, l.friend, 0); err != nil {
+			return nil, err
+		}
+		select {
+		case resp := <-l.responses:
+			l.fsn ^= 0x01
+			return resp, nil
+		case <-time.After(l.responseTimeout()):
+		case <-l.stop:
+			return nil, errors.Timeout.New().AddContext("low power mode stopped")
+		}
+	}
+	return nil, errors.Timeout.New().AddContextF("no response of friend %04x", l.friend)
+}
+
+// pollAll polls until the friend queue is empty
+func (l *lowPower) pollAll() error {
+	for {
+		resp, err := l.poll()
+		if err != nil {
+			return err
+		}
+		if resp.update && resp.md == 0 {
+			return nil
+		}
+	}
+}
+
+// sendSubscriptions sends the subscription list add or remove message and waits for the confirm
+func (l *lowPower) sendSubscriptions(opcode uint, addrs []uint) error {
+	for i := 0; i < len(addrs); i += lpnSubscriptionsPerMsg {
+		end := i + lpnSubscriptionsPerMsg
+		if end > len(addrs) {
+			end = len(addrs)
+		}
+		lpnLock.Lock()
+		l.transaction = (l.transaction + 1) & 0xFF
+		params := []byte{byte(l.transaction)}
+		cred, friend := l.cred, l.friend
+		lpnLock.Unlock()
+		for _, addr := range addrs[i:end] {
+			params = append(params, byte(addr>>8), byte(addr))
+		}
+		confirmed := false
+		for try := 0; try < lpnPollRetries && !confirmed; try++ {
+			if err := tpSendControlMsg(cred, opcode, params, friend, 0); err != nil {
+				return err
+			}
+			select {
+			case transaction := <-l.confirms:
+				confirmed = transaction == uint(params[0])
+			case <-time.After(l.responseTimeout()):
+			}
+		}
+		if !confirmed {
+			return errors.Timeout.New().AddContextF("subscription list of friend %04x", friend)
+		}
+	}
+	return nil
+}
+
+// clear ends the friendship with the friend
+func (l *lowPower) clear(friend uint) {
+	params, err := utils.PackStructBE(&def.FriendClearParameters{LPNAddress: meshDb.UnicastAddress, LPNCounter: l.lpnCounter})
+	if err == nil {
+		err = tpSendControlMsg(l.netKey, friendClear, params, friend, 5)
+	}
+	if err != nil {
+		loggerLpn.Error(err)
+	}
+}
+
+func (l *lowPower) run() {
+	defer close(l.done)
+	var previous uint
+	for {
+		offer, err := l.request(previous)
+		if err == nil {
+			err = l.establish(offer)
+		}
+		if err == nil {
+			// the first poll is answered by a friend update
+			err = l.pollAll()
+		}
+		if err == nil {
+			loggerLpn.Infof("friendship with %04x established", l.friend)
+			previous = l.friend
+			lpnLock.Lock()
+			addrs := []uint{}
+			for addr := range l.subscriptions {
+				addrs = append(addrs, addr)
+			}
+			lpnLock.Unlock()
+			if err := l.sendSubscriptions(friendSubscriptionListAdd, addrs); err != nil {
+				loggerLpn.Error(err)
+			}
+			err = l.keepPolling()
+		}
+		l.lost()
+		select {
+		case <-l.stop:
+			if previous != UNASSIGNED_ADDRESS {
+				l.clear(previous)
+			}
+			return
+		default:
+		}
+		loggerLpn.Warnf("no friendship, retry later, error: %s", err)
+		select {
+		case <-time.After(lpnRequestRetry):
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// keepPolling polls the friend every poll interval until the friendship is lost
+func (l *lowPower) keepPolling() error {
+	t := time.NewTicker(l.pollInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := l.pollAll(); err != nil {
+				return err
+			}
+		case <-l.stop:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package mesh
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_lpnPollTimeout(t *testing.T) {
+	l := &lowPower{pollInterval: 10 * time.Second}
+	assert.Equal(t, uint(300), l.pollTimeout())
+	l.pollInterval = time.Millisecond
+	assert.Equal(t, uint(friendMinPollTimeout), l.pollTimeout())
+}
+
+func Test_lpnTxCredentials(t *testing.T) {
+	netKey := createNetKeyS("7dd7364cd842ad18c17c2b820c84c3d6", 0)
+	cred, err := genFriendshipCredentials(netKey, 0x1201, 0x2345, 0x0000, 0x072f)
+	assert.Nil(t, err)
+	lpn = &lowPower{netKey: netKey, responses: make(chan *lpnResponse, 1)}
+	defer func() { lpn = nil }()
+
+	// not established yet
+	assert.Nil(t, lpnTxCredentials(&NetworkMessage{dst: 0x2345, netKey: netKey}))
+	lpn.cred = cred
+	assert.Equal(t, cred, lpnTxCredentials(&NetworkMessage{dst: 0x2345, netKey: netKey}))
+	// the friend request is sent with the master credentials
+	assert.Nil(t, lpnTxCredentials(&NetworkMessage{dst: FRIENDS_ADDRESS, netKey: netKey}))
+
+	// friend update with more data
+	lpnReceived(&NetworkMessage{ctl: 1, plain: []byte{friendUpdate, 0, 0, 0, 0, 0, 1}})
+	assert.Equal(t, &lpnResponse{update: true, md: 1}, <-lpn.responses)
+	lpnReceived(&NetworkMessage{ctl: 0, plain: []byte{0x00, 0x01}})
+	assert.Equal(t, &lpnResponse{}, <-lpn.responses)
+}
@@ -2,17 +2,11 @@ package mesh
 
 import (
 	"ble-mesh/mesh/crypto"
+	"ble-mesh/mesh/def"
 	"ble-mesh/utils"
 	"ble-mesh/utils/errors"
 	"bytes"
-	"crypto/rand"
-	"encoding/binary"
-	"fmt"
 	"reflect"
-	"sync"
-	"time"
-
-	"github.com/jinzhu/copier"
 )
 
 const (
@@ -33,11 +27,9 @@ const (
 )
 
 var (
-	loggerMesh    = utils.CreateLogger("Mesh")
-	netBear       Bear
-	provBear      Bear
-	currentBear   uint
-	provStoppedCb func()
+	loggerMesh  = utils.CreateLogger("Mesh")
+	netBear     Bear
+	currentBear uint
 )
 
 func genNetworkNonce(src, seq, ivIndex, ctl, ttl uint) ([]byte, error) {
@@ -64,220 +56,76 @@ func genDeviceNonce(src, seq, ivIndex, szmic, dst uint) ([]byte, error) {
 	return data, err
 }
 
+func genProxyNonce(src, seq, ivIndex uint) ([]byte, error) {
+	data, err := utils.PackStructBE(&def.ProxyNonceFormat{NonceType: 0x03, SEQ: seq, SRC: src, IVIndex: ivIndex})
+	if err != nil {
+		err = errors.FailedToGenerateNonce.New().AddContext(err)
+	}
+	return data, err
+}
+
 func StartMeshNetwork() {
 	netBear.Start()
 	startNet()
 	startTransport()
+	startIvUpdate()
+	startBeacon()
+	startHeartbeat()
+	go func() {
+		if err := ResumeKeyRefresh(); err != nil {
+			loggerMesh.Error(err)
+		}
+	}()
 }
 
 func StopMeshNetwork() {
 	netBear.Stop()
 	stopNet()
 	stopTransport()
+	stopIvUpdate()
+	stopBeacon()
+	stopHeartbeat()
+	StopTimeAuthority()
 }
 
-func StartMeshProvision(uuid string, provStopped func()) {
-	provBear.Start()
-	startProvision(uuid)
-	provStoppedCb = provStopped
-}
-
-func onProvisionFinished() {
-	if provStoppedCb != nil {
-		provStoppedCb()
+// StartMeshProvision provisions the device over the bearer, several devices can
+// be provisioned at the same time with different bearers
+func StartMeshProvision(dev UnprovisionedDevice, bear Bear, provStopped func(*ProvisionResult)) *ProvisioningSession {
+	provSessionsLock.Lock()
+	for _, s := range provSessions {
+		if s.UUID == dev.UUID || s.bear == bear {
+			provSessionsLock.Unlock()
+			loggerMesh.Errorf("device %s is being provisioned", dev.UUID)
+			return nil
+		}
 	}
-	StopMeshProvision()
+	s := newProvisioningSession(dev, bear, provStopped)
+	provSessions[bear] = s
+	provSessionsLock.Unlock()
+	s.start()
+	return s
 }
 
-func StopMeshProvision() {
-	provBear.Stop()
-	stopProvision()
+func StopMeshProvision(uuid string) {
+	provSessionsLock.Lock()
+	defer provSessionsLock.Unlock()
+	for _, s := range provSessions {
+		if s.UUID == uuid {
+			s.Stop()
+		}
+	}
 }
 
 func SetNetworkBear(b Bear) {
 	netBear = b
 }
 
-func SetProvisionBear(b Bear) {
-	provBear = b
-}
-
 func OnClose() {
 	writeMeshToDb()
 	for _, n := range meshDb.Nodes {
 		writeNodeToDb(n)
 	}
-}
-
-func RefreshNetKey(index uint) error {
-	//generate a new key and save
-	keyBytes := make([]byte, 16)
-	rand.Read(keyBytes)
-	newKey := createNetKeyB(keyBytes, index)
-	oldKey, _ := findNetKeyByIndex(index)
-	oldKey.NewKey = newKey
-	newKey.OldKey = oldKey
-	meshDb.NetKeys[index] = newKey
-	failedNodes := []*Node{}
-	timeoutNodes := []*Node{}
-	friends := map[*Node]*Node{}
-	writeMeshToDb()
-
-	finalResult := make(chan bool)
-	maxTimeout := 1000
-	resps := map[uint]*AccessMessage{}
-	var responseHandler modelMsglistener
-	responseHandler = func(msg *AccessMessage) {
-		if msg.opcode == opConfigNetKeyStatus {
-			resps[msg.src] = msg
-		}
-	}
-	go func(to *int) {
-		defer unregisterModelMessageRxListener(&responseHandler)
-		registerModelMessageRxListener(&responseHandler)
-		startTime := time.Now()
-		for {
-			time.Sleep(time.Millisecond * 100)
-			if time.Now().After(startTime.Add(time.Duration(*to) * time.Second)) {
-				finalResult <- false
-				break
-			}
-			if len(resps) == len(meshDb.Nodes) {
-				for src, resp := range resps {
-					netKey := binary.LittleEndian.Uint16(resp.payload[1:3]) & 0x0FFF
-					if netKey == uint16(index) {
-						status := statusCode[resp.payload[0]]
-						// loggerConfCli.Infof("got response for net key %d, status: %s", netKey, status)
-						if status == "Success" {
-							//copy netkey from db
-							node, _ := findNodeByAddr(src)
-							oldkey, _ := node.findNodeNetKeyByIndex(index)
-							copier.Copy(oldkey, newKey)
-							continue
-						}
-					}
-					finalResult <- false
-					break
-				}
-				finalResult <- true
-				break
-			}
-		}
-	}(&maxTimeout)
-
-	// opConfigNetKeyUpdate
-	var wg sync.WaitGroup
-	for _, node := range meshDb.Nodes {
-		wg.Add(1)
-		payload, _ := utils.PackLE("12,04,B", newKey.Index, newKey.Bytes)
-		modelSendTmpl1(false, node.UnicastAddress, opConfigNetKeyUpdate, payload, 10, func(msg *AccessMessage) error {
-			netKey := binary.LittleEndian.Uint16(msg.payload[1:3]) & 0x0FFF
-			status := statusCode[msg.payload[0]]
-			loggerMesh.Infof("got response for net key %d, status: %s", netKey, status)
-			if status == "Success" {
-				//copy netkey from db
-				if friends[node] != nil {
-					delete(friends, node)
-				}
-			} else {
-				loggerMesh.Errorf("cannot update netkey for node %x, response: %s", node.UnicastAddress, status)
-				failedNodes = append(failedNodes, node)
-			}
-			wg.Done()
-			//todo: implement error
-			return nil
-		}, func() {
-			//timeout
-			if friends[node] == nil {
-				timeoutNodes = append(timeoutNodes, node)
-			}
-			wg.Done()
-		}, func(ack *SegmentAckMessage) {
-			// obo ack received
-			if ack.obo == 1 {
-				friend, _ := findNodeByAddr(ack.src)
-				// update mesh topology
-				node.Friend = friend
-				node.LPN = true
-				writeNodeToDb(node)
-
-				friends[node] = friend
-			}
-		})
-	}
-	wg.Wait()
-	errStr := ""
-	for _, n := range failedNodes {
-		errStr += fmt.Sprintf("fail to update netkey for node %x\n", n.UnicastAddress)
-	}
-	for _, n := range timeoutNodes {
-		errStr += fmt.Sprintf("timeout to update netkey for node %x\n", n.UnicastAddress)
-	}
-	if errStr != "" {
-		// return errors.New(errStr)
-	}
-
-	// On receiving Segment Acknowledgments with the OBO field set to 1 to key update messages
-	// sent to a Low Power node, a Configuration Client may perform a PollTimeout List procedure
-	// to the Low Power node's Friend node (identifying the Friend node using the value of SRC field
-	// of the Segment Acknowledgment) in order to obtain the current value of the PollTimeout timer,
-	// and schedule retries of NetKey or AppKey updates based on this value.
-	timeoutFriendNodes := []*Node{}
-	// lpnPollTimeout := map[*Node]uint{}
-	var maxPollTimeout uint
-	for lpn, friend := range friends {
-		wg.Add(1)
-		payload, _ := utils.PackLE("16", lpn.UnicastAddress)
-		modelSendTmpl1(false, friend.UnicastAddress, opConfigLowPowerNodePollTimeoutGet, payload, 5,
-			func(msg *AccessMessage) error {
-				var addr, timeout uint
-				utils.UnpackLE(msg.payload, "16,32", &addr, &timeout)
-				// lpnPollTimeout[lpn] = timeout / 10
-				if maxPollTimeout < timeout {
-					maxPollTimeout = timeout
-				}
-				loggerFoundation.Infof("current timeout of LPN %4x: %dms", addr, timeout*100)
-				wg.Done()
-				//todo: implement error
-				return nil
-			},
-			func() {
-				//timeout should not happen
-				timeoutFriendNodes = append(timeoutFriendNodes, friend)
-				wg.Done()
-			}, nil)
-	}
-	wg.Wait()
-	for _, n := range timeoutFriendNodes {
-		errStr += fmt.Sprintf("timeout occurs during PollTimeout to node %x\n", n.UnicastAddress)
-	}
-	if errStr != "" {
-		// return errors.New(errStr)
-	}
-	maxTimeout = int(maxPollTimeout)
-
-	res := <-finalResult
-	if res {
-		loggerMesh.Info("netkey refresh phase 1 end")
-		newKey.KeyRefreshPhase = 1
-		return nil
-	}
-	// return errors.New(string.Sprintf("timeout occurs during PollTimeout to address %4x", friend.UnicastAddress))
-
-	for _, n := range meshDb.Nodes {
-		var found bool
-		for _, rn := range resps {
-			if rn.src == n.UnicastAddress {
-				found = true
-				break
-			}
-		}
-		if !found {
-			errStr += fmt.Sprintf("no response from node %x\n", n.UnicastAddress)
-		}
-	}
-	// return errors.New(errStr)
-	return nil
+	writeRplToDb()
 }
 
 // handles mesh beacon
@@ -296,22 +144,19 @@ func meshBeaconReceive(proxyPdu []byte) {
 		var ivUpdateFlag, keyRefresh, ivIndex uint
 		var networkId uint64
 		utils.UnpackBE(beacon, "06, 1, 1, 64, 32", &ivUpdateFlag, &keyRefresh, &networkId, &ivIndex)
-		// todo: key refresh, use both new key and old key
 		for _, netKey := range meshDb.NetKeys {
-			if netKey.NetworkId == networkId {
-				authVerify, _ := crypto.AES_CMAC(netKey.BeaconKey, beacon)
+			// during key refresh, the beacon may be authenticated with the old key
+			for _, key := range netKey.rxKeys() {
+				if key.NetworkId != networkId {
+					continue
+				}
+				authVerify, _ := crypto.AES_CMAC(key.BeaconKey, beacon)
 				if bytes.Equal(auth, authVerify[:8]) {
 					loggerMesh.Infof("received mesh beacon: keyReresh %d, IV index %d, IV update flag %d", keyRefresh, ivIndex, ivUpdateFlag)
-					// todo: KeyRefresh procedure
-					// netKey.KeyRefresh = keyRefresh
-					if ivIndex == meshDb.IVindex {
-						meshDb.IVupdate = ivUpdateFlag
-					}
-					if ivIndex == meshDb.IVindex+1 && ivUpdateFlag == 1 {
-						// key refresh procedure
-						meshDb.IVindex = ivIndex
-						meshDb.IVupdate = ivUpdateFlag
-					}
+					// the key refresh phases are driven by the provisioner, see RefreshNetKey
+					beaconObserved(netKey)
+					ivUpdateOnBeacon(ivIndex, ivUpdateFlag)
+					return
 				}
 			}
 		}
@@ -360,7 +205,12 @@ func calcUnicastAddr(uuid string) uint {
 	if meshDb.LowAddress > maxAddr {
 		return meshDb.LowAddress
 	}
-	return maxAddr + uint(len(meshDb.Nodes[maxAddr].Elements))
+	// composition data of the node may not be fetched yet
+	numElements := len(meshDb.Nodes[maxAddr].Elements)
+	if numElements == 0 {
+		numElements = 1
+	}
+	return maxAddr + uint(numElements)
 
 }
 
@@ -593,10 +443,38 @@ func SetNode(nodeNew *Node) error {
 	}
 
 	if node.AttentionTimer != nodeNew.AttentionTimer {
+		err := HealthAttentionSet(node.UnicastAddress, nodeNew.AttentionTimer)
+		if err != nil {
+			return err
+		}
+	}
+
+	// heartbeat
+	pub, pubNew := node.HeartbeatPublicationState, nodeNew.HeartbeatPublicationState
+	pub.Status, pubNew.Status = 0, 0
+	if pub != pubNew {
+		err := ConfigHeartbeatPublicationSet(
+			node.UnicastAddress,
+			pubNew.Destination,
+			pubNew.CountLog,
+			pubNew.PeriodLog,
+			pubNew.TTL,
+			pubNew.Features,
+			pubNew.NetKeyIndex,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	sub, subNew := node.HeartbeatSubscriptionState, nodeNew.HeartbeatSubscriptionState
+	if sub.Source != subNew.Source || sub.Destination != subNew.Destination || sub.PeriodLog != subNew.PeriodLog {
+		err := ConfigHeartbeatSubscriptionSet(node.UnicastAddress, subNew.Source, subNew.Destination, subNew.PeriodLog)
+		if err != nil {
+			return err
+		}
 	}
 
 	writeNodeToDb(node)
-	// todo: heartbeat publication & subscription
 	return nil
 }
 
@@ -6,6 +6,7 @@ import (
 	"ble-mesh/mesh/def"
 	"ble-mesh/utils"
 	"ble-mesh/utils/errors"
+	"bytes"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
@@ -14,6 +15,7 @@ import (
 	"path"
 	"reflect"
 	"strconv"
+	"time"
 )
 
 type (
@@ -23,12 +25,26 @@ type (
 		AppKeys        map[uint]*AppKey
 		Nodes          map[uint]*Node
 		Groups         map[uint]*Group
+		Scenes         map[uint]*Scene
 		UnicastAddress uint
 		LowAddress     uint
 		HighAddress    uint
 		IVindex        uint
 		IVupdate       uint
+		// last transition of the IV Update procedure and last IV Index Recovery
+		IVupdateTime   time.Time
+		IVrecoveryTime time.Time
 		SequenceNumber uint
+		// relay feature of the host node
+		Relay                        uint
+		RelayRetransmitCount         uint
+		RelayRetransmitIntervalSteps uint
+		// network transmit state of the host node
+		NetworkTransmitCount         uint
+		NetworkTransmitIntervalSteps uint
+		// friend feature of the host node
+		Friend          uint
+		FriendQueueSize uint
 	}
 
 	NetKey struct {
@@ -90,7 +106,13 @@ type (
 		KeyRefreshPhaseState uint
 		PollTimeoutListState map[uint]uint // key: lpn address
 		NetwrokTransmitState def.ConfigNetworkTransmitStatusMessageParameters
-		CurrentFault         uint
+		// the faults published by the health server, the latest last
+		CurrentFault    []HealthFaultRecord
+		RegisteredFault []HealthFault
+		HealthPeriod    uint
+		// heartbeat
+		HeartbeatPublicationState  def.ConfigHeartbeatPublicationStatusMessageParameters
+		HeartbeatSubscriptionState def.ConfigHeartbeatSubscriptionStatusMessageParameters
 	}
 
 	Model struct {
@@ -114,6 +136,13 @@ type (
 		Name    string
 		Address uint
 	}
+
+	// Scene is a scene number known to the gateway with the elements storing it
+	Scene struct {
+		Number    uint
+		Name      string
+		Addresses []uint
+	}
 )
 
 var (
@@ -180,17 +209,29 @@ func Init(configDirectory string) {
 		AppKeys:        make(map[uint]*AppKey),
 		Nodes:          make(map[uint]*Node),
 		Groups:         make(map[uint]*Group),
+		Scenes:         make(map[uint]*Scene),
 		MeshName:       meshDbRaw.MeshName,
 		UnicastAddress: utils.HexStringToUint(meshDbRaw.Provisioner.UnicastAddress),
 		LowAddress:     utils.HexStringToUint(meshDbRaw.Provisioner.LowAddress),
 		HighAddress:    utils.HexStringToUint(meshDbRaw.Provisioner.HighAddress),
 		IVindex:        meshDbRaw.IVindex,
 		IVupdate:       meshDbRaw.IVupdate,
+		IVupdateTime:   time.Unix(meshDbRaw.IVupdateTime, 0),
+		IVrecoveryTime: time.Unix(meshDbRaw.IVrecoveryTime, 0),
 		SequenceNumber: meshDbRaw.SequenceNumber,
+
+		Relay:                        meshDbRaw.Relay,
+		RelayRetransmitCount:         meshDbRaw.RelayRetransmitCount,
+		RelayRetransmitIntervalSteps: meshDbRaw.RelayRetransmitIntervalSteps,
+		NetworkTransmitCount:         meshDbRaw.NetworkTransmitCount,
+		NetworkTransmitIntervalSteps: meshDbRaw.NetworkTransmitIntervalSteps,
+		Friend:                       meshDbRaw.Friend,
+		FriendQueueSize:              meshDbRaw.FriendQueueSize,
 	}
 
 	for _, rawKey := range meshDbRaw.NetKeys {
 		key := createNetKeyS(rawKey.Key, rawKey.Index)
+		key.KeyRefreshPhase = rawKey.KeyRefreshPhase
 		if rawKey.OldKey != "" {
 			oldKey := createNetKeyS(rawKey.OldKey, rawKey.Index)
 			key.OldKey = oldKey
@@ -201,6 +242,7 @@ func Init(configDirectory string) {
 
 	for _, rawKey := range meshDbRaw.AppKeys {
 		key := createAppKeyS(rawKey.Key, rawKey.Index)
+		key.KeyRefreshPhase = rawKey.KeyRefreshPhase
 		if rawKey.OldKey != "" {
 			oldKey := createAppKeyS(rawKey.OldKey, rawKey.Index)
 			key.OldKey = oldKey
@@ -250,8 +292,21 @@ func Init(configDirectory string) {
 					NetworkTransmitCount:         nodeRaw.NetworkTransmitCount,
 					NetworkTransmitIntervalSteps: nodeRaw.NetworkTransmitIntervalSteps,
 				},
-				CurrentFault:       nodeRaw.CurrentFault,
+				CurrentFault:       healthFaultRecordsFromDb(nodeRaw.CurrentFault),
 				NodeIdentityStates: map[uint]uint{},
+				HeartbeatPublicationState: def.ConfigHeartbeatPublicationStatusMessageParameters{
+					Destination: utils.HexStringToUint(nodeRaw.HeartbeatPublicationDestination),
+					CountLog:    nodeRaw.HeartbeatPublicationCountLog,
+					PeriodLog:   nodeRaw.HeartbeatPublicationPeriodLog,
+					TTL:         nodeRaw.HeartbeatPublicationTTL,
+					Features:    nodeRaw.HeartbeatPublicationFeatures,
+					NetKeyIndex: nodeRaw.HeartbeatPublicationNetKeyIndex,
+				},
+				HeartbeatSubscriptionState: def.ConfigHeartbeatSubscriptionStatusMessageParameters{
+					Source:      utils.HexStringToUint(nodeRaw.HeartbeatSubscriptionSource),
+					Destination: utils.HexStringToUint(nodeRaw.HeartbeatSubscriptionDestination),
+					PeriodLog:   nodeRaw.HeartbeatSubscriptionPeriodLog,
+				},
 			}
 			utils.InitializeStruct(reflect.ValueOf(&node).Elem(), 1)
 			if nodeRaw.Friend != "" {
@@ -352,6 +407,19 @@ func Init(configDirectory string) {
 		}
 		meshDb.Groups[group.Address] = group
 	}
+	for _, s := range meshDbRaw.Scenes {
+		scene := &Scene{
+			Number:    s.Number,
+			Name:      s.Name,
+			Addresses: []uint{},
+		}
+		for _, addr := range s.Addresses {
+			scene.Addresses = append(scene.Addresses, utils.HexStringToUint(addr))
+		}
+		meshDb.Scenes[scene.Number] = scene
+	}
+	loadRpl()
+	loadKeyRefreshes()
 	loggerMesh.Debugf("%+#v", meshDb)
 }
 
@@ -365,6 +433,45 @@ func findNetKeyByNid(nid uint) []*NetKey {
 	return ret
 }
 
+// IsKnownNetworkId reports whether the network id advertised by a proxy
+// belongs to one of our net keys
+func IsKnownNetworkId(networkId []byte) bool {
+	if len(networkId) != 8 {
+		return false
+	}
+	var id uint64
+	utils.UnpackBE(networkId, "64", &id)
+	for _, netKey := range meshDb.NetKeys {
+		for _, key := range netKey.rxKeys() {
+			if key.NetworkId == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ResolveNodeIdentity returns the unicast address of the node advertising the
+// node identity, Hash = e(IdentityKey, Padding || Random || Address) mod 2^64
+func ResolveNodeIdentity(hash, random []byte) (uint, bool) {
+	if len(hash) != 8 || len(random) != 8 {
+		return 0, false
+	}
+	for _, n := range meshDb.Nodes {
+		for _, netKey := range meshDb.NetKeys {
+			for _, key := range netKey.rxKeys() {
+				plain := append(make([]byte, 6), random...)
+				plain = append(plain, byte(n.UnicastAddress>>8), byte(n.UnicastAddress))
+				cipher, err := crypto.AES_ECB(key.IdentityKey, plain)
+				if err == nil && bytes.Equal(cipher[8:16], hash) {
+					return n.UnicastAddress, true
+				}
+			}
+		}
+	}
+	return 0, false
+}
+
 func findNetKeyByIndex(index uint) (*NetKey, error) {
 	for _, key := range meshDb.NetKeys {
 		if key.Index == index {
@@ -502,19 +609,38 @@ func (node *Node) findLpnFriends() []*Node {
 func writeMeshToDb() {
 	meshDbRaw.IVindex = meshDb.IVindex
 	meshDbRaw.IVupdate = meshDb.IVupdate
-	meshDbRaw.SequenceNumber = meshDb.SequenceNumber
+	meshDbRaw.IVupdateTime = meshDb.IVupdateTime.Unix()
+	meshDbRaw.IVrecoveryTime = meshDb.IVrecoveryTime.Unix()
+	meshDbRaw.SequenceNumber = txSeq()
+	meshDbRaw.Relay = meshDb.Relay
+	meshDbRaw.RelayRetransmitCount = meshDb.RelayRetransmitCount
+	meshDbRaw.RelayRetransmitIntervalSteps = meshDb.RelayRetransmitIntervalSteps
+	meshDbRaw.NetworkTransmitCount = meshDb.NetworkTransmitCount
+	meshDbRaw.NetworkTransmitIntervalSteps = meshDb.NetworkTransmitIntervalSteps
+	meshDbRaw.Friend = meshDb.Friend
+	meshDbRaw.FriendQueueSize = meshDb.FriendQueueSize
+	meshDbRaw.Scenes = scenesToDb()
 	for _, netKey := range meshDb.NetKeys {
 		for i := 0; i < len(meshDbRaw.NetKeys); i++ {
 			if meshDbRaw.NetKeys[i].Index == netKey.Index {
 				meshDbRaw.NetKeys[i].KeyRefreshPhase = netKey.KeyRefreshPhase
 				meshDbRaw.NetKeys[i].Key = hex.EncodeToString(netKey.Bytes)
+				meshDbRaw.NetKeys[i].OldKey = ""
+				if netKey.OldKey != nil {
+					meshDbRaw.NetKeys[i].OldKey = hex.EncodeToString(netKey.OldKey.Bytes)
+				}
 			}
 		}
 	}
 	for _, appKey := range meshDb.AppKeys {
 		for i := 0; i < len(meshDbRaw.AppKeys); i++ {
 			if meshDbRaw.AppKeys[i].Index == appKey.Index {
+				meshDbRaw.AppKeys[i].KeyRefreshPhase = appKey.KeyRefreshPhase
 				meshDbRaw.AppKeys[i].Key = hex.EncodeToString(appKey.Bytes)
+				meshDbRaw.AppKeys[i].OldKey = ""
+				if appKey.OldKey != nil {
+					meshDbRaw.AppKeys[i].OldKey = hex.EncodeToString(appKey.OldKey.Bytes)
+				}
 			}
 		}
 	}
@@ -524,26 +650,35 @@ func writeMeshToDb() {
 
 func writeNodeToDb(node *Node) {
 	nodeRaw := &db.Node{
-		UUID:                         node.UUID,
-		Cid:                          node.Cid,
-		Pid:                          node.Pid,
-		Vid:                          node.Vid,
-		Crpl:                         node.Crpl,
-		Features:                     node.Features,
-		SequenceNumber:               node.SequenceNumber,
-		LPN:                          node.LPN,
-		TTL:                          node.DefaultTTL,
-		Relay:                        node.RelayState.Relay,
-		RelayRetransmitCount:         node.RelayState.RelayRetransmitCount,
-		RelayRetransmitIntervalSteps: node.RelayState.RelayRetransmitIntervalSteps,
-		AttentionTimer:               node.AttentionTimer,
-		SecureNetworkBeacon:          node.SecureNetworkBeacon,
-		GATTProxyState:               node.GATTProxyState,
-		FriendState:                  node.FriendState,
-		KeyRefreshPhaseState:         node.KeyRefreshPhaseState,
-		NetworkTransmitCount:         node.NetwrokTransmitState.NetworkTransmitCount,
-		NetworkTransmitIntervalSteps: node.NetwrokTransmitState.NetworkTransmitIntervalSteps,
-		CurrentFault:                 node.CurrentFault,
+		UUID:                             node.UUID,
+		Cid:                              node.Cid,
+		Pid:                              node.Pid,
+		Vid:                              node.Vid,
+		Crpl:                             node.Crpl,
+		Features:                         node.Features,
+		SequenceNumber:                   node.SequenceNumber,
+		LPN:                              node.LPN,
+		TTL:                              node.DefaultTTL,
+		Relay:                            node.RelayState.Relay,
+		RelayRetransmitCount:             node.RelayState.RelayRetransmitCount,
+		RelayRetransmitIntervalSteps:     node.RelayState.RelayRetransmitIntervalSteps,
+		AttentionTimer:                   node.AttentionTimer,
+		SecureNetworkBeacon:              node.SecureNetworkBeacon,
+		GATTProxyState:                   node.GATTProxyState,
+		FriendState:                      node.FriendState,
+		KeyRefreshPhaseState:             node.KeyRefreshPhaseState,
+		NetworkTransmitCount:             node.NetwrokTransmitState.NetworkTransmitCount,
+		NetworkTransmitIntervalSteps:     node.NetwrokTransmitState.NetworkTransmitIntervalSteps,
+		CurrentFault:                     healthFaultRecordsToDb(node.CurrentFault),
+		HeartbeatPublicationDestination:  strconv.FormatUint(uint64(node.HeartbeatPublicationState.Destination), 16),
+		HeartbeatPublicationCountLog:     node.HeartbeatPublicationState.CountLog,
+		HeartbeatPublicationPeriodLog:    node.HeartbeatPublicationState.PeriodLog,
+		HeartbeatPublicationTTL:          node.HeartbeatPublicationState.TTL,
+		HeartbeatPublicationFeatures:     node.HeartbeatPublicationState.Features,
+		HeartbeatPublicationNetKeyIndex:  node.HeartbeatPublicationState.NetKeyIndex,
+		HeartbeatSubscriptionSource:      strconv.FormatUint(uint64(node.HeartbeatSubscriptionState.Source), 16),
+		HeartbeatSubscriptionDestination: strconv.FormatUint(uint64(node.HeartbeatSubscriptionState.Destination), 16),
+		HeartbeatSubscriptionPeriodLog:   node.HeartbeatSubscriptionState.PeriodLog,
 	}
 	if node.Friend != nil {
 		nodeRaw.Friend = strconv.FormatUint(uint64(node.Friend.UnicastAddress), 16)
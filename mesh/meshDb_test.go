@@ -0,0 +1,23 @@
+package mesh
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ResolveNodeIdentity(t *testing.T) {
+	// sample data of Mesh Profile 8.6.3, service data using node identity
+	netKey := createNetKeyS("7dd7364cd842ad18c17c2b820c84c3d6", 0)
+	meshDb = &Mesh{NetKeys: map[uint]*NetKey{0: netKey}, Nodes: map[uint]*Node{0x1201: {UnicastAddress: 0x1201}}}
+	hash, _ := hex.DecodeString("00861765aefcc57b")
+	random, _ := hex.DecodeString("34ae608fbbc1f2c6")
+	addr, ok := ResolveNodeIdentity(hash, random)
+	assert.True(t, ok)
+	assert.Equal(t, uint(0x1201), addr)
+
+	random[0] ^= 0xff
+	_, ok = ResolveNodeIdentity(hash, random)
+	assert.False(t, ok)
+}
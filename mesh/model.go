@@ -348,11 +348,11 @@ var (
 		LightCTLServer:                     []int{opLightCTLGet, opLightCTLSet, opLightCTLSetUnacknowledged, opLightCTLTemperatureRangeGet, opLightCTLDefaultGet},
 		LightCTLSetupServer:                []int{opLightCTLDefaultSet, opLightCTLDefaultSetUnacknowledged, opLightCTLTemperatureRangeSet, opLightCTLTemperatureRangeSetUnacknowledged},
 		LightCTLTemperatureServer:          []int{opLightCTLTemperatureGet, opLightCTLTemperatureSet, opLightCTLTemperatureSetUnacknowledged},
-		LightHSLServer:                     []int{opLightHSLGet, opLightHSLSet, opLightHSLSetUnacknowledged, opLightHSLTargetGet, opLightHSLDefaultGet},
+		LightHSLServer:                     []int{opLightHSLGet, opLightHSLSet, opLightHSLSetUnacknowledged, opLightHSLTargetGet, opLightHSLDefaultGet, opLightHSLRangeGet},
 		LightHSLSetupServer:                []int{opLightHSLDefaultSet, opLightHSLDefaultSetUnacknowledged, opLightHSLRangeSet, opLightHSLRangeSetUnacknowledged},
 		LightHSLHueServer:                  []int{opLightHSLHueGet, opLightHSLHueSet, opLightHSLHueSetUnacknowledged},
 		LightHSLSaturationServer:           []int{opLightHSLSaturationGet, opLightHSLSaturationSet, opLightHSLSaturationSetUnacknowledged},
-		LightxyLServer:                     []int{opLightxyLGet, opLightxyLSet, opLightxyLSetUnacknowledged, opLightxyLTargetGet, opLightxyLDefaultGet},
+		LightxyLServer:                     []int{opLightxyLGet, opLightxyLSet, opLightxyLSetUnacknowledged, opLightxyLTargetGet, opLightxyLDefaultGet, opLightxyLRangeGet},
 		LightxyLSetupServer:                []int{opLightxyLDefaultSet, opLightxyLDefaultSetUnacknowledged, opLightxyLRangeSet, opLightxyLRangeSetUnacknowledged},
 		LightLCServer:                      []int{opLightLCModeGet, opLightLCModeSet, opLightLCModeSetUnacknowledged, opLightLCOMGet, opLightLCOMSet, opLightLCOMSetUnacknowledged, opLightLCLightOnOffGet, opLightLCLightOnOffSet, opLightLCLightOnOffSetUnacknowledged, opSensorStatus},
 		LightLCSetupServer:                 []int{opLightLCPropertyGet, opLightLCPropertySet, opLightLCPropertySetUnacknowledged},
@@ -383,6 +383,9 @@ var (
 		opConfigHeartbeatSubscriptionStatus:   func(d []byte) bool { return len(d) == 9 },
 		opConfigLowPowerNodePollTimeoutStatus: func(d []byte) bool { return len(d) == 5 },
 		opConfigNetworkTransmitStatus:         func(d []byte) bool { return len(d) == 1 },
+		opHealthFaultStatus:                   func(d []byte) bool { return len(d) >= 3 },
+		opHealthPeriodStatus:                  func(d []byte) bool { return len(d) == 1 },
+		opHealthAttentionStatus:               func(d []byte) bool { return len(d) == 1 },
 
 		//generic models
 		opGenericOnOffStatus:                  func(d []byte) bool { return len(d) == 1 || len(d) == 3 },
@@ -404,6 +407,23 @@ var (
 		opGenericManufacturerPropertyStatus:   func(d []byte) bool { return len(d) == 2 || len(d) > 3 },
 		opGenericClientPropertiesStatus:       func(d []byte) bool { return len(d)%2 == 0 },
 
+		// sensor models
+		opSensorDescriptorStatus: func(d []byte) bool { return len(d) >= 2 },
+		opSensorStatus:           func(d []byte) bool { return true },
+		opSensorColumnStatus:     func(d []byte) bool { return len(d) >= 2 },
+		opSensorSeriesStatus:     func(d []byte) bool { return len(d) >= 2 },
+		opSensorCadenceStatus:    func(d []byte) bool { return len(d) >= 2 },
+		opSensorSettingsStatus:   func(d []byte) bool { return len(d) >= 2 && len(d)%2 == 0 },
+		opSensorSettingStatus:    func(d []byte) bool { return len(d) >= 4 },
+		opSceneStatus:            func(d []byte) bool { return len(d) == 3 || len(d) == 6 },
+		opTimeStatus:             func(d []byte) bool { return len(d) == 5 || len(d) == 10 },
+		opTimeRoleStatus:         func(d []byte) bool { return len(d) == 1 },
+		opTimeZoneStatus:         func(d []byte) bool { return len(d) == 7 },
+		opTAI_UTCDeltaStatus:     func(d []byte) bool { return len(d) == 9 },
+		opSchedulerStatus:        func(d []byte) bool { return len(d) == 2 },
+		opSchedulerActionStatus:  func(d []byte) bool { return len(d) == schedulerEntrySize },
+		opSceneRegisterStatus:    func(d []byte) bool { return len(d) >= 3 && len(d)%2 == 1 },
+
 		//light models
 		opLightLightnessStatus:           func(d []byte) bool { return len(d) == 2 || len(d) == 5 },
 		opLightLightnessLinearStatus:     func(d []byte) bool { return len(d) == 2 || len(d) == 5 },
@@ -414,14 +434,14 @@ var (
 		opLightCTLTemperatureStatus:      func(d []byte) bool { return len(d) == 4 || len(d) == 9 },
 		opLightCTLTemperatureRangeStatus: func(d []byte) bool { return len(d) == 5 },
 		opLightCTLDefaultStatus:          func(d []byte) bool { return len(d) == 6 },
-		opLightHSLStatus:                 func(d []byte) bool { return len(d) == 7 },
-		opLightHSLTargetStatus:           func(d []byte) bool { return len(d) == 7 },
-		opLightHSLHueStatus:              func(d []byte) bool { return len(d) == 5 },
-		opLightHSLSaturationStatus:       func(d []byte) bool { return len(d) == 5 },
+		opLightHSLStatus:                 func(d []byte) bool { return len(d) == 6 || len(d) == 7 },
+		opLightHSLTargetStatus:           func(d []byte) bool { return len(d) == 6 || len(d) == 7 },
+		opLightHSLHueStatus:              func(d []byte) bool { return len(d) == 2 || len(d) == 5 },
+		opLightHSLSaturationStatus:       func(d []byte) bool { return len(d) == 2 || len(d) == 5 },
 		opLightHSLDefaultStatus:          func(d []byte) bool { return len(d) == 6 },
 		opLightHSLRangeStatus:            func(d []byte) bool { return len(d) == 9 },
-		opLightxyLStatus:                 func(d []byte) bool { return len(d) == 7 },
-		opLightxyLTargetStatus:           func(d []byte) bool { return len(d) == 7 },
+		opLightxyLStatus:                 func(d []byte) bool { return len(d) == 6 || len(d) == 7 },
+		opLightxyLTargetStatus:           func(d []byte) bool { return len(d) == 6 || len(d) == 7 },
 		opLightxyLDefaultStatus:          func(d []byte) bool { return len(d) == 6 },
 		opLightxyLRangeStatus:            func(d []byte) bool { return len(d) == 9 },
 		opLightLCModeStatus:              func(d []byte) bool { return len(d) == 1 },
@@ -472,6 +492,10 @@ var (
 		opLightCTLTemperatureStatus:      reflect.TypeOf(def.LightCTLTemperatureStatusMessageParameters{}),
 		opLightCTLTemperatureRangeStatus: reflect.TypeOf(def.LightCTLTemperatureRangeStatusMessageParameters{}),
 		opLightCTLDefaultStatus:          reflect.TypeOf(def.LightCTLDefaultStatusMessageParameters{}),
+		opLightHSLDefaultStatus:          reflect.TypeOf(def.LightHSLDefaultStatusMessageParameters{}),
+		opLightHSLRangeStatus:            reflect.TypeOf(def.LightHSLRangeStatusMessageParameters{}),
+		opLightxyLDefaultStatus:          reflect.TypeOf(def.LightXyLDefaultStatusMessageParameters{}),
+		opLightxyLRangeStatus:            reflect.TypeOf(def.LightXyLRangeStatusMessageParameters{}),
 	}
 
 	modelStateUnmarshallMap = map[uint]reflect.Type{
@@ -490,24 +514,24 @@ var (
 		// GenericManufacturerPropertyServer:  reflect.TypeOf(def.GenericOnOffStatusMessageParameters{}),
 		// GenericUserPropertyServer:          reflect.TypeOf(def.GenericOnOffStatusMessageParameters{}),
 		// GenericClientPropertyServer:        reflect.TypeOf(def.GenericOnOffStatusMessageParameters{}),
-		// SensorServer:                       reflect.TypeOf(def.GenericOnOffStatusMessageParameters{}),
+		SensorServer: reflect.TypeOf(SensorState{}),
 		// SensorSetupServer:                  reflect.TypeOf(def.GenericOnOffStatusMessageParameters{}),
-		// TimeServer:                         reflect.TypeOf(def.GenericOnOffStatusMessageParameters{}),
+		TimeServer: reflect.TypeOf(TimeState{}),
 		// TimeSetupServer:                    reflect.TypeOf(def.GenericOnOffStatusMessageParameters{}),
-		// SceneServer:                        reflect.TypeOf(def.GenericOnOffStatusMessageParameters{}),
+		SceneServer: reflect.TypeOf(SceneState{}),
 		// SceneSetupServer:                   reflect.TypeOf(def.GenericOnOffStatusMessageParameters{}),
-		// SchedulerServer:                    reflect.TypeOf(def.GenericOnOffStatusMessageParameters{}),
+		SchedulerServer: reflect.TypeOf(SchedulerState{}),
 		// SchedulerSetupServer: reflect.TypeOf(def.GenericOnOffStatusMessageParameters{}),
 		LightLightnessServer: reflect.TypeOf(LightnessState{}),
 		// LightLightnessSetupServer:          reflect.TypeOf(def.GenericOnOffStatusMessageParameters{}),
 		LightCTLServer: reflect.TypeOf(LightCtlState{}),
 		// LightCTLSetupServer:                reflect.TypeOf(def.GenericOnOffStatusMessageParameters{}),
 		LightCTLTemperatureServer: reflect.TypeOf(LightCtlState{}),
-		// LightHSLServer:                     reflect.TypeOf(def.GenericOnOffStatusMessageParameters{}),
+		LightHSLServer:            reflect.TypeOf(LightHslState{}),
 		// LightHSLSetupServer:                reflect.TypeOf(def.GenericOnOffStatusMessageParameters{}),
-		// LightHSLHueServer:                  reflect.TypeOf(def.GenericOnOffStatusMessageParameters{}),
-		// LightHSLSaturationServer:           reflect.TypeOf(def.GenericOnOffStatusMessageParameters{}),
-		// LightxyLServer:                     reflect.TypeOf(def.GenericOnOffStatusMessageParameters{}),
+		LightHSLHueServer:        reflect.TypeOf(LightHslState{}),
+		LightHSLSaturationServer: reflect.TypeOf(LightHslState{}),
+		LightxyLServer:           reflect.TypeOf(LightXylState{}),
 		// LightxyLSetupServer:                reflect.TypeOf(def.GenericOnOffStatusMessageParameters{}),
 		// LightLCServer:                      reflect.TypeOf(def.GenericOnOffStatusMessageParameters{}),
 		// LightLCSetupServer:                 reflect.TypeOf(def.GenericOnOffStatusMessageParameters{}),
@@ -608,6 +632,9 @@ func modelSendTmpl1(async bool, dst uint, opcode uint, payload []byte, timeout u
 	// 	loggerConfCli.Warnf("previous config message not finished")
 	// 	return
 	// }
+	if isGroupAddr(dst) {
+		return modelSendToGroup(dst, opcode, payload)
+	}
 	var node *Node
 	var err error
 	if node, err = findNodeByAddr(dst); err != nil {
@@ -680,7 +707,7 @@ func modelSendTmpl1(async bool, dst uint, opcode uint, payload []byte, timeout u
 		// it's a reponse of configuration request
 		tpSendAccessMsgWithDevKey(pdu, dst, 5, ackFuncWrapper)
 		return waitForResp()
-	} else if _, ok := opcodeReqRespMap[opcode]; ok {
+	} else if _, ack := opcodeReqRespMap[opcode]; ack || modelOpcodeSupported(opcode) {
 		//find the binded appkeys of the model
 
 		var targetModel *Model
@@ -713,6 +740,10 @@ func modelSendTmpl1(async bool, dst uint, opcode uint, payload []byte, timeout u
 			}
 			tpSendAccessMsgWithAppKey(appKey, netKey, pdu, dst, 5)
 		}
+		if !ack {
+			// unacknowledged message
+			return nil
+		}
 		// wait for each out message or just one transaction
 		return waitForResp()
 	} else {
@@ -721,6 +752,47 @@ func modelSendTmpl1(async bool, dst uint, opcode uint, payload []byte, timeout u
 	return nil
 }
 
+// modelOpcodeSupported tells if a server model handles the opcode
+func modelOpcodeSupported(opcode uint) bool {
+	for _, msgs := range modelMap {
+		if funk.ContainsInt(msgs, int(opcode)) {
+			return true
+		}
+	}
+	return false
+}
+
+// modelSendToGroup sends an unacknowledged message with the app key of a model subscribed to the group
+func modelSendToGroup(dst uint, opcode uint, payload []byte) error {
+	if _, ok := opcodeReqRespMap[opcode]; ok {
+		return errors.AcknowledgedMessageToGroup.New().AddContextF("opcode:%4x, group:%4x", opcode, dst)
+	}
+	for _, n := range meshDb.Nodes {
+		for _, e := range n.Elements {
+			for _, m := range e.Models {
+				if len(m.BindedAppKeyIds) == 0 || !funk.ContainsInt(modelMap[m.ModelID], int(opcode)) {
+					continue
+				}
+				for _, addr := range m.SubAddresses {
+					if addr != dst {
+						continue
+					}
+					appKey, err := n.findNodeAppKeyByIndex(m.BindedAppKeyIds[0])
+					if err != nil {
+						return err
+					}
+					netKey, err := n.findNodeNetKeyByAppKeyIndex(m.BindedAppKeyIds[0])
+					if err != nil {
+						return err
+					}
+					return tpSendAccessMsg(1, appKey, netKey, generateRequest(opcode, payload), dst, 5, nil)
+				}
+			}
+		}
+	}
+	return errors.ModelNotFound.New().AddContextF("no model subscribed to group %4x handles opcode %4x", dst, opcode)
+}
+
 func registerModelMessageRxListener(cb *modelMsglistener) {
 	modelMsgListeners = append(modelMsgListeners, cb)
 }
@@ -747,7 +819,9 @@ func modelMessageReceive(src, dst uint, data []byte) {
 			loggerModel.Errorf("unexpected response, expected opcode: %4x, actual: %4x", txMsg.expectedRespOpcode, msg.opcode)
 		}
 	}
-	// todo: message publication
+	for _, l := range modelMsgListeners {
+		(*l)(msg)
+	}
 }
 
 func extractPayload(data []byte) (uint, []byte) {
@@ -765,6 +839,32 @@ func extractPayload(data []byte) (uint, []byte) {
 	return opCode, data[opSize:]
 }
 
+// transitionTime encodes the milliseconds in the transition time format, 6 bits
+// of steps with the finest of the 100 ms, 1 s, 10 s and 10 min step resolutions
+func transitionTime(ms uint) uint {
+	for i, resolution := range []uint{100, 1000, 10000, 600000} {
+		if steps := ms / resolution; steps <= 0x3E {
+			return uint(i)<<6 | steps
+		}
+	}
+	return 0x3<<6 | 0x3E
+}
+
+// transitionParams appends the TID, the transition time of the milliseconds and no delay to the parameters
+func transitionParams(params []byte, transition uint) []byte {
+	transactionIdentifier++
+	return append(params, byte(transactionIdentifier), byte(transitionTime(transition)), 0)
+}
+
+// uint16Params packs the 16 bit values in little endian
+func uint16Params(values ...uint) []byte {
+	params := make([]byte, 2*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint16(params[2*i:], uint16(v))
+	}
+	return params
+}
+
 func generateRequest(op uint, args ...interface{}) []byte {
 	buffer := bytes.NewBuffer([]byte{})
 	if op <= 0xFF {
@@ -8,7 +8,6 @@ import (
 	"bytes"
 	"encoding/binary"
 
-	"github.com/jinzhu/copier"
 	funk "github.com/thoas/go-funk"
 )
 
@@ -231,7 +230,8 @@ func handleCompData(src uint, data []byte) error {
 		Relay:  comp.Features.Relay,
 		Lpn:    comp.Features.LowPower,
 	}
-	if node.Elements == nil || len(node.Elements) == 0 {
+	// elements created during provisioning only carry the addresses
+	if node.Elements == nil || len(node.Elements) == 0 || len(node.Elements[0].Models) == 0 {
 		node.Elements = []*Element{}
 		var addr uint
 		if comp.Page == 0 {
@@ -746,20 +746,22 @@ func ConfigNetKeyAdd(dst uint, netkeyIndex uint) error {
 	})
 }
 
+// ConfigNetKeyUpdate sends the new key of the net key being refreshed, see RefreshNetKey
 func ConfigNetKeyUpdate(dst uint, netkeyIndex uint) error {
-	newKey := createNetKey(netkeyIndex)
-	oldkey, err := findNetKeyByIndex(netkeyIndex)
+	key, err := findNetKeyByIndex(netkeyIndex)
 	if err != nil {
 		return err
 	}
+	if key.KeyRefreshPhase != 1 {
+		return errors.InvalidKeyRefreshPhase.New().AddContextF("netkeyIndex:%d, phase:%d", netkeyIndex, key.KeyRefreshPhase)
+	}
 	params := &ConfigNetKeyUpdateMessageParameters{
 		NetKeyIndex: netkeyIndex,
-		NetKey:      newKey.Bytes,
+		NetKey:      key.Bytes,
 	}
 	return modelSendTmplParsed(false, dst, opConfigNetKeyUpdate, params, func(n *Node, d interface{}) error {
 		resp := d.(ConfigNetKeyStatusMessageParameters)
 		if resp.Status == STATUS_SUCCESS {
-			copier.Copy(oldkey, newKey)
 			return nil
 		}
 		return errors.InvalidResponse.New()
@@ -848,17 +850,20 @@ func ConfigAppKeyAdd(dst, netKeyIndex, appKeyIndex uint) error {
 	})
 }
 
+// ConfigAppKeyUpdate sends the new key of the app key being refreshed, see RefreshNetKey
 func ConfigAppKeyUpdate(dst, netKeyIndex, appKeyIndex uint) error {
 	node, _ := findNodeByAddr(dst)
-	_, err := node.findNodeAppKeyByIndex(appKeyIndex)
+	key, err := node.findNodeAppKeyByIndex(appKeyIndex)
 	if err != nil {
 		return err
 	}
-	newKey := createAppKey(appKeyIndex)
+	if key.KeyRefreshPhase != 1 {
+		return errors.InvalidKeyRefreshPhase.New().AddContextF("appkeyIndex:%d, phase:%d", appKeyIndex, key.KeyRefreshPhase)
+	}
 	params := &ConfigAppKeyUpdateMessageParameters{
 		NetKeyIndex: netKeyIndex,
 		AppKeyIndex: appKeyIndex,
-		AppKey:      newKey.Bytes,
+		AppKey:      key.Bytes,
 	}
 	return modelSendTmplParsed(false, dst, opConfigAppKeyUpdate, params, func(n *Node, d interface{}) error {
 		resp := d.(ConfigAppKeyStatusMessageParameters)
@@ -1079,6 +1084,7 @@ func ConfigVendorModelAppGet(elementAddr, modelId uint) error {
 func ConfigNodeReset(dst uint) error {
 	return modelSendTmplParsed(false, dst, opConfigNodeReset, nil, func(n *Node, d interface{}) error {
 		deleteNode(dst)
+		rplReset(dst, len(n.Elements))
 		loggerFoundation.Info("node reset finished")
 		return nil
 	})
@@ -1101,7 +1107,83 @@ func ConfigFriendSet(dst, friendState uint) error {
 	return modelSendTmplParsed(false, dst, opConfigFriendSet, params, handleFriendResponse)
 }
 
-// todo: key refresh, heartbeat
+func handleKeyRefreshPhaseResponse(n *Node, d interface{}) error {
+	resp := d.(ConfigKeyRefreshPhaseStatusMessageParameters)
+	if resp.Status == STATUS_SUCCESS {
+		n.KeyRefreshPhaseState = resp.Phase
+		return nil
+	}
+	return errors.InvalidResponse.New()
+}
+
+func ConfigKeyRefreshPhaseGet(dst, netKeyIndex uint) error {
+	params := &ConfigKeyRefreshPhaseGetMessageParameters{
+		NetKeyIndex: netKeyIndex,
+	}
+	return modelSendTmplParsed(false, dst, opConfigKeyRefreshPhaseGet, params, handleKeyRefreshPhaseResponse)
+}
+
+// ConfigKeyRefreshPhaseSet triggers the transition to phase 2 (use new keys) or 3 (revoke old keys)
+func ConfigKeyRefreshPhaseSet(dst, netKeyIndex, transition uint) error {
+	if transition != 2 && transition != 3 {
+		return errors.InvalidKeyRefreshPhase.New().AddContextF("transition:%d", transition)
+	}
+	params := &ConfigKeyRefreshPhaseSetMessageParameters{
+		NetKeyIndex: netKeyIndex,
+		Transition:  transition,
+	}
+	return modelSendTmplParsed(false, dst, opConfigKeyRefreshPhaseSet, params, handleKeyRefreshPhaseResponse)
+}
+
+func handleHeartbeatPublicationResponse(n *Node, d interface{}) error {
+	resp := d.(ConfigHeartbeatPublicationStatusMessageParameters)
+	if resp.Status == STATUS_SUCCESS {
+		n.HeartbeatPublicationState = resp
+		return nil
+	}
+	return errors.InvalidResponse.New().AddContextF("status:%d", resp.Status)
+}
+
+func ConfigHeartbeatPublicationGet(dst uint) error {
+	return modelSendTmplParsed(false, dst, opConfigHeartbeatPublicationGet, nil, handleHeartbeatPublicationResponse)
+}
+
+// ConfigHeartbeatPublicationSet makes the node send 2^(countLog-1) heartbeats every 2^(periodLog-1) seconds,
+// countLog 0xff sends them indefinitely
+func ConfigHeartbeatPublicationSet(dst, destination, countLog, periodLog, ttl, features, netKeyIndex uint) error {
+	params := &ConfigHeartbeatPublicationSetMessageParameters{
+		Destination: destination,
+		CountLog:    countLog,
+		PeriodLog:   periodLog,
+		TTL:         ttl,
+		Features:    features,
+		NetKeyIndex: netKeyIndex,
+	}
+	return modelSendTmplParsed(false, dst, opConfigHeartbeatPublicationSet, params, handleHeartbeatPublicationResponse)
+}
+
+func handleHeartbeatSubscriptionResponse(n *Node, d interface{}) error {
+	resp := d.(ConfigHeartbeatSubscriptionStatusMessageParameters)
+	if resp.Status == STATUS_SUCCESS {
+		n.HeartbeatSubscriptionState = resp
+		return nil
+	}
+	return errors.InvalidResponse.New().AddContextF("status:%d", resp.Status)
+}
+
+func ConfigHeartbeatSubscriptionGet(dst uint) error {
+	return modelSendTmplParsed(false, dst, opConfigHeartbeatSubscriptionGet, nil, handleHeartbeatSubscriptionResponse)
+}
+
+// ConfigHeartbeatSubscriptionSet makes the node count the heartbeats from source to destination for 2^(periodLog-1) seconds
+func ConfigHeartbeatSubscriptionSet(dst, source, destination, periodLog uint) error {
+	params := &ConfigHeartbeatSubscriptionSetMessageParameters{
+		Source:      source,
+		Destination: destination,
+		PeriodLog:   periodLog,
+	}
+	return modelSendTmplParsed(false, dst, opConfigHeartbeatSubscriptionSet, params, handleHeartbeatSubscriptionResponse)
+}
 
 func ConfigLowPowerNodePollTimeoutGet(friendAddr, lpnAddr uint) error {
 	params := &ConfigLowPowerNodePollTimeoutGetMessageParameters{
@@ -1131,5 +1213,3 @@ func ConfigNetworkTransmitSet(dst uint, count, step uint) error {
 	}
 	return modelSendTmplParsed(false, dst, opConfigNetworkTransmitSet, params, handleNetworkTransmitResponse)
 }
-
-// todo: health messages
@@ -0,0 +1,218 @@
+package mesh
+
+import (
+	"ble-mesh/mesh/db"
+	. "ble-mesh/mesh/def"
+	"ble-mesh/utils"
+	"reflect"
+	"sort"
+	"time"
+)
+
+type (
+	HealthFault struct {
+		Code        uint
+		Description string
+	}
+
+	HealthFaultRecord struct {
+		Time      time.Time
+		TestID    uint
+		CompanyID uint
+		Faults    []HealthFault
+	}
+
+	// FaultyNode is a node whose latest current status reports faults
+	FaultyNode struct {
+		UnicastAddress uint
+		HealthFaultRecord
+	}
+)
+
+const (
+	// the current faults kept per node
+	healthFaultHistorySize = 32
+)
+
+var (
+	loggerHealthCli = utils.CreateLogger("HealthClient")
+	healthListener  = modelMsglistener(healthCurrentStatusReceive)
+)
+
+func init() {
+	registerModelMessageRxListener(&healthListener)
+}
+
+// decodeHealthFaults describes the fault codes with the fault values of the specification
+func decodeHealthFaults(codes []uint) []HealthFault {
+	faults := []HealthFault{}
+	for _, code := range codes {
+		fault := HealthFault{Code: code}
+		for r, s := range FaultValues {
+			if r.Start <= code && r.End >= code {
+				fault.Description = s
+				break
+			}
+		}
+		faults = append(faults, fault)
+	}
+	return faults
+}
+
+func healthFaultCodes(faults []HealthFault) []uint {
+	codes := []uint{}
+	for _, f := range faults {
+		codes = append(codes, f.Code)
+	}
+	return codes
+}
+
+func healthFaultRecordsFromDb(recordsRaw []db.FaultRecord) []HealthFaultRecord {
+	records := []HealthFaultRecord{}
+	for _, r := range recordsRaw {
+		records = append(records, HealthFaultRecord{
+			Time:      time.Unix(r.Time, 0),
+			TestID:    r.TestID,
+			CompanyID: r.CompanyID,
+			Faults:    decodeHealthFaults(r.Faults),
+		})
+	}
+	return records
+}
+
+func healthFaultRecordsToDb(records []HealthFaultRecord) []db.FaultRecord {
+	recordsRaw := []db.FaultRecord{}
+	for _, r := range records {
+		recordsRaw = append(recordsRaw, db.FaultRecord{
+			Time:      r.Time.Unix(),
+			TestID:    r.TestID,
+			CompanyID: r.CompanyID,
+			Faults:    healthFaultCodes(r.Faults),
+		})
+	}
+	return recordsRaw
+}
+
+// recordCurrentFault adds the current faults to the history of the node if they changed
+func (n *Node) recordCurrentFault(testID, companyID uint, codes []uint) bool {
+	if l := len(n.CurrentFault); l > 0 {
+		last := n.CurrentFault[l-1]
+		if last.TestID == testID && last.CompanyID == companyID && reflect.DeepEqual(healthFaultCodes(last.Faults), codes) {
+			return false
+		}
+	}
+	n.CurrentFault = append(n.CurrentFault, HealthFaultRecord{
+		Time:      time.Now(),
+		TestID:    testID,
+		CompanyID: companyID,
+		Faults:    decodeHealthFaults(codes),
+	})
+	if len(n.CurrentFault) > healthFaultHistorySize {
+		n.CurrentFault = n.CurrentFault[len(n.CurrentFault)-healthFaultHistorySize:]
+	}
+	return true
+}
+
+// healthCurrentStatusReceive records the current status published by the health servers
+func healthCurrentStatusReceive(msg *AccessMessage) {
+	if msg.opcode != opHealthCurrentStatus {
+		return
+	}
+	node, err := findNodeByAddr(msg.src)
+	if err != nil {
+		loggerHealthCli.Warn(err)
+		return
+	}
+	status := &HealthCurrentStatusMessageParameters{}
+	if len(msg.payload) < 3 || msg.Parse(status) != nil {
+		loggerHealthCli.Warnf("invalid current status from %04x: % 2x", msg.src, msg.payload)
+		return
+	}
+	if node.recordCurrentFault(status.TestID, status.CompanyID, status.FaultArray) {
+		loggerHealthCli.Infof("current faults of %04x: %+v", msg.src, node.CurrentFault[len(node.CurrentFault)-1].Faults)
+		writeNodeToDb(node)
+	}
+}
+
+// GetFaultyNodes returns the nodes currently reporting faults
+func GetFaultyNodes() []FaultyNode {
+	nodes := []FaultyNode{}
+	for _, n := range meshDb.Nodes {
+		if len(n.CurrentFault) == 0 {
+			continue
+		}
+		last := n.CurrentFault[len(n.CurrentFault)-1]
+		if len(last.Faults) > 0 {
+			nodes = append(nodes, FaultyNode{UnicastAddress: n.UnicastAddress, HealthFaultRecord: last})
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].UnicastAddress < nodes[j].UnicastAddress })
+	return nodes
+}
+
+func handleHealthFaultResponse(n *Node, d interface{}) error {
+	resp := d.(HealthFaultStatusMessageParameters)
+	n.RegisteredFault = decodeHealthFaults(resp.FaultArray)
+	loggerHealthCli.Debugf("registered faults of %04x: %+v", n.UnicastAddress, n.RegisteredFault)
+	return nil
+}
+
+// HealthFaultGet reads the registered faults of the company
+func HealthFaultGet(dst, companyID uint) error {
+	params := &HealthFaultGetMessageParameters{
+		CompanyID: companyID,
+	}
+	return modelSendTmplParsed(false, dst, opHealthFaultGet, params, handleHealthFaultResponse)
+}
+
+func HealthFaultClear(dst, companyID uint) error {
+	params := &HealthFaultClearMessageParameters{
+		CompanyID: companyID,
+	}
+	return modelSendTmplParsed(false, dst, opHealthFaultClear, params, handleHealthFaultResponse)
+}
+
+// HealthFaultTest runs the self test, the faults found are registered
+func HealthFaultTest(dst, testID, companyID uint) error {
+	params := &HealthFaultTestMessageParameters{
+		TestID:    testID,
+		CompanyID: companyID,
+	}
+	return modelSendTmplParsed(false, dst, opHealthFaultTest, params, handleHealthFaultResponse)
+}
+
+func handleHealthPeriodResponse(n *Node, d interface{}) error {
+	resp := d.(HealthPeriodStatusMessageParameters)
+	n.HealthPeriod = resp.FastPeriodDivisor
+	return nil
+}
+
+func HealthPeriodGet(dst uint) error {
+	return modelSendTmplParsed(false, dst, opHealthPeriodGet, nil, handleHealthPeriodResponse)
+}
+
+// HealthPeriodSet divides the publish period of the current status by 2^fastPeriodDivisor while there are faults
+func HealthPeriodSet(dst, fastPeriodDivisor uint) error {
+	params := &HealthPeriodSetMessageParameters{
+		FastPeriodDivisor: fastPeriodDivisor,
+	}
+	return modelSendTmplParsed(false, dst, opHealthPeriodSet, params, handleHealthPeriodResponse)
+}
+
+func handleHealthAttentionResponse(n *Node, d interface{}) error {
+	resp := d.(AttentionStatusMessageParameters)
+	n.AttentionTimer = resp.Attention
+	return nil
+}
+
+func HealthAttentionGet(dst uint) error {
+	return modelSendTmplParsed(false, dst, opHealthAttentionGet, nil, handleHealthAttentionResponse)
+}
+
+// HealthAttentionSet makes the node attract attention for the seconds given
+func HealthAttentionSet(dst, attention uint) error {
+	params := &HealthAttentionSetMessageParameters{
+		Attention: attention,
+	}
+	return modelSendTmplParsed(false, dst, opHealthAttentionSet, params, handleHealthAttentionResponse)
+}
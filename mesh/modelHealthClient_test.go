@@ -0,0 +1,32 @@
+package mesh
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_healthCurrentStatusReceive(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "health")
+	defer os.RemoveAll(dir)
+	confDir = dir
+	node := &Node{UnicastAddress: 0x0100, Elements: []*Element{{UnicastAddress: 0x0100}, {UnicastAddress: 0x0101}}}
+	meshDb = &Mesh{Nodes: map[uint]*Node{0x0100: node}}
+
+	// battery low warning and a vendor specific fault of company 0x0059
+	status := []byte{0x00, 0x59, 0x00, 0x01, 0x80}
+	modelMessageReceive(0x0101, 0x0001, append([]byte{opHealthCurrentStatus}, status...))
+	modelMessageReceive(0x0101, 0x0001, append([]byte{opHealthCurrentStatus}, status...))
+	assert.Len(t, node.CurrentFault, 1)
+	assert.Equal(t, uint(0x0059), node.CurrentFault[0].CompanyID)
+	assert.Equal(t, []HealthFault{{1, "Battery Low Warning"}, {0x80, "Vendor Specific Warning / Error"}}, node.CurrentFault[0].Faults)
+	assert.Len(t, GetFaultyNodes(), 1)
+
+	// the faults are gone
+	modelMessageReceive(0x0100, 0x0001, []byte{opHealthCurrentStatus, 0x00, 0x59, 0x00})
+	assert.Len(t, node.CurrentFault, 2)
+	assert.Empty(t, GetFaultyNodes())
+	assert.Len(t, healthFaultRecordsFromDb(healthFaultRecordsToDb(node.CurrentFault)), 2)
+}
@@ -62,7 +62,7 @@ const (
 	opLightHSLDefaultSet                        = 0x827F
 	opLightHSLDefaultSetUnacknowledged          = 0x8280
 	opLightHSLRangeSet                          = 0x8281
-	opLightHSLRangeSetUnacknowledged            = 0x82
+	opLightHSLRangeSetUnacknowledged            = 0x8282
 	opLightxyLGet                               = 0x8283
 	opLightxyLSet                               = 0x8284
 	opLightxyLSetUnacknowledged                 = 0x8285
@@ -0,0 +1,300 @@
+package mesh
+
+import (
+	. "ble-mesh/mesh/def"
+	"ble-mesh/utils/errors"
+	"encoding/binary"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// LightHslState is the state of the HSL, HSL Hue and HSL Saturation server models
+type LightHslState struct {
+	Lightness          uint `json:"lightness"`
+	Hue                uint `json:"hue"`
+	Saturation         uint `json:"saturation"`
+	TargetLightness    uint `json:"targetLightness"`
+	TargetHue          uint `json:"targetHue"`
+	TargetSaturation   uint `json:"targetSaturation"`
+	RemainingTime      uint `json:"remainingTime"`
+	LightnessDefault   uint `json:"lightnessDefault"`
+	HueDefault         uint `json:"hueDefault"`
+	SaturationDefault  uint `json:"saturationDefault"`
+	HueRangeMin        uint `json:"hueRangeMin"`
+	HueRangeMax        uint `json:"hueRangeMax"`
+	SaturationRangeMin uint `json:"saturationRangeMin"`
+	SaturationRangeMax uint `json:"saturationRangeMax"`
+}
+
+// parseHexColor parses the "#ffaa00" or "#fa0" color in 8 bit red, green and blue
+func parseHexColor(color string) (uint, uint, uint, error) {
+	hex := strings.TrimPrefix(color, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 6 || err != nil {
+		return 0, 0, 0, errors.InvalidColor.New().AddContextF("color: %s", color)
+	}
+	return uint(v>>16) & 0xFF, uint(v>>8) & 0xFF, uint(v) & 0xFF, nil
+}
+
+// RgbToHsl converts the 8 bit red, green and blue in the 16 bit HSL lightness, hue and saturation
+func RgbToHsl(r, g, b uint) (uint, uint, uint) {
+	rf, gf, bf := float64(r&0xFF)/0xFF, float64(g&0xFF)/0xFF, float64(b&0xFF)/0xFF
+	max := math.Max(rf, math.Max(gf, bf))
+	min := math.Min(rf, math.Min(gf, bf))
+	l := (max + min) / 2
+	var h, s float64
+	if d := max - min; d > 0 {
+		if l > 0.5 {
+			s = d / (2 - max - min)
+		} else {
+			s = d / (max + min)
+		}
+		switch max {
+		case rf:
+			h = (gf - bf) / d
+			if h < 0 {
+				h += 6
+			}
+		case gf:
+			h = (bf-rf)/d + 2
+		default:
+			h = (rf-gf)/d + 4
+		}
+		h /= 6
+	}
+	return uint(math.Round(l * 0xFFFF)), uint(math.Round(h*0x10000)) & 0xFFFF, uint(math.Round(s * 0xFFFF))
+}
+
+// HexToHsl converts the "#ffaa00" color in the 16 bit HSL lightness, hue and saturation
+func HexToHsl(color string) (uint, uint, uint, error) {
+	r, g, b, err := parseHexColor(color)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	l, h, s := RgbToHsl(r, g, b)
+	return l, h, s, nil
+}
+
+func (m *Model) lightHslState() LightHslState {
+	state, _ := m.State.(LightHslState)
+	return state
+}
+
+func (m *Model) handleLightHslResponse(msg *AccessMessage) error {
+	state := m.lightHslState()
+	d := msg.payload
+	l, h, s := uint(binary.LittleEndian.Uint16(d)), uint(binary.LittleEndian.Uint16(d[2:])), uint(binary.LittleEndian.Uint16(d[4:]))
+	remaining := uint(0)
+	if len(d) == 7 {
+		remaining = uint(d[6])
+	}
+	if msg.opcode == opLightHSLTargetStatus {
+		state.TargetLightness, state.TargetHue, state.TargetSaturation = l, h, s
+	} else {
+		state.Lightness, state.Hue, state.Saturation = l, h, s
+		loggerLightCli.Debugf("present lightness: %d%%, hue: %x, saturation: %x", calcLightnessPrc(l), h, s)
+	}
+	state.RemainingTime = remaining
+	m.State = state
+	return nil
+}
+
+func LightHslGet(dst uint) error {
+	m, err := findModelDirectly(dst, LightHSLServer)
+	if err != nil {
+		return err
+	}
+	return modelSendTmpl(false, dst, opLightHSLGet, nil, m.handleLightHslResponse)
+}
+
+func lightHslSet(ack bool, dst uint, lightness, hue, saturation, transition uint) error {
+	params := transitionParams(uint16Params(lightness, hue, saturation), transition)
+	if !ack {
+		return modelSendTmpl(false, dst, opLightHSLSetUnacknowledged, params, nil)
+	}
+	m, err := findModelDirectly(dst, LightHSLServer)
+	if err != nil {
+		return err
+	}
+	return modelSendTmpl(false, dst, opLightHSLSet, params, m.handleLightHslResponse)
+}
+
+// LightHslSet sets the HSL lightness, hue and saturation with the transition in milliseconds
+func LightHslSet(dst uint, lightness, hue, saturation, transition uint) error {
+	return lightHslSet(true, dst, lightness, hue, saturation, transition)
+}
+
+func LightHslSetUnacknowledged(dst uint, lightness, hue, saturation, transition uint) error {
+	return lightHslSet(false, dst, lightness, hue, saturation, transition)
+}
+
+// LightHslSetColor sets the "#ffaa00" color, unacknowledged if the destination is a group
+func LightHslSetColor(dst uint, color string, transition uint) error {
+	l, h, s, err := HexToHsl(color)
+	if err != nil {
+		return err
+	}
+	return lightHslSet(!isGroupAddr(dst), dst, l, h, s, transition)
+}
+
+func LightHslTargetGet(dst uint) error {
+	m, err := findModelDirectly(dst, LightHSLServer)
+	if err != nil {
+		return err
+	}
+	return modelSendTmpl(false, dst, opLightHSLTargetGet, nil, m.handleLightHslResponse)
+}
+
+func (m *Model) handleLightHslDefaultResponse(n *Node, d interface{}) error {
+	resp := d.(LightHSLDefaultStatusMessageParameters)
+	state := m.lightHslState()
+	state.LightnessDefault = resp.Lightness
+	state.HueDefault = resp.Hue
+	state.SaturationDefault = resp.Saturation
+	m.State = state
+	return nil
+}
+
+func LightHslDefaultGet(dst uint) error {
+	m, err := findModelDirectly(dst, LightHSLServer)
+	if err != nil {
+		return err
+	}
+	return modelSendTmplParsed(false, dst, opLightHSLDefaultGet, nil, m.handleLightHslDefaultResponse)
+}
+
+func LightHslDefaultSet(dst uint, lightness, hue, saturation uint) error {
+	m, err := findModelDirectly(dst, LightHSLServer)
+	if err != nil {
+		return err
+	}
+	req := &LightHSLDefaultSetMessageParameters{
+		Lightness:  lightness,
+		Hue:        hue,
+		Saturation: saturation,
+	}
+	return modelSendTmplParsed(false, dst, opLightHSLDefaultSet, req, m.handleLightHslDefaultResponse)
+}
+
+func (m *Model) handleLightHslRangeResponse(n *Node, d interface{}) error {
+	resp := d.(LightHSLRangeStatusMessageParameters)
+	if resp.StatusCode == 0 {
+		state := m.lightHslState()
+		state.HueRangeMin = resp.HueRangeMin
+		state.HueRangeMax = resp.HueRangeMax
+		state.SaturationRangeMin = resp.SaturationRangeMin
+		state.SaturationRangeMax = resp.SaturationRangeMax
+		m.State = state
+		loggerLightCli.Debugf("hue range min: %x, max: %x, saturation range min: %x, max: %x",
+			resp.HueRangeMin, resp.HueRangeMax, resp.SaturationRangeMin, resp.SaturationRangeMax)
+	} else if resp.StatusCode == 1 {
+		return errors.CannotSetRangeMin.New()
+	} else if resp.StatusCode == 2 {
+		return errors.CannotSetRangeMax.New()
+	}
+	return nil
+}
+
+func LightHslRangeGet(dst uint) error {
+	m, err := findModelDirectly(dst, LightHSLServer)
+	if err != nil {
+		return err
+	}
+	return modelSendTmplParsed(false, dst, opLightHSLRangeGet, nil, m.handleLightHslRangeResponse)
+}
+
+func LightHslRangeSet(dst uint, hueMin, hueMax, saturationMin, saturationMax uint) error {
+	m, err := findModelDirectly(dst, LightHSLServer)
+	if err != nil {
+		return err
+	}
+	req := &LightHSLRangeSetMessageParameters{
+		HueRangeMin:        hueMin,
+		HueRangeMax:        hueMax,
+		SaturationRangeMin: saturationMin,
+		SaturationRangeMax: saturationMax,
+	}
+	return modelSendTmplParsed(false, dst, opLightHSLRangeSet, req, m.handleLightHslRangeResponse)
+}
+
+// handleLightHslComponentResponse records the hue or the saturation status, the
+// target value and the remaining time are only sent during a transition
+func (m *Model) handleLightHslComponentResponse(msg *AccessMessage) error {
+	state := m.lightHslState()
+	d := msg.payload
+	present, target := uint(binary.LittleEndian.Uint16(d)), uint(binary.LittleEndian.Uint16(d))
+	state.RemainingTime = 0
+	if len(d) == 5 {
+		target = uint(binary.LittleEndian.Uint16(d[2:]))
+		state.RemainingTime = uint(d[4])
+	}
+	if msg.opcode == opLightHSLHueStatus {
+		state.Hue, state.TargetHue = present, target
+	} else {
+		state.Saturation, state.TargetSaturation = present, target
+	}
+	m.State = state
+	return nil
+}
+
+// LightHslHueGet reads the hue of the element of the HSL Hue server
+func LightHslHueGet(dst uint) error {
+	m, err := findModelDirectly(dst, LightHSLHueServer)
+	if err != nil {
+		return err
+	}
+	return modelSendTmpl(false, dst, opLightHSLHueGet, nil, m.handleLightHslComponentResponse)
+}
+
+func lightHslHueSet(ack bool, dst uint, hue, transition uint) error {
+	params := transitionParams(uint16Params(hue), transition)
+	if !ack {
+		return modelSendTmpl(false, dst, opLightHSLHueSetUnacknowledged, params, nil)
+	}
+	m, err := findModelDirectly(dst, LightHSLHueServer)
+	if err != nil {
+		return err
+	}
+	return modelSendTmpl(false, dst, opLightHSLHueSet, params, m.handleLightHslComponentResponse)
+}
+
+func LightHslHueSet(dst uint, hue, transition uint) error {
+	return lightHslHueSet(true, dst, hue, transition)
+}
+
+func LightHslHueSetUnacknowledged(dst uint, hue, transition uint) error {
+	return lightHslHueSet(false, dst, hue, transition)
+}
+
+// LightHslSaturationGet reads the saturation of the element of the HSL Saturation server
+func LightHslSaturationGet(dst uint) error {
+	m, err := findModelDirectly(dst, LightHSLSaturationServer)
+	if err != nil {
+		return err
+	}
+	return modelSendTmpl(false, dst, opLightHSLSaturationGet, nil, m.handleLightHslComponentResponse)
+}
+
+func lightHslSaturationSet(ack bool, dst uint, saturation, transition uint) error {
+	params := transitionParams(uint16Params(saturation), transition)
+	if !ack {
+		return modelSendTmpl(false, dst, opLightHSLSaturationSetUnacknowledged, params, nil)
+	}
+	m, err := findModelDirectly(dst, LightHSLSaturationServer)
+	if err != nil {
+		return err
+	}
+	return modelSendTmpl(false, dst, opLightHSLSaturationSet, params, m.handleLightHslComponentResponse)
+}
+
+func LightHslSaturationSet(dst uint, saturation, transition uint) error {
+	return lightHslSaturationSet(true, dst, saturation, transition)
+}
+
+func LightHslSaturationSetUnacknowledged(dst uint, saturation, transition uint) error {
+	return lightHslSaturationSet(false, dst, saturation, transition)
+}
@@ -0,0 +1,44 @@
+package mesh
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_HexToHsl(t *testing.T) {
+	l, h, s, err := HexToHsl("#ff0000")
+	assert.Nil(t, err)
+	assert.Equal(t, []uint{0x8000, 0x0000, 0xFFFF}, []uint{l, h, s})
+
+	// hue of 120° and 240°
+	_, h, _, _ = HexToHsl("00ff00")
+	assert.Equal(t, uint(0x5555), h)
+	_, h, _, _ = HexToHsl("#00f")
+	assert.Equal(t, uint(0xAAAB), h)
+
+	l, h, s, _ = HexToHsl("#ffffff")
+	assert.Equal(t, []uint{0xFFFF, 0, 0}, []uint{l, h, s})
+
+	// orange: 40° hue, full saturation
+	l, h, s, _ = HexToHsl("#ffaa00")
+	assert.Equal(t, []uint{0x8000, 0x1C72, 0xFFFF}, []uint{l, h, s})
+
+	_, _, _, err = HexToHsl("#ffaa0")
+	assert.NotNil(t, err)
+	_, _, _, err = HexToHsl("orange")
+	assert.NotNil(t, err)
+}
+
+func Test_handleLightHslResponse(t *testing.T) {
+	m := &Model{ModelID: LightHSLServer}
+	m.handleLightHslResponse(&AccessMessage{opcode: opLightHSLStatus, payload: []byte{0x00, 0x80, 0x72, 0x1c, 0xff, 0xff}})
+	m.handleLightHslResponse(&AccessMessage{opcode: opLightHSLTargetStatus, payload: []byte{0xff, 0xff, 0x00, 0x00, 0x00, 0x00, 0x45}})
+	assert.Equal(t, LightHslState{Lightness: 0x8000, Hue: 0x1c72, Saturation: 0xffff, TargetLightness: 0xffff, RemainingTime: 0x45}, m.State)
+
+	m.handleLightHslComponentResponse(&AccessMessage{opcode: opLightHSLSaturationStatus, payload: []byte{0x00, 0x10}})
+	state := m.State.(LightHslState)
+	assert.Equal(t, uint(0x1000), state.Saturation)
+	assert.Equal(t, uint(0x1000), state.TargetSaturation)
+	assert.Equal(t, uint(0), state.RemainingTime)
+}
@@ -0,0 +1,296 @@
+package mesh
+
+import (
+	. "ble-mesh/mesh/def"
+	"ble-mesh/utils/errors"
+	"encoding/binary"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// LightXylState is the state of the xyL server model, x and y are the CIE 1931
+// chromaticity coordinates scaled to 16 bits
+type LightXylState struct {
+	Lightness        uint `json:"lightness"`
+	X                uint `json:"x"`
+	Y                uint `json:"y"`
+	TargetLightness  uint `json:"targetLightness"`
+	TargetX          uint `json:"targetX"`
+	TargetY          uint `json:"targetY"`
+	RemainingTime    uint `json:"remainingTime"`
+	LightnessDefault uint `json:"lightnessDefault"`
+	XDefault         uint `json:"xDefault"`
+	YDefault         uint `json:"yDefault"`
+	XRangeMin        uint `json:"xRangeMin"`
+	XRangeMax        uint `json:"xRangeMax"`
+	YRangeMin        uint `json:"yRangeMin"`
+	YRangeMax        uint `json:"yRangeMax"`
+}
+
+const (
+	// range of the approximation of the Planckian locus
+	cctMin = 1667
+	cctMax = 25000
+	// chromaticity of the D65 white point of sRGB
+	d65X = 0.3127
+	d65Y = 0.3290
+)
+
+// encodeCie scales the chromaticity coordinate in [0, 1] to 16 bits
+func encodeCie(c float64) uint {
+	return uint(math.Round(math.Max(0, math.Min(1, c)) * 0xFFFF))
+}
+
+func decodeCie(v uint) float64 {
+	return float64(v) / 0xFFFF
+}
+
+// CctToXy converts the correlated color temperature in Kelvin to the 16 bit x and y
+// of the Planckian locus, with the cubic spline approximation of Kim et al.
+func CctToXy(temperature uint) (uint, uint, error) {
+	if temperature < cctMin || temperature > cctMax {
+		return 0, 0, errors.InvalidColor.New().AddContextF("temperature: %dK", temperature)
+	}
+	t := float64(temperature)
+	var x, y float64
+	if t <= 4000 {
+		x = -0.2661239e9/(t*t*t) - 0.2343589e6/(t*t) + 0.8776956e3/t + 0.179910
+	} else {
+		x = -3.0258469e9/(t*t*t) + 2.1070379e6/(t*t) + 0.2226347e3/t + 0.240390
+	}
+	switch {
+	case t <= 2222:
+		y = -1.1063814*x*x*x - 1.34811020*x*x + 2.18555832*x - 0.20219683
+	case t <= 4000:
+		y = -0.9549476*x*x*x - 1.37418593*x*x + 2.09137015*x - 0.16748867
+	default:
+		y = 3.0817580*x*x*x - 5.87338670*x*x + 3.75112997*x - 0.37001483
+	}
+	return encodeCie(x), encodeCie(y), nil
+}
+
+// XyToCct approximates the correlated color temperature of the 16 bit x and y with McCamy's formula
+func XyToCct(x, y uint) uint {
+	n := (decodeCie(x) - 0.3320) / (0.1858 - decodeCie(y))
+	return uint(math.Round(449*n*n*n + 3525*n*n + 6823.3*n + 5520.33))
+}
+
+func srgbToLinear(c float64) float64 {
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+func linearToSrgb(c float64) float64 {
+	if c <= 0.0031308 {
+		return 12.92 * c
+	}
+	return 1.055*math.Pow(c, 1/2.4) - 0.055
+}
+
+// RgbToXyl converts the 8 bit sRGB color to the 16 bit xyL lightness, x and y,
+// the lightness is the one of the brightest channel
+func RgbToXyl(r, g, b uint) (uint, uint, uint) {
+	rf, gf, bf := float64(r&0xFF)/0xFF, float64(g&0xFF)/0xFF, float64(b&0xFF)/0xFF
+	lightness := uint(math.Round(math.Max(rf, math.Max(gf, bf)) * 0xFFFF))
+	rl, gl, bl := srgbToLinear(rf), srgbToLinear(gf), srgbToLinear(bf)
+	X := 0.4124*rl + 0.3576*gl + 0.1805*bl
+	Y := 0.2126*rl + 0.7152*gl + 0.0722*bl
+	Z := 0.0193*rl + 0.1192*gl + 0.9505*bl
+	if sum := X + Y + Z; sum > 0 {
+		return lightness, encodeCie(X / sum), encodeCie(Y / sum)
+	}
+	// black has no chromaticity, take the white point
+	return lightness, encodeCie(d65X), encodeCie(d65Y)
+}
+
+// XylToRgb converts the 16 bit xyL lightness, x and y to the 8 bit sRGB color,
+// the colors out of the sRGB gamut are clipped
+func XylToRgb(lightness, x, y uint) (uint, uint, uint) {
+	xf, yf := decodeCie(x), decodeCie(y)
+	if yf == 0 {
+		return 0, 0, 0
+	}
+	X, Z := xf/yf, (1-xf-yf)/yf
+	rgb := []float64{
+		3.2406*X - 1.5372 - 0.4986*Z,
+		-0.9689*X + 1.8758 + 0.0415*Z,
+		0.0557*X - 0.2040 + 1.0570*Z,
+	}
+	max := 0.0
+	for i := range rgb {
+		rgb[i] = math.Max(0, rgb[i])
+		max = math.Max(max, rgb[i])
+	}
+	if max == 0 {
+		return 0, 0, 0
+	}
+	out := make([]uint, 3)
+	for i := range rgb {
+		out[i] = uint(math.Round(linearToSrgb(rgb[i]/max) * float64(lightness) / 0xFFFF * 0xFF))
+	}
+	return out[0], out[1], out[2]
+}
+
+// ColorToXyl converts the "2700K" color temperature or the "#ffaa00" sRGB color
+// to the 16 bit xyL lightness, x and y, the color temperatures are at full lightness
+func ColorToXyl(color string) (uint, uint, uint, error) {
+	if c := strings.ToUpper(color); strings.HasSuffix(c, "K") {
+		temperature, err := strconv.ParseUint(strings.TrimSuffix(c, "K"), 10, 16)
+		if err != nil {
+			return 0, 0, 0, errors.InvalidColor.New().AddContextF("color: %s", color)
+		}
+		x, y, err := CctToXy(uint(temperature))
+		return 0xFFFF, x, y, err
+	}
+	r, g, b, err := parseHexColor(color)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	l, x, y := RgbToXyl(r, g, b)
+	return l, x, y, nil
+}
+
+func (m *Model) lightXylState() LightXylState {
+	state, _ := m.State.(LightXylState)
+	return state
+}
+
+func (m *Model) handleLightXylResponse(msg *AccessMessage) error {
+	state := m.lightXylState()
+	d := msg.payload
+	l, x, y := uint(binary.LittleEndian.Uint16(d)), uint(binary.LittleEndian.Uint16(d[2:])), uint(binary.LittleEndian.Uint16(d[4:]))
+	remaining := uint(0)
+	if len(d) == 7 {
+		remaining = uint(d[6])
+	}
+	if msg.opcode == opLightxyLTargetStatus {
+		state.TargetLightness, state.TargetX, state.TargetY = l, x, y
+	} else {
+		state.Lightness, state.X, state.Y = l, x, y
+		loggerLightCli.Debugf("present lightness: %d%%, x: %.4f, y: %.4f", calcLightnessPrc(l), decodeCie(x), decodeCie(y))
+	}
+	state.RemainingTime = remaining
+	m.State = state
+	return nil
+}
+
+func LightXylGet(dst uint) error {
+	m, err := findModelDirectly(dst, LightxyLServer)
+	if err != nil {
+		return err
+	}
+	return modelSendTmpl(false, dst, opLightxyLGet, nil, m.handleLightXylResponse)
+}
+
+func lightXylSet(ack bool, dst uint, lightness, x, y, transition uint) error {
+	params := transitionParams(uint16Params(lightness, x, y), transition)
+	if !ack {
+		return modelSendTmpl(false, dst, opLightxyLSetUnacknowledged, params, nil)
+	}
+	m, err := findModelDirectly(dst, LightxyLServer)
+	if err != nil {
+		return err
+	}
+	return modelSendTmpl(false, dst, opLightxyLSet, params, m.handleLightXylResponse)
+}
+
+// LightXylSet sets the xyL lightness, x and y with the transition in milliseconds
+func LightXylSet(dst uint, lightness, x, y, transition uint) error {
+	return lightXylSet(true, dst, lightness, x, y, transition)
+}
+
+func LightXylSetUnacknowledged(dst uint, lightness, x, y, transition uint) error {
+	return lightXylSet(false, dst, lightness, x, y, transition)
+}
+
+// LightXylSetColor sets the "2700K" or "#ffaa00" color, unacknowledged if the destination is a group
+func LightXylSetColor(dst uint, color string, transition uint) error {
+	l, x, y, err := ColorToXyl(color)
+	if err != nil {
+		return err
+	}
+	return lightXylSet(!isGroupAddr(dst), dst, l, x, y, transition)
+}
+
+func LightXylTargetGet(dst uint) error {
+	m, err := findModelDirectly(dst, LightxyLServer)
+	if err != nil {
+		return err
+	}
+	return modelSendTmpl(false, dst, opLightxyLTargetGet, nil, m.handleLightXylResponse)
+}
+
+func (m *Model) handleLightXylDefaultResponse(n *Node, d interface{}) error {
+	resp := d.(LightXyLDefaultStatusMessageParameters)
+	state := m.lightXylState()
+	state.LightnessDefault = resp.Lightness
+	state.XDefault = resp.XyLX
+	state.YDefault = resp.XyLY
+	m.State = state
+	return nil
+}
+
+func LightXylDefaultGet(dst uint) error {
+	m, err := findModelDirectly(dst, LightxyLServer)
+	if err != nil {
+		return err
+	}
+	return modelSendTmplParsed(false, dst, opLightxyLDefaultGet, nil, m.handleLightXylDefaultResponse)
+}
+
+func LightXylDefaultSet(dst uint, lightness, x, y uint) error {
+	m, err := findModelDirectly(dst, LightxyLServer)
+	if err != nil {
+		return err
+	}
+	req := &LightXyLDefaultSetMessageParameters{
+		Lightness: lightness,
+		XyLX:      x,
+		XyLY:      y,
+	}
+	return modelSendTmplParsed(false, dst, opLightxyLDefaultSet, req, m.handleLightXylDefaultResponse)
+}
+
+func (m *Model) handleLightXylRangeResponse(n *Node, d interface{}) error {
+	resp := d.(LightXyLRangeStatusMessageParameters)
+	if resp.StatusCode == 0 {
+		state := m.lightXylState()
+		state.XRangeMin = resp.XyLXRangeMin
+		state.XRangeMax = resp.XyLXRangeMax
+		state.YRangeMin = resp.XyLYRangeMin
+		state.YRangeMax = resp.XyLYRangeMax
+		m.State = state
+		loggerLightCli.Debugf("x range min: %x, max: %x, y range min: %x, max: %x",
+			resp.XyLXRangeMin, resp.XyLXRangeMax, resp.XyLYRangeMin, resp.XyLYRangeMax)
+	} else if resp.StatusCode == 1 {
+		return errors.CannotSetRangeMin.New()
+	} else if resp.StatusCode == 2 {
+		return errors.CannotSetRangeMax.New()
+	}
+	return nil
+}
+
+func LightXylRangeGet(dst uint) error {
+	m, err := findModelDirectly(dst, LightxyLServer)
+	if err != nil {
+		return err
+	}
+	return modelSendTmplParsed(false, dst, opLightxyLRangeGet, nil, m.handleLightXylRangeResponse)
+}
+
+func LightXylRangeSet(dst uint, xMin, xMax, yMin, yMax uint) error {
+	m, err := findModelDirectly(dst, LightxyLServer)
+	if err != nil {
+		return err
+	}
+	req := &LightXyLRangeSetMessageParameters{
+		XyLXRangeMin: xMin,
+		XyLXRangeMax: xMax,
+		XyLYRangeMin: yMin,
+		XyLYRangeMax: yMax,
+	}
+	return modelSendTmplParsed(false, dst, opLightxyLRangeSet, req, m.handleLightXylRangeResponse)
+}
@@ -0,0 +1,51 @@
+package mesh
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CctToXy(t *testing.T) {
+	x, y, err := CctToXy(2700)
+	assert.Nil(t, err)
+	assert.InDelta(t, 0.4599, decodeCie(x), 0.001)
+	assert.InDelta(t, 0.4106, decodeCie(y), 0.001)
+	assert.InDelta(t, 2700, float64(XyToCct(x, y)), 10)
+
+	x, y, _ = CctToXy(6500)
+	assert.InDelta(t, 6500, float64(XyToCct(x, y)), 20)
+
+	_, _, err = CctToXy(1000)
+	assert.NotNil(t, err)
+}
+
+func Test_ColorToXyl(t *testing.T) {
+	// the white of sRGB is D65
+	l, x, y, err := ColorToXyl("#ffffff")
+	assert.Nil(t, err)
+	assert.Equal(t, uint(0xFFFF), l)
+	assert.InDelta(t, d65X, decodeCie(x), 0.0005)
+	assert.InDelta(t, d65Y, decodeCie(y), 0.0005)
+
+	l, x, y, err = ColorToXyl("#ffaa00")
+	assert.Nil(t, err)
+	r, g, b := XylToRgb(l, x, y)
+	assert.InDelta(t, 0xff, float64(r), 1)
+	assert.InDelta(t, 0xaa, float64(g), 1)
+	assert.InDelta(t, 0x00, float64(b), 1)
+
+	l, x, _, err = ColorToXyl("2700k")
+	assert.Nil(t, err)
+	assert.Equal(t, uint(0xFFFF), l)
+	assert.InDelta(t, 0.4599, decodeCie(x), 0.001)
+
+	_, _, _, err = ColorToXyl("warmK")
+	assert.NotNil(t, err)
+}
+
+func Test_handleLightXylResponse(t *testing.T) {
+	m := &Model{ModelID: LightxyLServer}
+	m.handleLightXylResponse(&AccessMessage{opcode: opLightxyLStatus, payload: []byte{0xff, 0xff, 0x0c, 0x50, 0x39, 0x54, 0x0a}})
+	assert.Equal(t, LightXylState{Lightness: 0xffff, X: 0x500c, Y: 0x5439, RemainingTime: 0x0a}, m.State)
+}
@@ -0,0 +1,390 @@
+package mesh
+
+import (
+	"ble-mesh/mesh/db"
+	"ble-mesh/utils"
+	"ble-mesh/utils/errors"
+	"encoding/binary"
+	"sort"
+	"sync"
+)
+
+type (
+	// SceneState is the state of the scene server model
+	SceneState struct {
+		StatusCode    uint   `json:"statusCode"`
+		CurrentScene  uint   `json:"currentScene"`
+		TargetScene   uint   `json:"targetScene"`
+		RemainingTime uint   `json:"remainingTime"`
+		Scenes        []uint `json:"scenes"`
+	}
+
+	sceneRecallParameters struct {
+		SceneNumber    uint `bits:"16"`
+		TID            uint `bits:"8"`
+		TransitionTime uint `bits:"8"`
+		Delay          uint `bits:"8"`
+	}
+)
+
+const (
+	sceneStatusSuccess      = 0x00
+	sceneStatusRegisterFull = 0x01
+	sceneStatusNotFound     = 0x02
+)
+
+var (
+	loggerSceneCli = utils.CreateLogger("SceneClient")
+	sceneListener  = modelMsglistener(sceneStatusReceive)
+	sceneLock      sync.Mutex
+)
+
+func init() {
+	registerModelMessageRxListener(&sceneListener)
+}
+
+func (m *Model) sceneState() SceneState {
+	state, _ := m.State.(SceneState)
+	if state.Scenes == nil {
+		state.Scenes = []uint{}
+	}
+	return state
+}
+
+func sceneStatusError(statusCode, sceneNumber uint) error {
+	switch statusCode {
+	case sceneStatusSuccess:
+		return nil
+	case sceneStatusRegisterFull:
+		return errors.SceneRegisterFull.New().AddContextF("scene:%4x", sceneNumber)
+	case sceneStatusNotFound:
+		return errors.SceneNotFound.New().AddContextF("scene:%4x", sceneNumber)
+	}
+	return errors.InvalidResponse.New().AddContextF("scene:%4x, status code:%d", sceneNumber, statusCode)
+}
+
+// parseSceneStatus parses the scene status, the target scene and the remaining time are optional
+func parseSceneStatus(data []byte, state *SceneState) {
+	state.StatusCode = uint(data[0])
+	state.CurrentScene = uint(binary.LittleEndian.Uint16(data[1:]))
+	state.TargetScene, state.RemainingTime = 0, 0
+	if len(data) == 6 {
+		state.TargetScene = uint(binary.LittleEndian.Uint16(data[3:]))
+		state.RemainingTime = uint(data[5])
+	}
+}
+
+// parseSceneRegisterStatus parses the scene register status followed by the stored scene numbers
+func parseSceneRegisterStatus(data []byte, state *SceneState) {
+	state.StatusCode = uint(data[0])
+	state.CurrentScene = uint(binary.LittleEndian.Uint16(data[1:]))
+	state.Scenes = []uint{}
+	for i := 3; i+1 < len(data); i += 2 {
+		state.Scenes = append(state.Scenes, uint(binary.LittleEndian.Uint16(data[i:])))
+	}
+}
+
+func sceneNumberParam(sceneNumber uint) []byte {
+	param := make([]byte, 2)
+	binary.LittleEndian.PutUint16(param, uint16(sceneNumber))
+	return param
+}
+
+func (m *Model) updateSceneState(msg *AccessMessage) {
+	state := m.sceneState()
+	switch msg.opcode {
+	case opSceneStatus:
+		parseSceneStatus(msg.payload, &state)
+	case opSceneRegisterStatus:
+		parseSceneRegisterStatus(msg.payload, &state)
+		sceneRegistryUpdate(msg.src, state.Scenes)
+	}
+	m.State = state
+}
+
+func (m *Model) handleSceneResponse(sceneNumber uint) onResponseReceived {
+	return func(msg *AccessMessage) error {
+		m.updateSceneState(msg)
+		return sceneStatusError(uint(msg.payload[0]), sceneNumber)
+	}
+}
+
+// sceneStatusReceive records the scene statuses published by the scene servers
+func sceneStatusReceive(msg *AccessMessage) {
+	if msg.opcode != opSceneStatus && msg.opcode != opSceneRegisterStatus {
+		return
+	}
+	if txMsg, ok := txAccessMsgs[msg.src]; ok && txMsg.expectedRespOpcode == msg.opcode {
+		// the response is handled by the request
+		return
+	}
+	if validator := expectedRespLen[msg.opcode]; !validator(msg.payload) {
+		loggerSceneCli.Warnf("invalid scene status from %04x: % 2x", msg.src, msg.payload)
+		return
+	}
+	m, err := findModelDirectly(msg.src, SceneServer)
+	if err != nil {
+		loggerSceneCli.Warn(err)
+		return
+	}
+	m.updateSceneState(msg)
+}
+
+// sceneElements returns the elements addressed by the scene message, the
+// elements of a group are the ones whose scene setup server subscribes to it
+func sceneElements(dst uint) []uint {
+	if !isGroupAddr(dst) {
+		return []uint{dst}
+	}
+	addrs := []uint{}
+	for _, n := range meshDb.Nodes {
+		for _, e := range n.Elements {
+			m, err := e.findModel(SceneSetupServer)
+			if err != nil {
+				continue
+			}
+			for _, addr := range m.SubAddresses {
+				if addr == dst {
+					addrs = append(addrs, e.UnicastAddress)
+					break
+				}
+			}
+		}
+	}
+	return addrs
+}
+
+func (s *Scene) hasAddress(addr uint) bool {
+	for _, a := range s.Addresses {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Scene) removeAddress(addr uint) {
+	addrs := []uint{}
+	for _, a := range s.Addresses {
+		if a != addr {
+			addrs = append(addrs, a)
+		}
+	}
+	s.Addresses = addrs
+}
+
+// sceneRegistryLocked returns the scene of the registry, it is added if not known yet
+func sceneRegistryLocked(sceneNumber uint) *Scene {
+	scene, ok := meshDb.Scenes[sceneNumber]
+	if !ok {
+		scene = &Scene{Number: sceneNumber, Addresses: []uint{}}
+		meshDb.Scenes[sceneNumber] = scene
+	}
+	return scene
+}
+
+// sceneRegistryStore records the scene stored on the elements
+func sceneRegistryStore(sceneNumber uint, addrs []uint) {
+	sceneLock.Lock()
+	scene := sceneRegistryLocked(sceneNumber)
+	for _, addr := range addrs {
+		if !scene.hasAddress(addr) {
+			scene.Addresses = append(scene.Addresses, addr)
+		}
+	}
+	sceneLock.Unlock()
+	writeMeshToDb()
+}
+
+// sceneRegistryDelete records the scene deleted from the elements, the name of the scene is kept
+func sceneRegistryDelete(sceneNumber uint, addrs []uint) {
+	sceneLock.Lock()
+	if scene, ok := meshDb.Scenes[sceneNumber]; ok {
+		for _, addr := range addrs {
+			scene.removeAddress(addr)
+		}
+	}
+	sceneLock.Unlock()
+	writeMeshToDb()
+}
+
+// sceneRegistryUpdate replaces the scenes stored on the element by the ones of its scene register
+func sceneRegistryUpdate(addr uint, scenes []uint) {
+	sceneLock.Lock()
+	for _, scene := range meshDb.Scenes {
+		scene.removeAddress(addr)
+	}
+	for _, sceneNumber := range scenes {
+		scene := sceneRegistryLocked(sceneNumber)
+		scene.Addresses = append(scene.Addresses, addr)
+	}
+	sceneLock.Unlock()
+	writeMeshToDb()
+}
+
+func scenesToDb() []db.Scene {
+	sceneLock.Lock()
+	defer sceneLock.Unlock()
+	scenes := []db.Scene{}
+	for _, scene := range meshDb.Scenes {
+		sceneRaw := db.Scene{Number: scene.Number, Name: scene.Name, Addresses: []string{}}
+		for _, addr := range scene.Addresses {
+			sceneRaw.Addresses = append(sceneRaw.Addresses, utils.UintToHexString(addr))
+		}
+		scenes = append(scenes, sceneRaw)
+	}
+	sort.Slice(scenes, func(i, j int) bool { return scenes[i].Number < scenes[j].Number })
+	return scenes
+}
+
+// GetScenes returns the scene registry sorted by scene number
+func GetScenes() []Scene {
+	sceneLock.Lock()
+	defer sceneLock.Unlock()
+	scenes := []Scene{}
+	for _, scene := range meshDb.Scenes {
+		s := *scene
+		s.Addresses = append([]uint{}, scene.Addresses...)
+		sort.Slice(s.Addresses, func(i, j int) bool { return s.Addresses[i] < s.Addresses[j] })
+		scenes = append(scenes, s)
+	}
+	sort.Slice(scenes, func(i, j int) bool { return scenes[i].Number < scenes[j].Number })
+	return scenes
+}
+
+// SetSceneName names the scene of the registry, e.g. "Presentation mode"
+func SetSceneName(sceneNumber uint, name string) error {
+	if sceneNumber == 0 {
+		return errors.SceneNotFound.New().AddContext("scene number 0 is prohibited")
+	}
+	sceneLock.Lock()
+	sceneRegistryLocked(sceneNumber).Name = name
+	sceneLock.Unlock()
+	writeMeshToDb()
+	return nil
+}
+
+// SceneRemove removes the scene from the registry, it is not deleted from the elements
+func SceneRemove(sceneNumber uint) error {
+	sceneLock.Lock()
+	_, ok := meshDb.Scenes[sceneNumber]
+	delete(meshDb.Scenes, sceneNumber)
+	sceneLock.Unlock()
+	if !ok {
+		return errors.SceneNotFound.New().AddContextF("scene:%4x", sceneNumber)
+	}
+	writeMeshToDb()
+	return nil
+}
+
+func SceneGet(dst uint) error {
+	m, err := findModelDirectly(dst, SceneServer)
+	if err != nil {
+		return err
+	}
+	return modelSendTmpl(false, dst, opSceneGet, nil, m.handleSceneResponse(0))
+}
+
+func SceneRegisterGet(dst uint) error {
+	m, err := findModelDirectly(dst, SceneServer)
+	if err != nil {
+		return err
+	}
+	return modelSendTmpl(false, dst, opSceneRegisterGet, nil, m.handleSceneResponse(0))
+}
+
+func sceneStore(ack bool, dst, sceneNumber uint) error {
+	if sceneNumber == 0 {
+		return errors.SceneNotFound.New().AddContext("scene number 0 is prohibited")
+	}
+	if !ack {
+		if err := modelSendTmpl(false, dst, opSceneStoreUnacknowledged, sceneNumberParam(sceneNumber), nil); err != nil {
+			return err
+		}
+		sceneRegistryStore(sceneNumber, sceneElements(dst))
+		return nil
+	}
+	m, err := findModelDirectly(dst, SceneServer)
+	if err != nil {
+		return err
+	}
+	return modelSendTmpl(false, dst, opSceneStore, sceneNumberParam(sceneNumber), m.handleSceneResponse(sceneNumber))
+}
+
+// SceneStore stores the current state of the element as the scene
+func SceneStore(dst, sceneNumber uint) error {
+	return sceneStore(true, dst, sceneNumber)
+}
+
+// SceneStoreUnacknowledged stores the scene on the element or on the elements of the group
+func SceneStoreUnacknowledged(dst, sceneNumber uint) error {
+	return sceneStore(false, dst, sceneNumber)
+}
+
+func sceneRecall(ack bool, dst, sceneNumber, transition, delay uint) error {
+	if sceneNumber == 0 {
+		return errors.SceneNotFound.New().AddContext("scene number 0 is prohibited")
+	}
+	transactionIdentifier++
+	params := &sceneRecallParameters{
+		SceneNumber:    sceneNumber,
+		TID:            transactionIdentifier,
+		TransitionTime: transitionTime(transition),
+		Delay:          delay / 5,
+	}
+	payload, err := utils.PackStructLE(params)
+	if err != nil {
+		return err
+	}
+	if !ack {
+		return modelSendTmpl(false, dst, opSceneRecallUnacknowledged, payload, nil)
+	}
+	m, err := findModelDirectly(dst, SceneServer)
+	if err != nil {
+		return err
+	}
+	return modelSendTmpl(false, dst, opSceneRecall, payload, m.handleSceneResponse(sceneNumber))
+}
+
+// SceneRecall recalls the scene with the transition and the delay in milliseconds
+func SceneRecall(dst, sceneNumber, transition, delay uint) error {
+	return sceneRecall(true, dst, sceneNumber, transition, delay)
+}
+
+// SceneRecallUnacknowledged recalls the scene on the element or on the elements of the group
+func SceneRecallUnacknowledged(dst, sceneNumber, transition, delay uint) error {
+	return sceneRecall(false, dst, sceneNumber, transition, delay)
+}
+
+// SceneRecallByName recalls the named scene of the registry on the group, e.g. all the lights of a room
+func SceneRecallByName(dst uint, name string, transition, delay uint) error {
+	for _, scene := range GetScenes() {
+		if scene.Name == name {
+			return sceneRecall(false, dst, scene.Number, transition, delay)
+		}
+	}
+	return errors.SceneNotFound.New().AddContextF("scene: %s", name)
+}
+
+func sceneDelete(ack bool, dst, sceneNumber uint) error {
+	if !ack {
+		if err := modelSendTmpl(false, dst, opSceneDeleteUnacknowledged, sceneNumberParam(sceneNumber), nil); err != nil {
+			return err
+		}
+		sceneRegistryDelete(sceneNumber, sceneElements(dst))
+		return nil
+	}
+	m, err := findModelDirectly(dst, SceneServer)
+	if err != nil {
+		return err
+	}
+	return modelSendTmpl(false, dst, opSceneDelete, sceneNumberParam(sceneNumber), m.handleSceneResponse(sceneNumber))
+}
+
+func SceneDelete(dst, sceneNumber uint) error {
+	return sceneDelete(true, dst, sceneNumber)
+}
+
+func SceneDeleteUnacknowledged(dst, sceneNumber uint) error {
+	return sceneDelete(false, dst, sceneNumber)
+}
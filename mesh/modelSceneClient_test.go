@@ -0,0 +1,48 @@
+package mesh
+
+import (
+	"ble-mesh/mesh/db"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseSceneStatus(t *testing.T) {
+	state := SceneState{}
+	parseSceneStatus([]byte{0x00, 0x01, 0x00, 0x02, 0x00, 0x45}, &state)
+	assert.Equal(t, SceneState{CurrentScene: 1, TargetScene: 2, RemainingTime: 0x45}, state)
+
+	parseSceneRegisterStatus([]byte{0x01, 0x01, 0x00, 0x01, 0x00, 0x34, 0x12}, &state)
+	assert.Equal(t, uint(sceneStatusRegisterFull), state.StatusCode)
+	assert.Equal(t, []uint{0x0001, 0x1234}, state.Scenes)
+}
+
+func Test_transitionTime(t *testing.T) {
+	assert.Equal(t, uint(0x00), transitionTime(0))
+	assert.Equal(t, uint(0x05), transitionTime(500))
+	assert.Equal(t, uint(0x40|0x0A), transitionTime(10000))
+	assert.Equal(t, uint(0xC0|0x3E), transitionTime(24*3600*1000))
+}
+
+func Test_sceneRegistry(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "mesh")
+	defer os.RemoveAll(dir)
+	confDir = dir
+	meshDbRaw = &db.Mesh{}
+	meshDb = &Mesh{Scenes: map[uint]*Scene{}}
+
+	assert.Nil(t, SetSceneName(3, "Presentation mode"))
+	sceneRegistryStore(3, []uint{0x0102, 0x0103})
+	sceneRegistryUpdate(0x0103, []uint{4})
+	scenes := GetScenes()
+	assert.Len(t, scenes, 2)
+	assert.Equal(t, Scene{Number: 3, Name: "Presentation mode", Addresses: []uint{0x0102}}, scenes[0])
+	assert.Equal(t, []uint{0x0103}, scenes[1].Addresses)
+	assert.Equal(t, "Presentation mode", meshDbRaw.Scenes[0].Name)
+
+	sceneRegistryDelete(3, []uint{0x0102})
+	assert.Empty(t, GetScenes()[0].Addresses)
+	assert.NotNil(t, SceneRemove(5))
+}
@@ -0,0 +1,230 @@
+package mesh
+
+import (
+	"ble-mesh/utils/errors"
+	"encoding/binary"
+)
+
+type (
+	// SchedulerEntry is an entry of the scheduler register, the fields take the
+	// SCHEDULER_* values for any, random or repeated times
+	SchedulerEntry struct {
+		Index uint `json:"index"`
+		// last two digits of the year
+		Year uint `json:"year"`
+		// bitmask of the months, bit 0 is January
+		Month  uint `json:"month"`
+		Day    uint `json:"day"`
+		Hour   uint `json:"hour"`
+		Minute uint `json:"minute"`
+		Second uint `json:"second"`
+		// bitmask of the days of the week, bit 0 is Monday
+		DayOfWeek      uint `json:"dayOfWeek"`
+		Action         uint `json:"action"`
+		TransitionTime uint `json:"transitionTime"`
+		SceneNumber    uint `json:"sceneNumber"`
+	}
+
+	// SchedulerState is the state of the scheduler server model, keyed by the index of the entries
+	SchedulerState struct {
+		// bitmask of the defined entries
+		Schedules uint                    `json:"schedules"`
+		Entries   map[uint]SchedulerEntry `json:"entries"`
+	}
+)
+
+const (
+	SCHEDULER_ANY_YEAR         = 0x64
+	SCHEDULER_ALL_MONTHS       = 0xFFF
+	SCHEDULER_ANY_DAY          = 0x00
+	SCHEDULER_ANY_HOUR         = 0x18
+	SCHEDULER_RANDOM_HOUR      = 0x19
+	SCHEDULER_ANY_MINUTE       = 0x3C
+	SCHEDULER_EVERY_15_MINUTES = 0x3D
+	SCHEDULER_EVERY_20_MINUTES = 0x3E
+	SCHEDULER_RANDOM_MINUTE    = 0x3F
+	SCHEDULER_ANY_SECOND       = 0x3C
+	SCHEDULER_EVERY_15_SECONDS = 0x3D
+	SCHEDULER_EVERY_20_SECONDS = 0x3E
+	SCHEDULER_RANDOM_SECOND    = 0x3F
+	SCHEDULER_WEEKDAYS         = 0x1F
+	SCHEDULER_WEEKEND          = 0x60
+	SCHEDULER_EVERY_DAY        = 0x7F
+
+	SCHEDULER_ACTION_TURN_OFF     = 0x0
+	SCHEDULER_ACTION_TURN_ON      = 0x1
+	SCHEDULER_ACTION_SCENE_RECALL = 0x2
+	SCHEDULER_ACTION_NO_ACTION    = 0xF
+
+	SCHEDULER_ENTRIES = 16
+
+	// the 4 bits index and the 76 bits of the register entry
+	schedulerEntrySize   = 10
+	schedulerEntryFields = 11
+	schedulerMaxDay      = 31
+)
+
+// sizes in bits of the fields of the scheduler register entry, in the order of fields()
+var schedulerFieldSizes = [schedulerEntryFields]uint{4, 7, 12, 5, 5, 6, 6, 7, 4, 8, 16}
+
+func (e *SchedulerEntry) fields() [schedulerEntryFields]*uint {
+	return [...]*uint{&e.Index, &e.Year, &e.Month, &e.Day, &e.Hour, &e.Minute, &e.Second, &e.DayOfWeek, &e.Action, &e.TransitionTime, &e.SceneNumber}
+}
+
+func (e *SchedulerEntry) validate() error {
+	for i, f := range e.fields() {
+		if *f >= 1<<schedulerFieldSizes[i] {
+			return errors.InvalidSchedulerEntry.New().AddContextF("entry: %+v", *e)
+		}
+	}
+	if e.Year > SCHEDULER_ANY_YEAR || e.Day > schedulerMaxDay || e.Hour > SCHEDULER_RANDOM_HOUR {
+		return errors.InvalidSchedulerEntry.New().AddContextF("entry: %+v", *e)
+	}
+	switch e.Action {
+	case SCHEDULER_ACTION_TURN_OFF, SCHEDULER_ACTION_TURN_ON, SCHEDULER_ACTION_NO_ACTION:
+	case SCHEDULER_ACTION_SCENE_RECALL:
+		if e.SceneNumber == 0 {
+			return errors.InvalidSchedulerEntry.New().AddContext("scene number 0 is prohibited")
+		}
+	default:
+		return errors.InvalidSchedulerEntry.New().AddContextF("action: %x", e.Action)
+	}
+	return nil
+}
+
+// encodeSchedulerEntry packs the fields of the entry from the least significant bit
+func encodeSchedulerEntry(e SchedulerEntry) []byte {
+	data := make([]byte, schedulerEntrySize)
+	offset := uint(0)
+	for i, f := range e.fields() {
+		for b := uint(0); b < schedulerFieldSizes[i]; b++ {
+			if *f>>b&1 == 1 {
+				data[(offset+b)/8] |= 1 << ((offset + b) % 8)
+			}
+		}
+		offset += schedulerFieldSizes[i]
+	}
+	return data
+}
+
+func decodeSchedulerEntry(data []byte) SchedulerEntry {
+	e := SchedulerEntry{}
+	offset := uint(0)
+	for i, f := range e.fields() {
+		for b := uint(0); b < schedulerFieldSizes[i]; b++ {
+			*f |= uint(data[(offset+b)/8]>>((offset+b)%8)&1) << b
+		}
+		offset += schedulerFieldSizes[i]
+	}
+	return e
+}
+
+func (m *Model) schedulerState() SchedulerState {
+	state, _ := m.State.(SchedulerState)
+	if state.Entries == nil {
+		state.Entries = map[uint]SchedulerEntry{}
+	}
+	return state
+}
+
+func (m *Model) handleSchedulerResponse(msg *AccessMessage) error {
+	state := m.schedulerState()
+	state.Schedules = uint(binary.LittleEndian.Uint16(msg.payload))
+	m.State = state
+	return nil
+}
+
+func (m *Model) handleSchedulerActionResponse(msg *AccessMessage) error {
+	state := m.schedulerState()
+	e := decodeSchedulerEntry(msg.payload)
+	state.Entries[e.Index] = e
+	m.State = state
+	return nil
+}
+
+// SchedulerGet reads the bitmask of the defined entries
+func SchedulerGet(dst uint) error {
+	m, err := findModelDirectly(dst, SchedulerServer)
+	if err != nil {
+		return err
+	}
+	return modelSendTmpl(false, dst, opSchedulerGet, nil, m.handleSchedulerResponse)
+}
+
+func SchedulerActionGet(dst, index uint) error {
+	if index >= SCHEDULER_ENTRIES {
+		return errors.InvalidSchedulerEntry.New().AddContextF("index: %d", index)
+	}
+	m, err := findModelDirectly(dst, SchedulerServer)
+	if err != nil {
+		return err
+	}
+	return modelSendTmpl(false, dst, opSchedulerActionGet, []byte{byte(index)}, m.handleSchedulerActionResponse)
+}
+
+func schedulerActionSet(ack bool, dst uint, entry SchedulerEntry) error {
+	if err := entry.validate(); err != nil {
+		return err
+	}
+	m, err := findModelDirectly(dst, SchedulerServer)
+	if err != nil {
+		return err
+	}
+	if !ack {
+		return modelSendTmpl(false, dst, opSchedulerActionSetUnacknowledged, encodeSchedulerEntry(entry), nil)
+	}
+	return modelSendTmpl(false, dst, opSchedulerActionSet, encodeSchedulerEntry(entry), m.handleSchedulerActionResponse)
+}
+
+// SchedulerActionSet sets the entry of the scheduler register at the index of the entry
+func SchedulerActionSet(dst uint, entry SchedulerEntry) error {
+	return schedulerActionSet(true, dst, entry)
+}
+
+func SchedulerActionSetUnacknowledged(dst uint, entry SchedulerEntry) error {
+	return schedulerActionSet(false, dst, entry)
+}
+
+// SchedulerActionsGet reads the 16 entries of the scheduler register
+func SchedulerActionsGet(dst uint) ([]SchedulerEntry, error) {
+	m, err := findModelDirectly(dst, SchedulerServer)
+	if err != nil {
+		return nil, err
+	}
+	if err := SchedulerGet(dst); err != nil {
+		return nil, err
+	}
+	entries := []SchedulerEntry{}
+	for i := uint(0); i < SCHEDULER_ENTRIES; i++ {
+		if err := SchedulerActionGet(dst, i); err != nil {
+			return nil, err
+		}
+		entries = append(entries, m.schedulerState().Entries[i])
+	}
+	return entries, nil
+}
+
+// SchedulerActionsSet replaces the scheduler register, the entries take the index
+// of their position and the entries after them are set to no action
+func SchedulerActionsSet(dst uint, entries []SchedulerEntry) error {
+	if len(entries) > SCHEDULER_ENTRIES {
+		return errors.InvalidSchedulerEntry.New().AddContextF("%d entries", len(entries))
+	}
+	register := make([]SchedulerEntry, SCHEDULER_ENTRIES)
+	for i := range register {
+		register[i] = SchedulerEntry{Year: SCHEDULER_ANY_YEAR, Action: SCHEDULER_ACTION_NO_ACTION}
+		if i < len(entries) {
+			register[i] = entries[i]
+		}
+		register[i].Index = uint(i)
+		if err := register[i].validate(); err != nil {
+			return err
+		}
+	}
+	for _, entry := range register {
+		if err := SchedulerActionSet(dst, entry); err != nil {
+			return err
+		}
+	}
+	return SchedulerGet(dst)
+}
@@ -0,0 +1,34 @@
+package mesh
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_encodeSchedulerEntry(t *testing.T) {
+	// lights off at 22:00 on weekdays
+	entry := SchedulerEntry{
+		Index:     1,
+		Year:      SCHEDULER_ANY_YEAR,
+		Month:     SCHEDULER_ALL_MONTHS,
+		Day:       SCHEDULER_ANY_DAY,
+		Hour:      22,
+		DayOfWeek: SCHEDULER_WEEKDAYS,
+		Action:    SCHEDULER_ACTION_TURN_OFF,
+	}
+	assert.Nil(t, entry.validate())
+	data := encodeSchedulerEntry(entry)
+	assert.Equal(t, []byte{0x41, 0xfe, 0x7f, 0x60, 0x01, 0xe0, 0x03, 0x00, 0x00, 0x00}, data)
+	assert.Equal(t, entry, decodeSchedulerEntry(data))
+
+	entry = SchedulerEntry{Index: 15, Year: 26, Month: 0x800, Day: 31, Hour: SCHEDULER_RANDOM_HOUR, Minute: SCHEDULER_EVERY_15_MINUTES,
+		Second: 59, DayOfWeek: SCHEDULER_WEEKEND, Action: SCHEDULER_ACTION_SCENE_RECALL, TransitionTime: 0x45, SceneNumber: 0x1234}
+	assert.Equal(t, entry, decodeSchedulerEntry(encodeSchedulerEntry(entry)))
+
+	entry.SceneNumber = 0
+	assert.NotNil(t, entry.validate())
+	assert.Nil(t, (&SchedulerEntry{Hour: SCHEDULER_ANY_HOUR, Action: SCHEDULER_ACTION_TURN_ON}).validate())
+	assert.NotNil(t, (&SchedulerEntry{Day: 32, Action: SCHEDULER_ACTION_TURN_ON}).validate())
+	assert.NotNil(t, (&SchedulerEntry{Hour: 0x1A, Action: SCHEDULER_ACTION_TURN_ON}).validate())
+}
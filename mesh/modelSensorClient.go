@@ -1,22 +1,463 @@
 package mesh
 
+import (
+	"ble-mesh/utils"
+	"ble-mesh/utils/errors"
+	"encoding/binary"
+)
+
 const (
-	opSensorDescriptorGet=0x8230
-	opSensorDescriptorStatus=0x51
-	opSensorGet=0x8231
-	opSensorStatus=0x52
-	opSensorColumnGet=0x8232
-	opSensorColumnStatus=0x53
-	opSensorSeriesGet=0x8233
-	opSensorSeriesStatus=0x54
-	opSensorCadenceGet=0x8234
-	opSensorCadenceSet=0x55
-	opSensorCadenceSetUnacknowledged=0x56
-	opSensorCadenceStatus=0x57
-	opSensorSettingsGet=0x8235
-	opSensorSettingsStatus=0x58
-	opSensorSettingGet=0x8236
-	opSensorSettingSet=0x59
-	opSensorSettingSetUnacknowledged=0x5A
-	opSensorSettingStatus=0x5B
+	opSensorDescriptorGet            = 0x8230
+	opSensorDescriptorStatus         = 0x51
+	opSensorGet                      = 0x8231
+	opSensorStatus                   = 0x52
+	opSensorColumnGet                = 0x8232
+	opSensorColumnStatus             = 0x53
+	opSensorSeriesGet                = 0x8233
+	opSensorSeriesStatus             = 0x54
+	opSensorCadenceGet               = 0x8234
+	opSensorCadenceSet               = 0x55
+	opSensorCadenceSetUnacknowledged = 0x56
+	opSensorCadenceStatus            = 0x57
+	opSensorSettingsGet              = 0x8235
+	opSensorSettingsStatus           = 0x58
+	opSensorSettingGet               = 0x8236
+	opSensorSettingSet               = 0x59
+	opSensorSettingSetUnacknowledged = 0x5A
+	opSensorSettingStatus            = 0x5B
+)
+
+type (
+	SensorDescriptor struct {
+		PropertyID        uint
+		PositiveTolerance uint
+		NegativeTolerance uint
+		SamplingFunction  uint
+		MeasurementPeriod uint
+		UpdateInterval    uint
+	}
+
+	// SensorColumn is a column of a data series, the raw values have the format of the property
+	SensorColumn struct {
+		X     SensorValue
+		Width SensorValue
+		Y     SensorValue
+	}
+
+	SensorCadence struct {
+		PropertyID               uint
+		FastCadencePeriodDivisor uint
+		// 0: the deltas have the format of the property, 1: unitless in 0.01 %
+		StatusTriggerType      uint
+		StatusTriggerDeltaDown uint
+		StatusTriggerDeltaUp   uint
+		StatusMinInterval      uint
+		FastCadenceLow         SensorValue
+		FastCadenceHigh        SensorValue
+	}
+
+	SensorSetting struct {
+		SettingPropertyID uint
+		Access            uint
+		Value             SensorValue
+	}
+
+	// SensorState is the state of the sensor server model, keyed by property ID
+	SensorState struct {
+		Descriptors map[uint]SensorDescriptor       `json:"descriptors"`
+		Values      map[uint]SensorValue            `json:"values"`
+		Series      map[uint][]SensorColumn         `json:"series"`
+		Cadences    map[uint]SensorCadence          `json:"cadences"`
+		Settings    map[uint]map[uint]SensorSetting `json:"settings"`
+	}
 )
+
+const (
+	sensorDescriptorSize = 8
+	// the length of the format B marshalled property meaning a zero length value
+	sensorZeroLength = 0x7F
+)
+
+var (
+	loggerSensorCli = utils.CreateLogger("SensorClient")
+	sensorListener  = modelMsglistener(sensorStatusReceive)
+)
+
+func init() {
+	registerModelMessageRxListener(&sensorListener)
+}
+
+func (m *Model) sensorState() SensorState {
+	state, _ := m.State.(SensorState)
+	if state.Descriptors == nil {
+		state.Descriptors = map[uint]SensorDescriptor{}
+	}
+	if state.Values == nil {
+		state.Values = map[uint]SensorValue{}
+	}
+	if state.Series == nil {
+		state.Series = map[uint][]SensorColumn{}
+	}
+	if state.Cadences == nil {
+		state.Cadences = map[uint]SensorCadence{}
+	}
+	if state.Settings == nil {
+		state.Settings = map[uint]map[uint]SensorSetting{}
+	}
+	return state
+}
+
+func sensorPropertyParam(propertyID uint) []byte {
+	return sensorRawBytes(propertyID, 2)
+}
+
+// parseSensorDescriptors parses the 8 octet descriptors, a descriptor status of
+// 2 octets holds the property ID the sensor does not have
+func parseSensorDescriptors(data []byte) ([]SensorDescriptor, error) {
+	if len(data) == 2 {
+		return []SensorDescriptor{}, nil
+	}
+	if len(data)%sensorDescriptorSize != 0 {
+		return nil, errors.DataLengthCheckFailed.New().AddContextF("sensor descriptor: % 2x", data)
+	}
+	descriptors := []SensorDescriptor{}
+	for i := 0; i < len(data); i += sensorDescriptorSize {
+		v := binary.LittleEndian.Uint64(data[i : i+sensorDescriptorSize])
+		descriptors = append(descriptors, SensorDescriptor{
+			PropertyID:        uint(v & 0xFFFF),
+			PositiveTolerance: uint(v>>16) & 0xFFF,
+			NegativeTolerance: uint(v>>28) & 0xFFF,
+			SamplingFunction:  uint(v>>40) & 0xFF,
+			MeasurementPeriod: uint(v>>48) & 0xFF,
+			UpdateInterval:    uint(v>>56) & 0xFF,
+		})
+	}
+	return descriptors, nil
+}
+
+// parseSensorData parses the marshalled sensor data, a sequence of property IDs
+// with their raw values in format A or format B
+func parseSensorData(data []byte) ([]SensorValue, error) {
+	values := []SensorValue{}
+	for len(data) > 0 {
+		var length, propertyID uint
+		if data[0]&0x01 == 0 {
+			// format A: 4 bits length, 11 bits property ID
+			if len(data) < 2 {
+				return nil, errors.DataLengthCheckFailed.New().AddContextF("sensor data: % 2x", data)
+			}
+			v := uint(binary.LittleEndian.Uint16(data))
+			length, propertyID = (v>>1)&0x0F+1, v>>5
+			data = data[2:]
+		} else {
+			// format B: 7 bits length, 16 bits property ID
+			if len(data) < 3 {
+				return nil, errors.DataLengthCheckFailed.New().AddContextF("sensor data: % 2x", data)
+			}
+			length, propertyID = uint(data[0]>>1)+1, uint(binary.LittleEndian.Uint16(data[1:]))
+			if length == sensorZeroLength+1 {
+				length = 0
+			}
+			data = data[3:]
+		}
+		if uint(len(data)) < length {
+			return nil, errors.DataLengthCheckFailed.New().AddContextF("sensor data of property %04x: % 2x", propertyID, data)
+		}
+		values = append(values, decodeSensorValue(propertyID, data[:length]))
+		data = data[length:]
+	}
+	return values, nil
+}
+
+// parseSensorColumns parses the columns of the property, each of them is X, width and Y
+func parseSensorColumns(propertyID uint, data []byte) ([]SensorColumn, error) {
+	size, err := sensorPropertySize(propertyID)
+	if err != nil {
+		return nil, err
+	}
+	columns := []SensorColumn{}
+	for len(data) > 0 {
+		column := SensorColumn{}
+		if len(data) == size {
+			// the column does not exist, only X is sent back
+			column.X = decodeSensorValue(propertyID, data)
+			return append(columns, column), nil
+		}
+		if len(data) < 3*size {
+			return nil, errors.DataLengthCheckFailed.New().AddContextF("sensor column of property %04x: % 2x", propertyID, data)
+		}
+		column.X = decodeSensorValue(propertyID, data[:size])
+		column.Width = decodeSensorValue(propertyID, data[size:2*size])
+		column.Y = decodeSensorValue(propertyID, data[2*size:3*size])
+		columns = append(columns, column)
+		data = data[3*size:]
+	}
+	return columns, nil
+}
+
+func parseSensorCadence(data []byte) (SensorCadence, error) {
+	cadence := SensorCadence{PropertyID: uint(binary.LittleEndian.Uint16(data))}
+	data = data[2:]
+	if len(data) == 0 {
+		// the cadence of the property is not supported
+		return cadence, nil
+	}
+	size, err := sensorPropertySize(cadence.PropertyID)
+	if err != nil {
+		return cadence, err
+	}
+	cadence.FastCadencePeriodDivisor = uint(data[0] & 0x7F)
+	cadence.StatusTriggerType = uint(data[0] >> 7)
+	deltaSize := size
+	if cadence.StatusTriggerType == 1 {
+		deltaSize = 2
+	}
+	if len(data) != 1+2*deltaSize+1+2*size {
+		return cadence, errors.DataLengthCheckFailed.New().AddContextF("sensor cadence: % 2x", data)
+	}
+	data = data[1:]
+	cadence.StatusTriggerDeltaDown = sensorRawUint(data[:deltaSize])
+	cadence.StatusTriggerDeltaUp = sensorRawUint(data[deltaSize : 2*deltaSize])
+	data = data[2*deltaSize:]
+	cadence.StatusMinInterval = uint(data[0])
+	cadence.FastCadenceLow = decodeSensorValue(cadence.PropertyID, data[1:1+size])
+	cadence.FastCadenceHigh = decodeSensorValue(cadence.PropertyID, data[1+size:])
+	return cadence, nil
+}
+
+func (m *Model) handleSensorDescriptorResponse(msg *AccessMessage) error {
+	descriptors, err := parseSensorDescriptors(msg.payload)
+	if err != nil {
+		return err
+	}
+	state := m.sensorState()
+	for _, d := range descriptors {
+		state.Descriptors[d.PropertyID] = d
+	}
+	m.State = state
+	return nil
+}
+
+// SensorDescriptorGet reads the descriptor of the property, or of all the properties if propertyID is 0
+func SensorDescriptorGet(dst, propertyID uint) error {
+	m, err := findModelDirectly(dst, SensorServer)
+	if err != nil {
+		return err
+	}
+	var payload []byte
+	if propertyID != 0 {
+		payload = sensorPropertyParam(propertyID)
+	}
+	return modelSendTmpl(false, dst, opSensorDescriptorGet, payload, m.handleSensorDescriptorResponse)
+}
+
+func (m *Model) updateSensorValues(data []byte) error {
+	values, err := parseSensorData(data)
+	if err != nil {
+		return err
+	}
+	state := m.sensorState()
+	for _, v := range values {
+		if len(v.Raw) == 0 {
+			// the sensor does not have the property
+			continue
+		}
+		state.Values[v.PropertyID] = v
+		loggerSensorCli.Debugf("sensor %04x: %s = %v %s", m.Element.UnicastAddress, v.Name, v.Value, v.Unit)
+	}
+	m.State = state
+	return nil
+}
+
+func (m *Model) handleSensorResponse(msg *AccessMessage) error {
+	return m.updateSensorValues(msg.payload)
+}
+
+// SensorGet reads the value of the property, or of all the properties if propertyID is 0
+func SensorGet(dst, propertyID uint) error {
+	m, err := findModelDirectly(dst, SensorServer)
+	if err != nil {
+		return err
+	}
+	var payload []byte
+	if propertyID != 0 {
+		payload = sensorPropertyParam(propertyID)
+	}
+	return modelSendTmpl(false, dst, opSensorGet, payload, m.handleSensorResponse)
+}
+
+// sensorStatusReceive records the sensor values published by the sensor servers
+func sensorStatusReceive(msg *AccessMessage) {
+	if msg.opcode != opSensorStatus {
+		return
+	}
+	if txMsg, ok := txAccessMsgs[msg.src]; ok && txMsg.expectedRespOpcode == msg.opcode {
+		// the response is handled by the request
+		return
+	}
+	m, err := findModelDirectly(msg.src, SensorServer)
+	if err != nil {
+		loggerSensorCli.Warn(err)
+		return
+	}
+	if err := m.updateSensorValues(msg.payload); err != nil {
+		loggerSensorCli.Warn(err)
+	}
+}
+
+func (m *Model) handleSensorSeriesResponse(msg *AccessMessage) error {
+	propertyID := uint(binary.LittleEndian.Uint16(msg.payload))
+	columns, err := parseSensorColumns(propertyID, msg.payload[2:])
+	if err != nil {
+		return err
+	}
+	state := m.sensorState()
+	state.Series[propertyID] = columns
+	m.State = state
+	return nil
+}
+
+// SensorColumnGet reads the column of the data series starting at the raw X value
+func SensorColumnGet(dst, propertyID, rawValueX uint) error {
+	m, err := findModelDirectly(dst, SensorServer)
+	if err != nil {
+		return err
+	}
+	size, err := sensorPropertySize(propertyID)
+	if err != nil {
+		return err
+	}
+	payload := append(sensorPropertyParam(propertyID), sensorRawBytes(rawValueX, size)...)
+	return modelSendTmpl(false, dst, opSensorColumnGet, payload, m.handleSensorSeriesResponse)
+}
+
+// SensorSeriesGet reads the columns between the raw X values, or all of them if both are 0
+func SensorSeriesGet(dst, propertyID, rawValueX1, rawValueX2 uint) error {
+	m, err := findModelDirectly(dst, SensorServer)
+	if err != nil {
+		return err
+	}
+	size, err := sensorPropertySize(propertyID)
+	if err != nil {
+		return err
+	}
+	payload := sensorPropertyParam(propertyID)
+	if rawValueX1 != 0 || rawValueX2 != 0 {
+		payload = append(payload, sensorRawBytes(rawValueX1, size)...)
+		payload = append(payload, sensorRawBytes(rawValueX2, size)...)
+	}
+	return modelSendTmpl(false, dst, opSensorSeriesGet, payload, m.handleSensorSeriesResponse)
+}
+
+func (m *Model) handleSensorCadenceResponse(msg *AccessMessage) error {
+	cadence, err := parseSensorCadence(msg.payload)
+	if err != nil {
+		return err
+	}
+	state := m.sensorState()
+	state.Cadences[cadence.PropertyID] = cadence
+	m.State = state
+	return nil
+}
+
+func SensorCadenceGet(dst, propertyID uint) error {
+	m, err := findModelDirectly(dst, SensorServer)
+	if err != nil {
+		return err
+	}
+	return modelSendTmpl(false, dst, opSensorCadenceGet, sensorPropertyParam(propertyID), m.handleSensorCadenceResponse)
+}
+
+// SensorCadenceSet publishes the property every publish period / 2^fastCadencePeriodDivisor while
+// its value is within fastCadenceLow and fastCadenceHigh, and when it changes by the trigger deltas.
+// The deltas and the cadence range are raw values of the property.
+func SensorCadenceSet(dst, propertyID, fastCadencePeriodDivisor, statusTriggerType, statusTriggerDeltaDown, statusTriggerDeltaUp, statusMinInterval, fastCadenceLow, fastCadenceHigh uint) error {
+	m, err := findModelDirectly(dst, SensorServer)
+	if err != nil {
+		return err
+	}
+	size, err := sensorPropertySize(propertyID)
+	if err != nil {
+		return err
+	}
+	deltaSize := size
+	if statusTriggerType == 1 {
+		deltaSize = 2
+	}
+	payload := sensorPropertyParam(propertyID)
+	payload = append(payload, byte(fastCadencePeriodDivisor&0x7F|statusTriggerType<<7))
+	payload = append(payload, sensorRawBytes(statusTriggerDeltaDown, deltaSize)...)
+	payload = append(payload, sensorRawBytes(statusTriggerDeltaUp, deltaSize)...)
+	payload = append(payload, byte(statusMinInterval))
+	payload = append(payload, sensorRawBytes(fastCadenceLow, size)...)
+	payload = append(payload, sensorRawBytes(fastCadenceHigh, size)...)
+	return modelSendTmpl(false, dst, opSensorCadenceSet, payload, m.handleSensorCadenceResponse)
+}
+
+func (m *Model) handleSensorSettingsResponse(msg *AccessMessage) error {
+	propertyID := uint(binary.LittleEndian.Uint16(msg.payload))
+	state := m.sensorState()
+	settings := state.Settings[propertyID]
+	if settings == nil {
+		settings = map[uint]SensorSetting{}
+		state.Settings[propertyID] = settings
+	}
+	for i := 2; i+1 < len(msg.payload); i += 2 {
+		id := uint(binary.LittleEndian.Uint16(msg.payload[i:]))
+		if _, ok := settings[id]; !ok {
+			settings[id] = SensorSetting{SettingPropertyID: id}
+		}
+	}
+	m.State = state
+	return nil
+}
+
+// SensorSettingsGet reads the setting property IDs of the sensor property
+func SensorSettingsGet(dst, propertyID uint) error {
+	m, err := findModelDirectly(dst, SensorServer)
+	if err != nil {
+		return err
+	}
+	return modelSendTmpl(false, dst, opSensorSettingsGet, sensorPropertyParam(propertyID), m.handleSensorSettingsResponse)
+}
+
+func (m *Model) handleSensorSettingResponse(msg *AccessMessage) error {
+	propertyID := uint(binary.LittleEndian.Uint16(msg.payload))
+	setting := SensorSetting{SettingPropertyID: uint(binary.LittleEndian.Uint16(msg.payload[2:]))}
+	if len(msg.payload) == 4 {
+		return errors.InvalidResponse.New().AddContextF("setting %04x of property %04x not found", setting.SettingPropertyID, propertyID)
+	}
+	setting.Access = uint(msg.payload[4])
+	setting.Value = decodeSensorValue(setting.SettingPropertyID, msg.payload[5:])
+	state := m.sensorState()
+	if state.Settings[propertyID] == nil {
+		state.Settings[propertyID] = map[uint]SensorSetting{}
+	}
+	state.Settings[propertyID][setting.SettingPropertyID] = setting
+	m.State = state
+	return nil
+}
+
+func SensorSettingGet(dst, propertyID, settingPropertyID uint) error {
+	m, err := findModelDirectly(dst, SensorServer)
+	if err != nil {
+		return err
+	}
+	payload := append(sensorPropertyParam(propertyID), sensorPropertyParam(settingPropertyID)...)
+	return modelSendTmpl(false, dst, opSensorSettingGet, payload, m.handleSensorSettingResponse)
+}
+
+// SensorSettingSet writes the raw value of the setting, its format is the one of the setting property
+func SensorSettingSet(dst, propertyID, settingPropertyID, rawValue uint) error {
+	m, err := findModelDirectly(dst, SensorServer)
+	if err != nil {
+		return err
+	}
+	size, err := sensorPropertySize(settingPropertyID)
+	if err != nil {
+		return err
+	}
+	payload := append(sensorPropertyParam(propertyID), sensorPropertyParam(settingPropertyID)...)
+	payload = append(payload, sensorRawBytes(rawValue, size)...)
+	return modelSendTmpl(false, dst, opSensorSettingSet, payload, m.handleSensorSettingResponse)
+}
@@ -0,0 +1,52 @@
+package mesh
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseSensorData(t *testing.T) {
+	// format A: ambient temperature 0x004f of 22.5 °C, format B: light level 0x004e of 350.25 lux
+	values, err := parseSensorData([]byte{0xe0, 0x09, 0x2d, 0x05, 0x4e, 0x00, 0xd1, 0x88, 0x00})
+	assert.Nil(t, err)
+	assert.Len(t, values, 2)
+	assert.Equal(t, "Present Ambient Temperature", values[0].Name)
+	assert.Equal(t, 22.5, values[0].Value)
+	assert.Equal(t, "lx", values[1].Unit)
+	assert.InDelta(t, 350.25, values[1].Value, 0.001)
+
+	// format B, zero length: the sensor does not have the property
+	values, err = parseSensorData([]byte{0xff, 0x4e, 0x00})
+	assert.Nil(t, err)
+	assert.Empty(t, values[0].Raw)
+	assert.Nil(t, values[0].Value)
+
+	_, err = parseSensorData([]byte{0xe0, 0x09})
+	assert.NotNil(t, err)
+}
+
+func Test_decodeSensorValue(t *testing.T) {
+	assert.Equal(t, -5.0, decodeSensorValue(0x004f, []byte{0xf6}).Value)
+	// unknown value
+	assert.Nil(t, decodeSensorValue(0x004f, []byte{0x7f}).Value)
+	assert.Equal(t, true, decodeSensorValue(0x004d, []byte{0x01}).Value)
+	// not registered
+	v := decodeSensorValue(0x0fff, []byte{0x01, 0x02})
+	assert.Nil(t, v.Value)
+	assert.Equal(t, []byte{0x01, 0x02}, v.Raw)
+}
+
+func Test_parseSensorCadence(t *testing.T) {
+	// motion sensed, divisor 2, trigger type 0, deltas 1 and 2, min interval 3, fast cadence 10..100 %
+	cadence, err := parseSensorCadence([]byte{0x42, 0x00, 0x02, 0x01, 0x02, 0x03, 0x14, 0xc8})
+	assert.Nil(t, err)
+	assert.Equal(t, uint(2), cadence.FastCadencePeriodDivisor)
+	assert.Equal(t, uint(2), cadence.StatusTriggerDeltaUp)
+	assert.Equal(t, 10.0, cadence.FastCadenceLow.Value)
+	assert.Equal(t, 100.0, cadence.FastCadenceHigh.Value)
+
+	descriptors, err := parseSensorDescriptors([]byte{0x4f, 0x00, 0x01, 0x20, 0x00, 0x01, 0x02, 0x03})
+	assert.Nil(t, err)
+	assert.Equal(t, SensorDescriptor{PropertyID: 0x4f, PositiveTolerance: 1, NegativeTolerance: 2, SamplingFunction: 1, MeasurementPeriod: 2, UpdateInterval: 3}, descriptors[0])
+}
@@ -0,0 +1,152 @@
+package mesh
+
+import (
+	"ble-mesh/utils/errors"
+	"sort"
+)
+
+type (
+	// sensorCharacteristic is the format of the raw value of a device property
+	sensorCharacteristic struct {
+		size       int
+		signed     bool
+		resolution float64
+		unit       string
+		// the raw value meaning the value is not known, 0 if there is none
+		unknown uint
+	}
+
+	sensorProperty struct {
+		name string
+		*sensorCharacteristic
+	}
+
+	// SensorValue is the raw value of a device property decoded into its unit
+	SensorValue struct {
+		PropertyID uint
+		Name       string
+		Unit       string
+		// nil if the property is not registered or the sensor does not know the value
+		Value interface{}
+		Raw   []byte
+	}
+
+	SensorPropertyInfo struct {
+		PropertyID uint
+		Name       string
+		Unit       string
+		Size       int
+	}
+)
+
+var (
+	// characteristics of the GATT Specification Supplement
+	charBoolean             = &sensorCharacteristic{size: 1, resolution: 1}
+	charCount16             = &sensorCharacteristic{size: 2, resolution: 1, unknown: 0xFFFF}
+	charPercentage8         = &sensorCharacteristic{size: 1, resolution: 0.5, unit: "%", unknown: 0xFF}
+	charIlluminance         = &sensorCharacteristic{size: 3, resolution: 0.01, unit: "lx", unknown: 0xFFFFFF}
+	charTemperature8        = &sensorCharacteristic{size: 1, signed: true, resolution: 0.5, unit: "°C", unknown: 0x7F}
+	charTemperature         = &sensorCharacteristic{size: 2, signed: true, resolution: 0.01, unit: "°C", unknown: 0x8000}
+	charHumidity            = &sensorCharacteristic{size: 2, resolution: 0.01, unit: "%", unknown: 0xFFFF}
+	charCorrelatedColorTemp = &sensorCharacteristic{size: 2, resolution: 1, unit: "K", unknown: 0xFFFF}
+	charPower               = &sensorCharacteristic{size: 3, resolution: 0.1, unit: "W", unknown: 0xFFFFFF}
+	charEnergy              = &sensorCharacteristic{size: 3, resolution: 1, unit: "kWh", unknown: 0xFFFFFF}
+	charEnergy32            = &sensorCharacteristic{size: 4, resolution: 0.001, unit: "kWh", unknown: 0xFFFFFFFF}
+	charVoltage             = &sensorCharacteristic{size: 2, resolution: 1.0 / 64, unit: "V", unknown: 0xFFFF}
+	charElectricCurrent     = &sensorCharacteristic{size: 2, resolution: 0.01, unit: "A", unknown: 0xFFFF}
+	charTimeSecond16        = &sensorCharacteristic{size: 2, resolution: 1, unit: "s", unknown: 0xFFFF}
+	charConcentrationPpm    = &sensorCharacteristic{size: 2, resolution: 1, unit: "ppm", unknown: 0xFFFF}
+	charConcentrationPpb    = &sensorCharacteristic{size: 2, resolution: 1, unit: "ppb", unknown: 0xFFFF}
+	charNoise               = &sensorCharacteristic{size: 1, resolution: 1, unit: "dB", unknown: 0xFF}
+
+	// Mesh Device Properties
+	sensorProperties = map[uint]*sensorProperty{
+		0x0042: {"Motion Sensed", charPercentage8},
+		0x0043: {"Motion Threshold", charPercentage8},
+		0x004C: {"People Count", charCount16},
+		0x004D: {"Presence Detected", charBoolean},
+		0x004E: {"Present Ambient Light Level", charIlluminance},
+		0x004F: {"Present Ambient Temperature", charTemperature8},
+		0x0051: {"Present Correlated Color Temperature", charCorrelatedColorTemp},
+		0x0052: {"Present Device Input Power", charPower},
+		0x0053: {"Present Device Operating Efficiency", charPercentage8},
+		0x0054: {"Present Device Operating Temperature", charTemperature},
+		0x0056: {"Present Indoor Ambient Temperature", charTemperature8},
+		0x0057: {"Present Input Current", charElectricCurrent},
+		0x0059: {"Present Input Voltage", charVoltage},
+		0x005B: {"Present Outdoor Ambient Temperature", charTemperature8},
+		0x0068: {"Time Since Motion Sensed", charTimeSecond16},
+		0x0069: {"Time Since Presence Detected", charTimeSecond16},
+		0x006A: {"Total Device Energy Use", charEnergy},
+		0x0072: {"Precise Total Device Energy Use", charEnergy32},
+		0x0076: {"Present Ambient Relative Humidity", charHumidity},
+		0x0077: {"Present Ambient Carbon Dioxide Concentration", charConcentrationPpm},
+		0x0078: {"Present Ambient Volatile Organic Compounds Concentration", charConcentrationPpb},
+		0x0079: {"Present Ambient Noise", charNoise},
+	}
+)
+
+// GetSensorProperties returns the device properties whose values are decoded
+func GetSensorProperties() []SensorPropertyInfo {
+	infos := []SensorPropertyInfo{}
+	for id, p := range sensorProperties {
+		infos = append(infos, SensorPropertyInfo{PropertyID: id, Name: p.name, Unit: p.unit, Size: p.size})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].PropertyID < infos[j].PropertyID })
+	return infos
+}
+
+// sensorPropertySize returns the size of the raw value of the property
+func sensorPropertySize(propertyID uint) (int, error) {
+	p, ok := sensorProperties[propertyID]
+	if !ok {
+		return 0, errors.SensorPropertyNotSupported.New().AddContextF("property:%04x", propertyID)
+	}
+	return p.size, nil
+}
+
+// sensorRawUint reads the little endian raw value
+func sensorRawUint(raw []byte) uint {
+	var v uint
+	for i := len(raw) - 1; i >= 0; i-- {
+		v = v<<8 | uint(raw[i])
+	}
+	return v
+}
+
+// sensorRawBytes writes the raw value of the size in little endian
+func sensorRawBytes(v uint, size int) []byte {
+	raw := make([]byte, size)
+	for i := range raw {
+		raw[i] = byte(v >> (8 * uint(i)))
+	}
+	return raw
+}
+
+func (c *sensorCharacteristic) decode(raw []byte) interface{} {
+	if len(raw) != c.size {
+		return nil
+	}
+	v := sensorRawUint(raw)
+	if c.unknown != 0 && v == c.unknown {
+		return nil
+	}
+	if c == charBoolean {
+		return v != 0
+	}
+	if c.signed && v&(1<<(8*uint(c.size)-1)) != 0 {
+		return float64(int64(v)-int64(1)<<(8*uint(c.size))) * c.resolution
+	}
+	return float64(v) * c.resolution
+}
+
+// decodeSensorValue decodes the raw value with the characteristic of the property
+func decodeSensorValue(propertyID uint, raw []byte) SensorValue {
+	value := SensorValue{PropertyID: propertyID, Raw: append([]byte{}, raw...)}
+	if p, ok := sensorProperties[propertyID]; ok {
+		value.Name = p.name
+		value.Unit = p.unit
+		value.Value = p.decode(raw)
+	}
+	return value
+}
@@ -0,0 +1,397 @@
+package mesh
+
+import (
+	"ble-mesh/utils"
+	"ble-mesh/utils/errors"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+type (
+	// TimeState is the state of the time server model, the TAI-UTC delta and
+	// the time zone offsets are decoded, in seconds and in 15 minute steps
+	TimeState struct {
+		TAISeconds     uint      `json:"taiSeconds"`
+		Subsecond      uint      `json:"subsecond"`
+		Uncertainty    uint      `json:"uncertainty"`
+		TimeAuthority  bool      `json:"timeAuthority"`
+		TAIUTCDelta    int       `json:"taiUtcDelta"`
+		TimeZoneOffset int       `json:"timeZoneOffset"`
+		Time           time.Time `json:"time"`
+		Role           uint      `json:"role"`
+		// upcoming changes of the time zone offset and of the TAI-UTC delta
+		TimeZoneOffsetNew int  `json:"timeZoneOffsetNew"`
+		TAIOfZoneChange   uint `json:"taiOfZoneChange"`
+		TAIUTCDeltaNew    int  `json:"taiUtcDeltaNew"`
+		TAIOfDeltaChange  uint `json:"taiOfDeltaChange"`
+	}
+
+	TimeAuthorityStatus struct {
+		Running  bool
+		Node     uint
+		Interval time.Duration
+		LastSync time.Time
+		Error    string
+	}
+
+	timeAuthority struct {
+		dst      uint
+		interval time.Duration
+		lastSync time.Time
+		err      error
+		stop     chan struct{}
+		done     chan struct{}
+	}
+)
+
+const (
+	TIME_ROLE_NONE      = 0x00
+	TIME_ROLE_AUTHORITY = 0x01
+	TIME_ROLE_RELAY     = 0x02
+	TIME_ROLE_CLIENT    = 0x03
+
+	// 2000-01-01T00:00:00 UTC, the TAI seconds count from 2000-01-01T00:00:00 TAI
+	// so they are the seconds since this time plus the TAI-UTC delta
+	taiEpoch = 946684800
+	// the encoded TAI-UTC delta and time zone offset are offset by these values
+	taiUtcDeltaOffset    = 255
+	timeZoneOffsetOffset = 64
+	timeZoneStep         = 15 * time.Minute
+
+	// the uncertainty of the host time in 10 ms steps, mostly the delay of the mesh
+	hostTimeUncertainty = 10
+	// TAI-UTC delta since the leap second of 2016-12-31
+	defaultTaiUtcDelta = 37
+)
+
+var (
+	loggerTimeCli = utils.CreateLogger("TimeClient")
+	timeListener  = modelMsglistener(timeStatusReceive)
+	timeLock      sync.Mutex
+	timeAuth      *timeAuthority
+	hostTaiUtc    = defaultTaiUtcDelta
+	hostClock     = time.Now
+)
+
+func init() {
+	registerModelMessageRxListener(&timeListener)
+}
+
+func (m *Model) timeState() TimeState {
+	state, _ := m.State.(TimeState)
+	return state
+}
+
+// timeToTai converts the UTC time in TAI seconds and subsecond in 1/256 s
+func timeToTai(t time.Time, taiUtcDelta int) (uint, uint) {
+	seconds := t.Unix() - taiEpoch + int64(taiUtcDelta)
+	return uint(seconds), uint(t.Nanosecond() * 256 / int(time.Second))
+}
+
+// taiToTime converts the TAI seconds and subsecond in UTC time
+func taiToTime(taiSeconds, subsecond uint, taiUtcDelta int) time.Time {
+	seconds := int64(taiSeconds) + taiEpoch - int64(taiUtcDelta)
+	return time.Unix(seconds, int64(subsecond)*int64(time.Second)/256).UTC()
+}
+
+func taiSecondsBytes(taiSeconds uint) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(taiSeconds))
+	return b[:5]
+}
+
+func taiSecondsFromBytes(b []byte) uint {
+	return uint(binary.LittleEndian.Uint64(append(append([]byte{}, b[:5]...), 0, 0, 0)))
+}
+
+// timeZoneOffset returns the offset of the zone of the time in 15 minute steps
+func timeZoneOffset(t time.Time) int {
+	_, offset := t.Zone()
+	return offset / int(timeZoneStep/time.Second)
+}
+
+// encodeTime encodes the time of the Time Set and Time Status messages
+func encodeTime(state TimeState) []byte {
+	data := append(taiSecondsBytes(state.TAISeconds), byte(state.Subsecond), byte(state.Uncertainty))
+	v := uint16(state.TAIUTCDelta+taiUtcDeltaOffset) << 1
+	if state.TimeAuthority {
+		v |= 1
+	}
+	data = append(data, byte(v), byte(v>>8))
+	return append(data, byte(state.TimeZoneOffset+timeZoneOffsetOffset))
+}
+
+// parseTimeStatus parses the time status, the fields after the TAI seconds are absent if they are 0
+func parseTimeStatus(data []byte, state *TimeState) {
+	state.TAISeconds = taiSecondsFromBytes(data)
+	if len(data) < 10 {
+		state.Subsecond, state.Uncertainty, state.TimeAuthority = 0, 0, false
+		state.Time = time.Time{}
+		return
+	}
+	state.Subsecond = uint(data[5])
+	state.Uncertainty = uint(data[6])
+	v := binary.LittleEndian.Uint16(data[7:])
+	state.TimeAuthority = v&1 == 1
+	state.TAIUTCDelta = int(v>>1) - taiUtcDeltaOffset
+	state.TimeZoneOffset = int(data[9]) - timeZoneOffsetOffset
+	state.Time = taiToTime(state.TAISeconds, state.Subsecond, state.TAIUTCDelta)
+}
+
+func parseTimeZoneStatus(data []byte, state *TimeState) {
+	state.TimeZoneOffset = int(data[0]) - timeZoneOffsetOffset
+	state.TimeZoneOffsetNew = int(data[1]) - timeZoneOffsetOffset
+	state.TAIOfZoneChange = taiSecondsFromBytes(data[2:])
+}
+
+func parseTAIUTCDeltaStatus(data []byte, state *TimeState) {
+	state.TAIUTCDelta = int(binary.LittleEndian.Uint16(data)&0x7FFF) - taiUtcDeltaOffset
+	state.TAIUTCDeltaNew = int(binary.LittleEndian.Uint16(data[2:])&0x7FFF) - taiUtcDeltaOffset
+	state.TAIOfDeltaChange = taiSecondsFromBytes(data[4:])
+}
+
+func (m *Model) updateTimeState(msg *AccessMessage) {
+	state := m.timeState()
+	switch msg.opcode {
+	case opTimeStatus:
+		parseTimeStatus(msg.payload, &state)
+	case opTimeRoleStatus:
+		state.Role = uint(msg.payload[0])
+	case opTimeZoneStatus:
+		parseTimeZoneStatus(msg.payload, &state)
+	case opTAI_UTCDeltaStatus:
+		parseTAIUTCDeltaStatus(msg.payload, &state)
+	}
+	m.State = state
+}
+
+func (m *Model) handleTimeResponse(msg *AccessMessage) error {
+	m.updateTimeState(msg)
+	return nil
+}
+
+// timeStatusReceive records the time published by the time servers
+func timeStatusReceive(msg *AccessMessage) {
+	if msg.opcode != opTimeStatus {
+		return
+	}
+	if txMsg, ok := txAccessMsgs[msg.src]; ok && txMsg.expectedRespOpcode == msg.opcode {
+		// the response is handled by the request
+		return
+	}
+	if validator := expectedRespLen[msg.opcode]; !validator(msg.payload) {
+		loggerTimeCli.Warnf("invalid time status from %04x: % 2x", msg.src, msg.payload)
+		return
+	}
+	m, err := findModelDirectly(msg.src, TimeServer)
+	if err != nil {
+		loggerTimeCli.Warn(err)
+		return
+	}
+	m.updateTimeState(msg)
+}
+
+func TimeGet(dst uint) error {
+	m, err := findModelDirectly(dst, TimeServer)
+	if err != nil {
+		return err
+	}
+	return modelSendTmpl(false, dst, opTimeGet, nil, m.handleTimeResponse)
+}
+
+func timeSet(dst uint, state TimeState) error {
+	m, err := findModelDirectly(dst, TimeServer)
+	if err != nil {
+		return err
+	}
+	return modelSendTmpl(false, dst, opTimeSet, encodeTime(state), m.handleTimeResponse)
+}
+
+// TimeSet sets the TAI time with the subsecond in 1/256 s and the uncertainty
+// in 10 ms steps, the TAI-UTC delta and the time zone offset are the host ones
+func TimeSet(dst, taiSeconds, subsecond, uncertainty uint) error {
+	timeLock.Lock()
+	delta := hostTaiUtc
+	timeLock.Unlock()
+	return timeSet(dst, TimeState{
+		TAISeconds:     taiSeconds,
+		Subsecond:      subsecond,
+		Uncertainty:    uncertainty,
+		TimeAuthority:  true,
+		TAIUTCDelta:    delta,
+		TimeZoneOffset: timeZoneOffset(hostClock()),
+	})
+}
+
+// TimeSetHost sets the time of the node to the system time of the host
+func TimeSetHost(dst uint) error {
+	now := hostClock()
+	timeLock.Lock()
+	delta := hostTaiUtc
+	timeLock.Unlock()
+	taiSeconds, subsecond := timeToTai(now, delta)
+	return timeSet(dst, TimeState{
+		TAISeconds:     taiSeconds,
+		Subsecond:      subsecond,
+		Uncertainty:    hostTimeUncertainty,
+		TimeAuthority:  true,
+		TAIUTCDelta:    delta,
+		TimeZoneOffset: timeZoneOffset(now),
+	})
+}
+
+func TimeRoleGet(dst uint) error {
+	m, err := findModelDirectly(dst, TimeServer)
+	if err != nil {
+		return err
+	}
+	return modelSendTmpl(false, dst, opTimeRoleGet, nil, m.handleTimeResponse)
+}
+
+// TimeRoleSet sets the time role of the node, one of TIME_ROLE_*
+func TimeRoleSet(dst, role uint) error {
+	if role > TIME_ROLE_CLIENT {
+		return errors.WrongTimeSetting.New().AddContextF("time role: %d", role)
+	}
+	m, err := findModelDirectly(dst, TimeServer)
+	if err != nil {
+		return err
+	}
+	return modelSendTmpl(false, dst, opTimeRoleSet, []byte{byte(role)}, m.handleTimeResponse)
+}
+
+func TimeZoneGet(dst uint) error {
+	m, err := findModelDirectly(dst, TimeServer)
+	if err != nil {
+		return err
+	}
+	return modelSendTmpl(false, dst, opTimeZoneGet, nil, m.handleTimeResponse)
+}
+
+// TimeZoneSet sets the upcoming time zone offset in 15 minute steps and the TAI seconds of the change
+func TimeZoneSet(dst uint, offsetNew int, taiOfZoneChange uint) error {
+	m, err := findModelDirectly(dst, TimeServer)
+	if err != nil {
+		return err
+	}
+	payload := append([]byte{byte(offsetNew + timeZoneOffsetOffset)}, taiSecondsBytes(taiOfZoneChange)...)
+	return modelSendTmpl(false, dst, opTimeZoneSet, payload, m.handleTimeResponse)
+}
+
+func TAIUTCDeltaGet(dst uint) error {
+	m, err := findModelDirectly(dst, TimeServer)
+	if err != nil {
+		return err
+	}
+	return modelSendTmpl(false, dst, opTAI_UTCDeltaGet, nil, m.handleTimeResponse)
+}
+
+// TAIUTCDeltaSet sets the upcoming TAI-UTC delta in seconds and the TAI seconds of the change
+func TAIUTCDeltaSet(dst uint, deltaNew int, taiOfDeltaChange uint) error {
+	m, err := findModelDirectly(dst, TimeServer)
+	if err != nil {
+		return err
+	}
+	v := uint16(deltaNew + taiUtcDeltaOffset)
+	payload := append([]byte{byte(v), byte(v >> 8)}, taiSecondsBytes(taiOfDeltaChange)...)
+	return modelSendTmpl(false, dst, opTAI_UTCDeltaSet, payload, m.handleTimeResponse)
+}
+
+// SetHostTAIUTCDelta sets the TAI-UTC delta of the host time after a leap second
+func SetHostTAIUTCDelta(delta uint) error {
+	timeLock.Lock()
+	defer timeLock.Unlock()
+	hostTaiUtc = int(delta)
+	return nil
+}
+
+func (t *timeAuthority) sync() {
+	err := TimeSetHost(t.dst)
+	timeLock.Lock()
+	t.err = err
+	if err == nil {
+		t.lastSync = hostClock()
+	}
+	timeLock.Unlock()
+	if err != nil {
+		loggerTimeCli.Warnf("time of %04x not set: %s", t.dst, err)
+	}
+}
+
+func (t *timeAuthority) run() {
+	defer close(t.done)
+	t.sync()
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.sync()
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// StartTimeAuthority makes the node the Time Authority of the mesh and sets its
+// time to the host time every interval seconds
+func StartTimeAuthority(dst, interval uint) error {
+	if interval == 0 {
+		return errors.WrongTimeSetting.New().AddContext("interval is 0")
+	}
+	timeLock.Lock()
+	running := timeAuth != nil
+	timeLock.Unlock()
+	if running {
+		return errors.WrongTimeSetting.New().AddContext("time authority is already running")
+	}
+	if err := TimeRoleSet(dst, TIME_ROLE_AUTHORITY); err != nil {
+		return err
+	}
+	t := &timeAuthority{
+		dst:      dst,
+		interval: time.Duration(interval) * time.Second,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	timeLock.Lock()
+	timeAuth = t
+	timeLock.Unlock()
+	go t.run()
+	return nil
+}
+
+// StopTimeAuthority stops setting the time of the Time Authority, its time role is kept
+func StopTimeAuthority() error {
+	timeLock.Lock()
+	t := timeAuth
+	timeLock.Unlock()
+	if t == nil {
+		return nil
+	}
+	close(t.stop)
+	<-t.done
+	timeLock.Lock()
+	timeAuth = nil
+	timeLock.Unlock()
+	return nil
+}
+
+func GetTimeAuthorityStatus() TimeAuthorityStatus {
+	timeLock.Lock()
+	defer timeLock.Unlock()
+	if timeAuth == nil {
+		return TimeAuthorityStatus{}
+	}
+	status := TimeAuthorityStatus{
+		Running:  true,
+		Node:     timeAuth.dst,
+		Interval: timeAuth.interval,
+		LastSync: timeAuth.lastSync,
+	}
+	if timeAuth.err != nil {
+		status.Error = timeAuth.err.Error()
+	}
+	return status
+}
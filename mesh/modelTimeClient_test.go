@@ -0,0 +1,48 @@
+package mesh
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_timeToTai(t *testing.T) {
+	// the TAI epoch was 32 seconds before 2000-01-01 UTC
+	seconds, subsecond := timeToTai(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), 32)
+	assert.Equal(t, uint(32), seconds)
+	assert.Equal(t, uint(0), subsecond)
+
+	utc := time.Date(2026, 10, 18, 12, 30, 15, int(time.Second/2), time.UTC)
+	seconds, subsecond = timeToTai(utc, 37)
+	assert.Equal(t, uint(0x80), subsecond)
+	assert.Equal(t, utc, taiToTime(seconds, subsecond, 37))
+}
+
+func Test_encodeTime(t *testing.T) {
+	state := TimeState{
+		TAISeconds:     0x0102030405,
+		Subsecond:      0x80,
+		Uncertainty:    10,
+		TimeAuthority:  true,
+		TAIUTCDelta:    37,
+		TimeZoneOffset: -20,
+	}
+	data := encodeTime(state)
+	assert.Equal(t, []byte{0x05, 0x04, 0x03, 0x02, 0x01, 0x80, 0x0a, 0x49, 0x02, 0x2c}, data)
+
+	parsed := TimeState{}
+	parseTimeStatus(data, &parsed)
+	state.Time = taiToTime(state.TAISeconds, state.Subsecond, 37)
+	assert.Equal(t, state, parsed)
+
+	// the time is not known
+	parseTimeStatus([]byte{0x00, 0x00, 0x00, 0x00, 0x00}, &parsed)
+	assert.Equal(t, uint(0), parsed.TAISeconds)
+	assert.True(t, parsed.Time.IsZero())
+
+	parseTAIUTCDeltaStatus([]byte{0x24, 0x01, 0x25, 0x01, 0x05, 0x04, 0x03, 0x02, 0x01}, &parsed)
+	assert.Equal(t, 37, parsed.TAIUTCDelta)
+	assert.Equal(t, 38, parsed.TAIUTCDeltaNew)
+	assert.Equal(t, uint(0x0102030405), parsed.TAIOfDeltaChange)
+}
@@ -19,7 +19,15 @@ type NetworkMessage struct {
 	plain   []byte
 	ivIndex uint
 	netKey  *NetKey
+	// the key which decrypted the pdu, nil for the friendship credentials
+	rxKey   *NetKey
 	ackFunc onAckReceived
+	// proxy configuration messages are encrypted with the proxy nonce
+	proxyConfig bool
+	// the access message the segment belongs to, it is encrypted once SEQ
+	// is assigned since SEQ is part of the nonce
+	upper *upperTpPdu
+	segO  int
 }
 
 const cacheSize = 50
@@ -49,7 +57,19 @@ func netRxProc() {
 		if netMsg == nil || netMsg.src == meshDb.UnicastAddress {
 			continue
 		}
-		if netMsg.dst == meshDb.UnicastAddress || isVirtualAddr(netMsg.dst) || isGroupAddr(netMsg.dst) {
+		relay(netMsg)
+		friendRelay(netMsg)
+		if netMsg.dst == meshDb.UnicastAddress || isVirtualAddr(netMsg.dst) || isGroupAddr(netMsg.dst) ||
+			(netMsg.dst == FRIENDS_ADDRESS && meshDb.Friend == FRIEND_ENABLED) {
+			seqAuth, segmented := netMsg.seqAuth()
+			if err := rplCheck(netMsg.src, seqAuth, netMsg.ivIndex, segmented); err != nil {
+				loggerNet.Debug(err)
+				continue
+			}
+			writeRplToDb()
+			if node, _ := findNodeByAddr(netMsg.src); node != nil {
+				node.SequenceNumber = netMsg.seq
+			}
 			transportReceive(netMsg)
 		}
 	}
@@ -64,6 +84,15 @@ func stopNet() {
 	close(netRxChan)
 }
 
+// seqAuth returns the SeqAuth of the segmented message, the SEQ otherwise
+func (msg *NetworkMessage) seqAuth() (uint, bool) {
+	if len(msg.plain) < 4 || msg.plain[0]&0x80 == 0 {
+		return msg.seq, false
+	}
+	seqZero := (uint(msg.plain[1])&0x7F)<<6 | uint(msg.plain[2])>>2
+	return seqAuth(msg.seq, seqZero), true
+}
+
 func networkUnpack(netPdu []byte) (out *NetworkMessage, err error) {
 	defer func() {
 		if out != nil && out.netKey.NewKey != nil {
@@ -82,96 +111,127 @@ func networkUnpack(netPdu []byte) (out *NetworkMessage, err error) {
 	}
 	cache.PushBack(netPdu)
 
-	msg := NetworkMessage{}
-	err = utils.UnpackBE(netPdu[:1], "1, 7", &msg.ivi, &msg.nid)
+	return networkDecryptByNid(netPdu, NetworkMessage{})
+}
+
+// networkDecryptByNid decrypts the pdu with the net keys matching its nid
+func networkDecryptByNid(netPdu []byte, msg NetworkMessage) (*NetworkMessage, error) {
+	err := utils.UnpackBE(netPdu[:1], "1, 7", &msg.ivi, &msg.nid)
 	if err != nil {
 		return nil, err
 	}
 	netKeys := findNetKeyByNid(msg.nid)
-	if len(netKeys) == 0 {
-		return nil, errors.NetKeyNotFoundByNid.New().AddContext(msg.nid)
-	}
+	friends := friendshipsByNid(msg.nid)
 	for _, netKey := range netKeys {
-		obfuscated := netPdu[1:7]
-		// When this state is active, a node shall transmit using the current IV Index
-		// and shall process messages from the current IV Index and also the current IV Index - 1.
-		msg.ivIndex = meshDb.IVindex
-		if (msg.ivIndex & 0x01) != msg.ivi {
-			msg.ivIndex--
-		}
-		privacyPlain, err := utils.PackBE("040, 32, B8", msg.ivIndex, netPdu[7:14])
-		if err != nil {
-			loggerNet.Error(err)
-			continue
-		}
-		pecb, err := crypto.AES_ECB(netKey.PrivacyKey, privacyPlain)
-		if err != nil {
-			loggerNet.Error(err)
-			continue
-		}
-		deobfuscated := make([]byte, len(obfuscated), len(obfuscated))
-		for i, d := range obfuscated {
-			deobfuscated[i] = d ^ pecb[i]
+		// during key refresh, the message may be encrypted with the old key
+		for _, key := range netKey.rxKeys() {
+			if key.Nid != msg.nid {
+				continue
+			}
+			m, err := networkDecrypt(netPdu, msg, key)
+			if err != nil {
+				loggerNet.Error(err)
+				continue
+			}
+			m.netKey, m.rxKey = netKey, key
+			loggerNet.Debugf("NET Rx: %+#v", *m)
+			return m, nil
 		}
-		err = utils.UnpackBE(deobfuscated, "1, 7, 24, 16", &msg.ctl, &msg.ttl, &msg.seq, &msg.src)
-		if err != nil {
-			loggerNet.Error(err)
-			continue
-		}
-		netMicLen := 4
-		if msg.ctl == 1 {
-			netMicLen = 8
-		}
-		// netMic, encrypted := netPdu[-netMicLen:], netPdu[7:-netMicLen]
-		nonce, err := genNetworkNonce(msg.src, msg.seq, msg.ivIndex, msg.ctl, msg.ttl)
-		if err != nil {
-			loggerNet.Error(err)
-			continue
-		}
-		plainNet, err := crypto.AES_CCM_Decrypt(
-			netKey.EncryptionKey,
-			nonce,
-			netPdu[7:],
-			netMicLen)
-		if plainNet == nil || err != nil {
-			loggerNet.Error(err)
-			continue
-		}
-		err = utils.UnpackBE(plainNet[:2], "16", &msg.dst)
-		if err != nil {
-			loggerNet.Error(err)
-			continue
+	}
+	// low power nodes send with the friendship credentials
+	for _, f := range friends {
+		if m, err := networkDecrypt(netPdu, msg, f.cred); err == nil {
+			m.netKey = f.netKey
+			return m, nil
 		}
-		msg.plain = plainNet[2:]
-		msg.netKey = netKey
-		if node, _ := findNodeByAddr(msg.src); node != nil {
-			node.SequenceNumber = uint(msg.seq)
+	}
+	// the friend sends to us with the friendship credentials when we are a low power node
+	if cred, netKey := lpnCredentialsByNid(msg.nid); cred != nil {
+		if m, err := networkDecrypt(netPdu, msg, cred); err == nil {
+			m.netKey = netKey
+			lpnReceived(m)
+			return m, nil
 		}
-		loggerNet.Debugf("NET Rx: %+#v", msg)
-		return &msg, nil
 	}
-
+	if len(netKeys) == 0 && len(friends) == 0 {
+		return nil, errors.NetKeyNotFoundByNid.New().AddContext(msg.nid)
+	}
 	return nil, errors.NoValidNetKeyForDecryption.New().AddContext(netPdu)
 }
 
-func networkSend(msg *NetworkMessage) error {
-	netPdu, err := networkPack(msg)
+// networkDecrypt deobfuscates and decrypts the network pdu with the key
+func networkDecrypt(netPdu []byte, msg NetworkMessage, key *NetKey) (*NetworkMessage, error) {
+	obfuscated := netPdu[1:7]
+	// When this state is active, a node shall transmit using the current IV Index
+	// and shall process messages from the current IV Index and also the current IV Index - 1.
+	msg.ivIndex = meshDb.IVindex
+	if (msg.ivIndex & 0x01) != msg.ivi {
+		msg.ivIndex--
+	}
+	privacyPlain, err := utils.PackBE("040, 32, B8", msg.ivIndex, netPdu[7:14])
+	if err != nil {
+		return nil, err
+	}
+	pecb, err := crypto.AES_ECB(key.PrivacyKey, privacyPlain)
+	if err != nil {
+		return nil, err
+	}
+	deobfuscated := make([]byte, len(obfuscated), len(obfuscated))
+	for i, d := range obfuscated {
+		deobfuscated[i] = d ^ pecb[i]
+	}
+	err = utils.UnpackBE(deobfuscated, "1, 7, 24, 16", &msg.ctl, &msg.ttl, &msg.seq, &msg.src)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	netMicLen := 4
+	if msg.ctl == 1 {
+		netMicLen = 8
 	}
-	netBear.SendNetPdu(netPdu)
-	return nil
-	// privacy_random = bitstring.pack('pad:40, uintbe:32, bytes:7',
-	// iv_index, network_pdu[:7]).bytes
+	// netMic, encrypted := netPdu[-netMicLen:], netPdu[7:-netMicLen]
+	nonce, err := msg.nonce()
+	if err != nil {
+		return nil, err
+	}
+	plainNet, err := crypto.AES_CCM_Decrypt(
+		key.EncryptionKey,
+		nonce,
+		netPdu[7:],
+		netMicLen)
+	if err != nil {
+		return nil, err
+	}
+	if plainNet == nil {
+		return nil, errors.NoValidNetKeyForDecryption.New().AddContextF("nid:%d", key.Nid)
+	}
+	err = utils.UnpackBE(plainNet[:2], "16", &msg.dst)
+	if err != nil {
+		return nil, err
+	}
+	msg.plain = plainNet[2:]
+	return &msg, nil
+}
+
+func (msg *NetworkMessage) nonce() ([]byte, error) {
+	if msg.proxyConfig {
+		return genProxyNonce(msg.src, msg.seq, msg.ivIndex)
+	}
+	return genNetworkNonce(msg.src, msg.seq, msg.ivIndex, msg.ctl, msg.ttl)
 }
 
 func networkPack(msg *NetworkMessage) ([]byte, error) {
+	// the old key is still used to transmit in phase 1 of key refresh
+	return networkPackWithKey(msg, msg.netKey.txKey())
+}
+
+// networkPackWithKey encrypts the message with the key, e.g. a relayed pdu
+// with the key it was received with
+func networkPackWithKey(msg *NetworkMessage, key *NetKey) ([]byte, error) {
 	micSize := 4
 	if msg.ctl == 1 {
 		micSize = 8
 	}
-	key := msg.netKey
-	nonce, err := genNetworkNonce(msg.src, msg.seq, msg.ivIndex, msg.ctl, msg.ttl)
+	nonce, err := msg.nonce()
 	if err != nil {
 		return nil, err
 	}
@@ -187,7 +247,7 @@ func networkPack(msg *NetworkMessage) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	privacyRandom, err := utils.PackBE("040,32,B8", meshDb.IVindex, append(cipher, netMic...)[:7])
+	privacyRandom, err := utils.PackBE("040,32,B8", msg.ivIndex, append(cipher, netMic...)[:7])
 	if err != nil {
 		return nil, err
 	}
@@ -201,7 +261,7 @@ func networkPack(msg *NetworkMessage) ([]byte, error) {
 	for i, d := range preObfuscated {
 		obfuscated[i] = d ^ pecb[i]
 	}
-	netPdu, err := utils.PackBE("1,7,B8,B8,B8", meshDb.IVindex&0x01, key.Nid, obfuscated, cipher, netMic)
+	netPdu, err := utils.PackBE("1,7,B8,B8,B8", msg.ivIndex&0x01, key.Nid, obfuscated, cipher, netMic)
 	loggerNet.Debugf("net message to sent:%+#v", msg)
 	return netPdu, err
 }
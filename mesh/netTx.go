@@ -0,0 +1,241 @@
+package mesh
+
+import (
+	"ble-mesh/utils/errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type (
+	// netTxItem is a network pdu being transmitted, remaining transmissions are left
+	netTxItem struct {
+		msg       *NetworkMessage
+		pdu       []byte
+		remaining uint
+		next      time.Time
+	}
+
+	// netTxScheduler sends the network pdus originating from us. The messages to a
+	// destination are sent in order, otherwise its replay protection would drop
+	// the ones with a lower SEQ. Segment acks are sent before the messages to
+	// other destinations, messages to the same destination are paced.
+	netTxScheduler struct {
+		// pending messages by destination, dsts in round robin order
+		queues map[uint][]*NetworkMessage
+		dsts   []uint
+		// retransmissions of the network transmit state
+		repeats []*netTxItem
+		// last transmission to the destination
+		lastDst map[uint]time.Time
+		nextTx  time.Time
+	}
+)
+
+const (
+	// minimum gap between two transmissions, a random jitter is added
+	netTxMinGap    = 10 * time.Millisecond
+	netTxMaxJitter = 10 * time.Millisecond
+	// minimum gap between two messages to the same destination
+	netTxDstInterval = 20 * time.Millisecond
+)
+
+var seqLock sync.Mutex
+
+// allocSeq returns the SEQ of the next pdu originating from us, it is only
+// called by the scheduler so the SEQs go on the air in increasing order
+func allocSeq() uint {
+	seqLock.Lock()
+	defer seqLock.Unlock()
+	seq := meshDb.SequenceNumber
+	meshDb.SequenceNumber++
+	return seq
+}
+
+func txSeq() uint {
+	seqLock.Lock()
+	defer seqLock.Unlock()
+	return meshDb.SequenceNumber
+}
+
+// SetNetworkTransmit sets the network transmit state of the host node, every pdu is sent
+// count + 1 times with an interval of (intervalSteps + 1) * 10 ms
+func SetNetworkTransmit(count, intervalSteps uint) error {
+	if count > 0x07 || intervalSteps > 0x1f {
+		return errors.WrongNetworkTransmitSetting.New().AddContextF("count:%d, steps:%d", count, intervalSteps)
+	}
+	meshDb.NetworkTransmitCount = count
+	meshDb.NetworkTransmitIntervalSteps = intervalSteps
+	writeMeshToDb()
+	return nil
+}
+
+func newNetTxScheduler() *netTxScheduler {
+	return &netTxScheduler{queues: map[uint][]*NetworkMessage{}, lastDst: map[uint]time.Time{}}
+}
+
+func netTxJitter() time.Duration {
+	return time.Duration(rand.Int63n(int64(netTxMaxJitter)))
+}
+
+func isSegAck(msg *NetworkMessage) bool {
+	return msg.ctl == 1 && !msg.proxyConfig && len(msg.plain) > 0 && msg.plain[0] == 0x00
+}
+
+// transmissions returns the number of transmissions of the message, the
+// gatt link to a proxy is reliable
+func transmissions(msg *NetworkMessage) uint {
+	if _, ok := netBear.(*GattProxyBear); ok || msg.proxyConfig {
+		return 1
+	}
+	return meshDb.NetworkTransmitCount + 1
+}
+
+func (s *netTxScheduler) enqueue(msg *NetworkMessage) {
+	if len(s.queues[msg.dst]) == 0 {
+		s.dsts = append(s.dsts, msg.dst)
+	}
+	s.queues[msg.dst] = append(s.queues[msg.dst], msg)
+}
+
+// pop removes the head message of the destination, the destination moves to
+// the end of the round robin
+func (s *netTxScheduler) pop(i int) *NetworkMessage {
+	dst := s.dsts[i]
+	msg := s.queues[dst][0]
+	s.queues[dst] = s.queues[dst][1:]
+	s.dsts = append(s.dsts[:i], s.dsts[i+1:]...)
+	if len(s.queues[dst]) > 0 {
+		s.dsts = append(s.dsts, dst)
+	} else {
+		delete(s.queues, dst)
+	}
+	return msg
+}
+
+// ready reports whether the head message of the destination can be sent now
+func (s *netTxScheduler) ready(dst uint, now time.Time) bool {
+	return isSegAck(s.queues[dst][0]) || now.Sub(s.lastDst[dst]) >= netTxDstInterval
+}
+
+// start packs a new message. SEQ is assigned at the first transmission, the
+// access messages are encrypted then with the SEQ of their first pdu as SeqAuth.
+func (s *netTxScheduler) start(msg *NetworkMessage, now time.Time) *netTxItem {
+	msg.seq = allocSeq()
+	if msg.upper != nil {
+		plain, err := msg.upper.lowerPdu(msg.segO, msg.seq)
+		if err != nil {
+			loggerNet.Error(err)
+			return nil
+		}
+		msg.plain = plain
+	}
+	// as a low power node we send with the friendship credentials
+	if cred := lpnTxCredentials(msg); cred != nil {
+		msg.netKey = cred
+	}
+	// the messages to low power nodes wait in the friend queue for their poll
+	if msg.ctl == 0 && friendQueue(msg) {
+		return nil
+	}
+	pdu, err := networkPack(msg)
+	if err != nil {
+		loggerNet.Error(err)
+		return nil
+	}
+	s.lastDst[msg.dst] = now
+	return &netTxItem{msg: msg, pdu: pdu, remaining: transmissions(msg)}
+}
+
+// next returns the item to transmit now: a due retransmission, a segment ack,
+// then the message of the next destination which is not paced
+func (s *netTxScheduler) next(now time.Time) *netTxItem {
+	for i, item := range s.repeats {
+		if !item.next.After(now) {
+			s.repeats = append(s.repeats[:i], s.repeats[i+1:]...)
+			return item
+		}
+	}
+	for _, acksOnly := range []bool{true, false} {
+		for i := 0; i < len(s.dsts); i++ {
+			dst := s.dsts[i]
+			if (acksOnly && !isSegAck(s.queues[dst][0])) || !s.ready(dst, now) {
+				continue
+			}
+			if item := s.start(s.pop(i), now); item != nil {
+				return item
+			}
+			i--
+		}
+	}
+	return nil
+}
+
+// earliest returns the time of the next pending transmission
+func (s *netTxScheduler) earliest(now time.Time) (time.Time, bool) {
+	var t time.Time
+	found := false
+	update := func(c time.Time) {
+		if !found || c.Before(t) {
+			t, found = c, true
+		}
+	}
+	for _, item := range s.repeats {
+		update(item.next)
+	}
+	for _, dst := range s.dsts {
+		if s.ready(dst, now) {
+			update(now)
+		} else {
+			update(s.lastDst[dst].Add(netTxDstInterval))
+		}
+	}
+	return t, found
+}
+
+func (s *netTxScheduler) transmit(item *netTxItem, now time.Time) {
+	if item.msg.proxyConfig {
+		if err := proxyConfigSendPdu(item.pdu); err != nil {
+			loggerNet.Error(err)
+		}
+	} else {
+		netBear.SendNetPdu(item.pdu)
+	}
+	item.remaining--
+	if item.remaining > 0 {
+		interval := time.Duration(meshDb.NetworkTransmitIntervalSteps+1) * 10 * time.Millisecond
+		item.next = now.Add(interval + netTxJitter())
+		s.repeats = append(s.repeats, item)
+	}
+	s.nextTx = now.Add(netTxMinGap + netTxJitter())
+}
+
+// run transmits at most one pdu, it returns when it should run again
+func (s *netTxScheduler) run(now time.Time) (time.Time, bool) {
+	if now.Before(s.nextTx) {
+		return s.nextTx, true
+	}
+	if item := s.next(now); item != nil {
+		s.transmit(item, now)
+		return s.nextTx, true
+	}
+	return s.earliest(now)
+}
+
+func netTxProc() {
+	s := newNetTxScheduler()
+	for {
+		var timeout <-chan time.Time
+		if t, ok := s.run(time.Now()); ok {
+			timeout = time.After(time.Until(t))
+		}
+		select {
+		case msg, more := <-tpTxChan:
+			if !more {
+				return
+			}
+			s.enqueue(msg)
+		case <-timeout:
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package mesh
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_netTxScheduler(t *testing.T) {
+	netKey := createNetKey(0)
+	meshDb = &Mesh{NetKeys: map[uint]*NetKey{0: netKey}, UnicastAddress: 0x0001, NetworkTransmitCount: 1}
+	bear := &AdvertisingBear{}
+	bear.Start()
+	sent := 0
+	bear.SetWriteHandle(func([]byte) error {
+		sent++
+		return nil
+	})
+	netBear = bear
+
+	s := newNetTxScheduler()
+	a := &NetworkMessage{dst: 0x0100, plain: []byte{0x01}, netKey: netKey}
+	b := &NetworkMessage{dst: 0x0100, plain: []byte{0x02}, netKey: netKey}
+	ack := &NetworkMessage{ctl: 1, dst: 0x0200, plain: []byte{0x00, 0x00, 0x00}, netKey: netKey}
+	s.enqueue(a)
+	s.enqueue(b)
+	s.enqueue(ack)
+
+	now := time.Now()
+	// the segment ack goes first, then the messages to 0x0100 in order and paced
+	item := s.next(now)
+	assert.Equal(t, ack, item.msg)
+	s.transmit(item, now)
+	item = s.next(now)
+	assert.Equal(t, a, item.msg)
+	s.transmit(item, now)
+	assert.Nil(t, s.next(now))
+
+	// the retransmissions are due before b
+	now = now.Add(netTxDstInterval)
+	assert.Equal(t, ack, s.next(now).msg)
+	assert.Equal(t, a, s.next(now).msg)
+	item = s.next(now)
+	assert.Equal(t, b, item.msg)
+	assert.Equal(t, []uint{0, 1, 2}, []uint{ack.seq, a.seq, b.seq})
+	assert.Equal(t, 2, sent)
+}
+
+func Test_netTxSeq(t *testing.T) {
+	netKey := createNetKey(0)
+	appKey := createAppKey(0)
+	meshDb = &Mesh{NetKeys: map[uint]*NetKey{0: netKey}, AppKeys: map[uint]*AppKey{0: appKey}, UnicastAddress: 0x0001}
+	sent := make(chan []byte, 64)
+	bear := &AdvertisingBear{}
+	bear.Start()
+	bear.SetWriteHandle(func(packet []byte) error {
+		sent <- packet[2:]
+		return nil
+	})
+	netBear = bear
+	tpTxChan = make(chan *NetworkMessage, 10)
+	segMsgLock.Lock()
+	if segMsgTimeouts == nil {
+		segMsgTimeouts = map[*NetworkMessage]*AckTimeout{}
+	}
+	segMsgLock.Unlock()
+	stopped := make(chan bool)
+	go func() {
+		netTxProc()
+		close(stopped)
+	}()
+
+	// two senders of segmented and unsegmented messages to two destinations
+	const messages = 4
+	done := make(chan bool)
+	for _, dst := range []uint{0x0100, 0x0200} {
+		go func(dst uint) {
+			for i := 0; i < messages; i++ {
+				assert.Nil(t, tpSendAccessMsg(1, appKey, netKey, []byte{0x82, 0x01}, dst, 5, nil))
+				assert.Nil(t, tpSendAccessMsg(1, appKey, netKey, make([]byte, 16), dst, 5, func(*SegmentAckMessage) {}))
+			}
+			done <- true
+		}(dst)
+	}
+	<-done
+	<-done
+
+	// 1 unsegmented and 2 segments per iteration
+	lastSeq := -1
+	for i := 0; i < 2*messages*3; i++ {
+		select {
+		case pdu := <-sent:
+			rx, err := networkDecryptByNid(pdu, NetworkMessage{})
+			assert.Nil(t, err)
+			assert.True(t, int(rx.seq) > lastSeq, "SEQ %06x sent after %06x", rx.seq, lastSeq)
+			lastSeq = int(rx.seq)
+			// the first segment carries SeqAuth
+			if rx.plain[0]&0x80 != 0 && rx.plain[3]>>2&0x1f == 0 {
+				seqZero := (uint(rx.plain[1])&0x7f)<<6 | uint(rx.plain[2])>>2
+				assert.Equal(t, rx.seq&0x1fff, seqZero)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("pdu not sent")
+		}
+	}
+	close(tpTxChan)
+	<-stopped
+	segMsgLock.Lock()
+	for m := range segMsgTimeouts {
+		delete(segMsgTimeouts, m)
+	}
+	segMsgLock.Unlock()
+}
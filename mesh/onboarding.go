@@ -0,0 +1,319 @@
+package mesh
+
+import (
+	"ble-mesh/utils"
+	"ble-mesh/utils/errors"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+
+	"gopkg.in/yaml.v2"
+)
+
+type (
+	// OnboardingAppKey is added to the node and bound to the listed models,
+	// model ids are in hex like in the node database, e.g. "1000" or "00590001"
+	OnboardingAppKey struct {
+		NetKeyIndex uint     `json:"netKeyIndex" yaml:"netKeyIndex"`
+		AppKeyIndex uint     `json:"appKeyIndex" yaml:"appKeyIndex"`
+		Models      []string `json:"models" yaml:"models"`
+		modelIds    []uint
+	}
+
+	OnboardingSubscription struct {
+		Model     string   `json:"model" yaml:"model"`
+		Addresses []string `json:"addresses" yaml:"addresses"`
+		modelId   uint
+		addrs     []uint
+	}
+
+	OnboardingPublication struct {
+		Model                   string `json:"model" yaml:"model"`
+		Address                 string `json:"address" yaml:"address"`
+		AppKeyIndex             uint   `json:"appKeyIndex" yaml:"appKeyIndex"`
+		CredentialFlag          uint   `json:"credentialFlag" yaml:"credentialFlag"`
+		TTL                     uint   `json:"ttl" yaml:"ttl"`
+		PeriodSteps             uint   `json:"periodSteps" yaml:"periodSteps"`
+		PeriodResolution        uint   `json:"periodResolution" yaml:"periodResolution"`
+		RetransmitCount         uint   `json:"retransmitCount" yaml:"retransmitCount"`
+		RetransmitIntervalSteps uint   `json:"retransmitIntervalSteps" yaml:"retransmitIntervalSteps"`
+		modelId                 uint
+		addr                    uint
+	}
+
+	OnboardingRelay struct {
+		Relay                   uint `json:"relay" yaml:"relay"`
+		RetransmitCount         uint `json:"retransmitCount" yaml:"retransmitCount"`
+		RetransmitIntervalSteps uint `json:"retransmitIntervalSteps" yaml:"retransmitIntervalSteps"`
+	}
+
+	// OnboardingProfile is the configuration applied to a node once it is provisioned.
+	// The node states left nil are not touched.
+	OnboardingProfile struct {
+		Name          string                   `json:"name" yaml:"name"`
+		AppKeys       []OnboardingAppKey       `json:"appKeys" yaml:"appKeys"`
+		Subscriptions []OnboardingSubscription `json:"subscriptions" yaml:"subscriptions"`
+		Publications  []OnboardingPublication  `json:"publications" yaml:"publications"`
+		DefaultTTL    *uint                    `json:"defaultTTL" yaml:"defaultTTL"`
+		Relay         *OnboardingRelay         `json:"relay" yaml:"relay"`
+		Proxy         *uint                    `json:"proxy" yaml:"proxy"`
+		Friend        *uint                    `json:"friend" yaml:"friend"`
+	}
+
+	OnboardingStep struct {
+		Name string
+		Err  error
+	}
+
+	OnboardingReport struct {
+		Address uint
+		Profile string
+		Steps   []OnboardingStep
+	}
+)
+
+var loggerOnboarding = utils.CreateLogger("Onboarding")
+
+// LoadOnboardingProfile reads the profile in YAML if the file ends with .yaml
+// or .yml, in JSON otherwise
+func LoadOnboardingProfile(path string) (*OnboardingProfile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseOnboardingProfile(filepath.Ext(path), data)
+}
+
+func parseOnboardingProfile(ext string, data []byte) (*OnboardingProfile, error) {
+	profile := &OnboardingProfile{}
+	unmarshal := json.Unmarshal
+	if ext == ".yaml" || ext == ".yml" {
+		unmarshal = yaml.Unmarshal
+	}
+	if err := unmarshal(data, profile); err != nil {
+		return nil, err
+	}
+	if err := profile.validate(); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// parseHexField parses the hex value of the field, e.g. "subscriptions[0].model"
+func parseHexField(field, s string, max uint) (uint, error) {
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return 0, errors.InvalidOnboardingProfile.New().AddContextF("%s: %q is not a hex number", field, s)
+	}
+	return uint(v), checkRange(field, uint(v), max)
+}
+
+func checkRange(field string, v, max uint) error {
+	if v > max {
+		return errors.InvalidOnboardingProfile.New().AddContextF("%s: %#x is greater than %#x", field, v, max)
+	}
+	return nil
+}
+
+// validate parses the hex fields of the profile and checks the ranges of the
+// states, the error names the first invalid field
+func (p *OnboardingProfile) validate() error {
+	var err error
+	for i := range p.AppKeys {
+		k := &p.AppKeys[i]
+		field := fmt.Sprintf("appKeys[%d]", i)
+		if err = checkRange(field+".netKeyIndex", k.NetKeyIndex, 0xFFF); err != nil {
+			return err
+		}
+		if err = checkRange(field+".appKeyIndex", k.AppKeyIndex, 0xFFF); err != nil {
+			return err
+		}
+		k.modelIds = make([]uint, len(k.Models))
+		for j, m := range k.Models {
+			if k.modelIds[j], err = parseHexField(fmt.Sprintf("%s.models[%d]", field, j), m, 0xFFFFFFFF); err != nil {
+				return err
+			}
+		}
+	}
+	for i := range p.Subscriptions {
+		s := &p.Subscriptions[i]
+		field := fmt.Sprintf("subscriptions[%d]", i)
+		if s.modelId, err = parseHexField(field+".model", s.Model, 0xFFFFFFFF); err != nil {
+			return err
+		}
+		s.addrs = make([]uint, len(s.Addresses))
+		for j, a := range s.Addresses {
+			addrField := fmt.Sprintf("%s.addresses[%d]", field, j)
+			if s.addrs[j], err = parseHexField(addrField, a, 0xFFFF); err != nil {
+				return err
+			}
+			// only group and virtual addresses can be subscribed, except all nodes
+			if s.addrs[j] < VIRTUAL_ADDRESS_LOW || s.addrs[j] == ALL_NODES_ADDRESS {
+				return errors.InvalidOnboardingProfile.New().AddContextF("%s: %s is not a group or virtual address", addrField, a)
+			}
+		}
+	}
+	for i := range p.Publications {
+		pub := &p.Publications[i]
+		field := fmt.Sprintf("publications[%d]", i)
+		if pub.modelId, err = parseHexField(field+".model", pub.Model, 0xFFFFFFFF); err != nil {
+			return err
+		}
+		if pub.addr, err = parseHexField(field+".address", pub.Address, 0xFFFF); err != nil {
+			return err
+		}
+		// 0xFF means the default TTL of the node
+		if pub.TTL != 0xFF {
+			err = checkRange(field+".ttl", pub.TTL, 0x7F)
+		}
+		for _, e := range []error{
+			err,
+			checkRange(field+".appKeyIndex", pub.AppKeyIndex, 0xFFF),
+			checkRange(field+".credentialFlag", pub.CredentialFlag, 1),
+			checkRange(field+".periodSteps", pub.PeriodSteps, 0x3F),
+			checkRange(field+".periodResolution", pub.PeriodResolution, 3),
+			checkRange(field+".retransmitCount", pub.RetransmitCount, 7),
+			checkRange(field+".retransmitIntervalSteps", pub.RetransmitIntervalSteps, 0x1F),
+		} {
+			if e != nil {
+				return e
+			}
+		}
+	}
+	if p.DefaultTTL != nil {
+		if *p.DefaultTTL == 1 {
+			return errors.InvalidOnboardingProfile.New().AddContext("defaultTTL: 1 is prohibited")
+		}
+		if err = checkRange("defaultTTL", *p.DefaultTTL, 0x7F); err != nil {
+			return err
+		}
+	}
+	if r := p.Relay; r != nil {
+		for _, e := range []error{
+			checkRange("relay.relay", r.Relay, 1),
+			checkRange("relay.retransmitCount", r.RetransmitCount, 7),
+			checkRange("relay.retransmitIntervalSteps", r.RetransmitIntervalSteps, 0x1F),
+		} {
+			if e != nil {
+				return e
+			}
+		}
+	}
+	if p.Proxy != nil {
+		if err = checkRange("proxy", *p.Proxy, 1); err != nil {
+			return err
+		}
+	}
+	if p.Friend != nil {
+		if err = checkRange("friend", *p.Friend, 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Failed returns the steps which did not succeed
+func (r *OnboardingReport) Failed() []OnboardingStep {
+	failed := []OnboardingStep{}
+	for _, s := range r.Steps {
+		if s.Err != nil {
+			failed = append(failed, s)
+		}
+	}
+	return failed
+}
+
+func (r *OnboardingReport) Succeeded() bool {
+	return len(r.Failed()) == 0
+}
+
+func (r *OnboardingReport) step(err error, format string, args ...interface{}) error {
+	name := fmt.Sprintf(format, args...)
+	// the state is already there, e.g. onboarding is run twice
+	if errors.AppKeyAlreadyBindedToNode.Is(err) || errors.AppKeyAlreadyBindedToModel.Is(err) ||
+		errors.AddressAlreadyInSubscriptionList.Is(err) {
+		err = nil
+	}
+	if err != nil {
+		loggerOnboarding.Errorf("%04x: %s failed, error: %s", r.Address, name, err)
+	} else {
+		loggerOnboarding.Infof("%04x: %s", r.Address, name)
+	}
+	r.Steps = append(r.Steps, OnboardingStep{Name: name, Err: err})
+	return err
+}
+
+// modelElements returns the addresses of the elements of the node which contain the model
+func modelElements(node *Node, id uint) []uint {
+	addrs := []uint{}
+	for _, ele := range node.Elements {
+		if _, err := ele.findModel(id); err == nil {
+			addrs = append(addrs, ele.UnicastAddress)
+		}
+	}
+	return addrs
+}
+
+// OnboardNode fetches the composition data of the node and applies the profile.
+// A failed step doesn't stop the others, except those depending on it,
+// the result of every step is in the report.
+func OnboardNode(addr uint, profile *OnboardingProfile) *OnboardingReport {
+	report := &OnboardingReport{Address: addr, Profile: profile.Name}
+	// the profile may not come from LoadOnboardingProfile
+	if report.step(profile.validate(), "validate profile") != nil {
+		return report
+	}
+	if report.step(ConfigCompositionDataGet(addr, 0), "get composition data") != nil {
+		return report
+	}
+	node, err := findNodeByAddr(addr)
+	if report.step(err, "find node") != nil {
+		return report
+	}
+
+	for _, k := range profile.AppKeys {
+		if report.step(ConfigAppKeyAdd(addr, k.NetKeyIndex, k.AppKeyIndex),
+			"add app key %d of net key %d", k.AppKeyIndex, k.NetKeyIndex) != nil {
+			continue
+		}
+		for i, model := range k.Models {
+			for _, ele := range modelElements(node, k.modelIds[i]) {
+				report.step(ConfigModelAppBind(ele, k.AppKeyIndex, k.modelIds[i]),
+					"bind app key %d to model %s of element %04x", k.AppKeyIndex, model, ele)
+			}
+		}
+	}
+
+	for _, s := range profile.Subscriptions {
+		for _, ele := range modelElements(node, s.modelId) {
+			for i, a := range s.Addresses {
+				report.step(ConfigModelSubscriptionAdd(ele, s.addrs[i], s.modelId),
+					"subscribe model %s of element %04x to %s", s.Model, ele, a)
+			}
+		}
+	}
+
+	for _, p := range profile.Publications {
+		for _, ele := range modelElements(node, p.modelId) {
+			report.step(ConfigModelPublicationSet(ele, p.addr, p.AppKeyIndex, p.CredentialFlag, p.TTL,
+				p.PeriodSteps, p.PeriodResolution, p.RetransmitCount, p.RetransmitIntervalSteps, p.modelId),
+				"set publication of model %s of element %04x to %s", p.Model, ele, p.Address)
+		}
+	}
+
+	if profile.DefaultTTL != nil {
+		report.step(ConfigDefaultTTLSet(addr, *profile.DefaultTTL), "set default ttl to %d", *profile.DefaultTTL)
+	}
+	if r := profile.Relay; r != nil {
+		report.step(ConfigRelaySet(addr, r.Relay, r.RetransmitCount, r.RetransmitIntervalSteps), "set relay to %d", r.Relay)
+	}
+	if profile.Proxy != nil {
+		report.step(ConfigGattProxySet(addr, *profile.Proxy), "set gatt proxy to %d", *profile.Proxy)
+	}
+	if profile.Friend != nil {
+		report.step(ConfigFriendSet(addr, *profile.Friend), "set friend to %d", *profile.Friend)
+	}
+	return report
+}
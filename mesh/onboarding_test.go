@@ -0,0 +1,84 @@
+package mesh
+
+import (
+	"ble-mesh/utils/errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_onboardingReport(t *testing.T) {
+	r := &OnboardingReport{Address: 0x0100}
+	assert.Nil(t, r.step(errors.AppKeyAlreadyBindedToNode.New(), "add app key %d", 0))
+	assert.NotNil(t, r.step(errors.Timeout.New(), "set default ttl to %d", 5))
+	assert.Equal(t, 2, len(r.Steps))
+	assert.False(t, r.Succeeded())
+	assert.Equal(t, "set default ttl to 5", r.Failed()[0].Name)
+}
+
+func Test_modelElements(t *testing.T) {
+	node := &Node{}
+	node.Elements = []*Element{
+		{Node: node, UnicastAddress: 0x0100, Models: []*Model{{ModelID: 0x0000}, {ModelID: 0x1000}}},
+		{Node: node, UnicastAddress: 0x0101, Models: []*Model{{ModelID: 0x1000}}},
+	}
+	assert.Equal(t, []uint{0x0100, 0x0101}, modelElements(node, 0x1000))
+	assert.Equal(t, []uint{}, modelElements(node, 0x1300))
+}
+
+func Test_parseOnboardingProfile(t *testing.T) {
+	yamlProfile := `
+name: light
+appKeys:
+  - netKeyIndex: 0
+    appKeyIndex: 1
+    models: ["1000", "1300"]
+subscriptions:
+  - model: "1000"
+    addresses: ["c000"]
+defaultTTL: 5
+relay:
+  relay: 1
+  retransmitCount: 2
+`
+	jsonProfile := `{"name": "light", "appKeys": [{"netKeyIndex": 0, "appKeyIndex": 1, "models": ["1000", "1300"]}],
+		"subscriptions": [{"model": "1000", "addresses": ["c000"]}], "defaultTTL": 5,
+		"relay": {"relay": 1, "retransmitCount": 2}}`
+	fromYaml, err := parseOnboardingProfile(".yaml", []byte(yamlProfile))
+	assert.Nil(t, err)
+	fromJson, err := parseOnboardingProfile(".json", []byte(jsonProfile))
+	assert.Nil(t, err)
+	assert.Equal(t, fromJson, fromYaml)
+	assert.Equal(t, uint(5), *fromYaml.DefaultTTL)
+	assert.Equal(t, []string{"1000", "1300"}, fromYaml.AppKeys[0].Models)
+	assert.Equal(t, []uint{0x1000, 0x1300}, fromYaml.AppKeys[0].modelIds)
+	assert.Equal(t, []uint{0xc000}, fromYaml.Subscriptions[0].addrs)
+	_, err = parseOnboardingProfile(".json", []byte(yamlProfile))
+	assert.NotNil(t, err)
+}
+
+func Test_validateOnboardingProfile(t *testing.T) {
+	tests := []struct {
+		profile string
+		field   string
+	}{
+		{`{"appKeys": [{"appKeyIndex": 1, "models": ["1000", "10zz"]}]}`, "appKeys[0].models[1]"},
+		{`{"appKeys": [{"appKeyIndex": 4096}]}`, "appKeys[0].appKeyIndex"},
+		{`{"subscriptions": [{"model": "1000", "addresses": ["c000", "0100"]}]}`, "subscriptions[0].addresses[1]"},
+		{`{"subscriptions": [{"model": "100000000", "addresses": ["c000"]}]}`, "subscriptions[0].model"},
+		{`{"publications": [{"model": "1000", "address": "10000"}]}`, "publications[0].address"},
+		{`{"publications": [{"model": "1000", "address": "c000", "ttl": 128}]}`, "publications[0].ttl"},
+		{`{"defaultTTL": 1}`, "defaultTTL"},
+		{`{"relay": {"relay": 1, "retransmitCount": 8}}`, "relay.retransmitCount"},
+	}
+	for _, tt := range tests {
+		_, err := parseOnboardingProfile(".json", []byte(tt.profile))
+		if assert.NotNil(t, err, tt.profile) {
+			assert.Contains(t, err.Error(), tt.field)
+			assert.True(t, errors.InvalidOnboardingProfile.Is(err))
+		}
+	}
+	p, err := parseOnboardingProfile(".json", []byte(`{"publications": [{"model": "00590001", "address": "ffff", "ttl": 255}]}`))
+	assert.Nil(t, err)
+	assert.Equal(t, uint(0x00590001), p.Publications[0].modelId)
+}
@@ -1 +1,490 @@
-package mesh
\ No newline at end of file
+package mesh
+
+import (
+	"ble-mesh/utils"
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	PbAdvBear struct {
+		UUID      string
+		linkId    uint32
+		linkAcked chan bool
+		txChan    chan []byte
+		ackChan   chan byte
+		advChan   chan []byte
+		done      chan bool
+		doneOnce  *sync.Once
+		writeCb   func([]byte) error
+		logger    *logrus.Entry
+		writeLock *sync.Mutex
+		// guards opened, closed, pending and drained, they are set by the
+		// receive and transmit paths and read by the senders
+		stateLock *sync.Mutex
+		opened    bool
+		closed    bool
+		// reason sent in Link Close when the bearer is stopped
+		closeReason byte
+		// number of provisioning pdus not acknowledged yet
+		pending int
+		// closed once pending drops to 0, set while waitAcked waits for it
+		drained chan bool
+
+		txNum byte
+		rx    pbAdvTransaction
+		// number of the last transaction delivered to the upper layer
+		rxLastNum int
+	}
+
+	pbAdvTransaction struct {
+		num     int
+		segN    int
+		length  int
+		fcs     byte
+		pdu     []byte
+		segRcvd uint64
+	}
+)
+
+/* Generic Provisioning Control Format */
+const (
+	gpcfTransactionStart = iota
+	gpcfTransactionAck
+	gpcfTransactionContinuation
+	gpcfBearerControl
+)
+
+/* Provisioning Bearer Control opcodes */
+const (
+	pbAdvLinkOpen = iota
+	pbAdvLinkAck
+	pbAdvLinkClose
+)
+
+/* Link Close reasons */
+const (
+	pbAdvCloseSuccess = iota
+	pbAdvCloseTimeout
+	pbAdvCloseFail
+)
+
+const (
+	// Generic Provisioning PDU is at most 24 octets on the advertising bearer
+	pbAdvStartMtu        = 20
+	pbAdvContinuationMtu = 23
+	// device transaction numbers are 0x80-0xFF, so this never collides with an ack number
+	pbAdvImplicitAck = 0xFF
+
+	pbAdvSegmentInterval    = 250 * time.Millisecond
+	pbAdvRetransmitInterval = time.Second
+	pbAdvLinkTimeout        = 60 * time.Second
+	pbAdvTransactionTimeout = 30 * time.Second
+)
+
+var (
+	pbAdvLinks     = map[uint32]*PbAdvBear{}
+	pbAdvLinksLock = new(sync.Mutex)
+	fcsTable       = genFcsTable()
+)
+
+// 3GPP TS 27.010 CRC-8, polynomial x^8 + x^2 + x + 1 (reversed 0xE0)
+func genFcsTable() [256]byte {
+	table := [256]byte{}
+	for i := 0; i < 256; i++ {
+		crc := byte(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 == 1 {
+				crc = (crc >> 1) ^ 0xE0
+			} else {
+				crc >>= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}
+
+func calcFcs(data []byte) byte {
+	fcs := byte(0xFF)
+	for _, d := range data {
+		fcs = fcsTable[fcs^d]
+	}
+	return 0xFF - fcs
+}
+
+// pbAdvReceive is called by the advertising bearer with the payload of a
+// BT_LE_ADV_PROVISION packet: Link ID || Transaction Number || Generic Provisioning PDU
+func pbAdvReceive(pdu []byte) {
+	if len(pdu) < 6 {
+		return
+	}
+	linkId := binary.BigEndian.Uint32(pdu[:4])
+	pbAdvLinksLock.Lock()
+	b, ok := pbAdvLinks[linkId]
+	pbAdvLinksLock.Unlock()
+	if ok {
+		b.OnPduReceived(pdu)
+	}
+}
+
+func (b *PbAdvBear) Start() {
+	b.logger = utils.CreateLogger("pbAdvBear")
+	b.writeLock = new(sync.Mutex)
+	b.stateLock = new(sync.Mutex)
+	b.linkAcked = make(chan bool, 1)
+	b.txChan = make(chan []byte, 8)
+	b.ackChan = make(chan byte, 1)
+	b.advChan = make(chan []byte, 32)
+	b.done = make(chan bool)
+	b.doneOnce = new(sync.Once)
+	b.opened = false
+	b.closed = false
+	b.closeReason = pbAdvCloseSuccess
+	b.pending = 0
+	b.drained = nil
+	b.txNum = 0
+	b.rx = pbAdvTransaction{num: -1}
+	b.rxLastNum = -1
+
+	id := make([]byte, 4)
+	rand.Read(id)
+	b.linkId = binary.BigEndian.Uint32(id)
+	pbAdvLinksLock.Lock()
+	pbAdvLinks[b.linkId] = b
+	pbAdvLinksLock.Unlock()
+
+	go b.rxProc()
+	go b.txProc()
+}
+
+func (b *PbAdvBear) Stop() {
+	// let the last pdus, e.g. Provisioning Failed, reach the device first
+	b.waitAcked(pbAdvRetransmitInterval * 3)
+	b.closeLink(b.closeReason)
+}
+
+// waitAcked waits until the provisioning pdus sent are acknowledged by the
+// device, it returns false if the link is closed or the timeout expires first
+func (b *PbAdvBear) waitAcked(timeout time.Duration) bool {
+	b.stateLock.Lock()
+	if b.pending == 0 {
+		b.stateLock.Unlock()
+		return true
+	}
+	if b.drained == nil {
+		b.drained = make(chan bool)
+	}
+	drained := b.drained
+	b.stateLock.Unlock()
+	select {
+	case <-drained:
+		return true
+	case <-b.done:
+	case <-time.After(timeout):
+	}
+	return false
+}
+
+func (b *PbAdvBear) OnPduReceived(pdu []byte) {
+	select {
+	case b.advChan <- pdu:
+	default:
+		b.logger.Debug("pb-adv rx queue is full, drop packet")
+	}
+}
+
+func (b *PbAdvBear) SetWriteHandle(writeFunc func([]byte) error) {
+	b.writeCb = writeFunc
+}
+
+func (b *PbAdvBear) SetMTU(mtu uint) {
+}
+
+func (b *PbAdvBear) SendNetPdu(pdu []byte) {
+}
+
+func (b *PbAdvBear) SendBeaconPdu(pdu []byte) {
+}
+
+func (b *PbAdvBear) SendProvPdu(pdu []byte) {
+	b.stateLock.Lock()
+	if b.closed {
+		b.stateLock.Unlock()
+		return
+	}
+	b.pending++
+	b.stateLock.Unlock()
+	select {
+	case b.txChan <- pdu:
+	case <-b.done:
+		b.txDone()
+	}
+}
+
+// txDone is called once a provisioning pdu is acknowledged or given up
+func (b *PbAdvBear) txDone() {
+	b.stateLock.Lock()
+	defer b.stateLock.Unlock()
+	b.pending--
+	if b.pending == 0 && b.drained != nil {
+		close(b.drained)
+		b.drained = nil
+	}
+}
+
+func (b *PbAdvBear) send(transNum byte, genPdu []byte) {
+	if b.writeCb == nil {
+		return
+	}
+	pdu := make([]byte, 5)
+	binary.BigEndian.PutUint32(pdu, b.linkId)
+	pdu[4] = transNum
+	pdu = append(pdu, genPdu...)
+	packet := append([]byte{byte(len(pdu) + 1), BT_LE_ADV_PROVISION}, pdu...)
+	b.logger.Debugf("pb-adv tx: % 2x", packet)
+	b.writeLock.Lock()
+	b.writeCb(packet)
+	b.writeLock.Unlock()
+}
+
+func (b *PbAdvBear) segmentation(pdu []byte) [][]byte {
+	list := [][]byte{}
+	chunk := pdu
+	if len(chunk) > pbAdvStartMtu {
+		chunk = pdu[:pbAdvStartMtu]
+	}
+	rest := pdu[len(chunk):]
+	segN := (len(rest) + pbAdvContinuationMtu - 1) / pbAdvContinuationMtu
+	start := []byte{byte(segN<<2 | gpcfTransactionStart), 0, 0, calcFcs(pdu)}
+	binary.BigEndian.PutUint16(start[1:3], uint16(len(pdu)))
+	list = append(list, append(start, chunk...))
+	for i := 1; len(rest) > 0; i++ {
+		chunk = rest
+		if len(chunk) > pbAdvContinuationMtu {
+			chunk = rest[:pbAdvContinuationMtu]
+		}
+		rest = rest[len(chunk):]
+		list = append(list, append([]byte{byte(i<<2 | gpcfTransactionContinuation)}, chunk...))
+	}
+	return list
+}
+
+func (b *PbAdvBear) openLink() bool {
+	devUUID, err := uuid.Parse(b.UUID)
+	if err != nil {
+		b.logger.Errorf("invalid device uuid %s, error:%s", b.UUID, err)
+		return false
+	}
+	b.logger.Infof("opening pb-adv link %08x to %s", b.linkId, b.UUID)
+	linkOpen := append([]byte{pbAdvLinkOpen<<2 | gpcfBearerControl}, devUUID[:]...)
+	timeout := time.After(pbAdvLinkTimeout)
+	for {
+		b.send(0, linkOpen)
+		select {
+		case <-b.linkAcked:
+			b.logger.Infof("pb-adv link %08x established", b.linkId)
+			b.stateLock.Lock()
+			b.opened = true
+			b.stateLock.Unlock()
+			return true
+		case <-time.After(pbAdvRetransmitInterval):
+		case <-timeout:
+			b.logger.Errorf("pb-adv link %08x establishment timeout", b.linkId)
+			return false
+		case <-b.done:
+			return false
+		}
+	}
+}
+
+// unregister marks the link closed, it returns whether the link was opened
+// and whether it was already closed
+func (b *PbAdvBear) unregister() (bool, bool) {
+	pbAdvLinksLock.Lock()
+	delete(pbAdvLinks, b.linkId)
+	pbAdvLinksLock.Unlock()
+	b.stateLock.Lock()
+	opened, closed := b.opened, b.closed
+	b.closed = true
+	b.stateLock.Unlock()
+	b.doneOnce.Do(func() { close(b.done) })
+	return opened, closed
+}
+
+func (b *PbAdvBear) closeLink(reason byte) {
+	opened, closed := b.unregister()
+	if closed {
+		return
+	}
+	if opened {
+		b.logger.Infof("closing pb-adv link %08x, reason:%d", b.linkId, reason)
+		for i := 0; i < 3; i++ {
+			b.send(0, []byte{pbAdvLinkClose<<2 | gpcfBearerControl, reason})
+			time.Sleep(pbAdvSegmentInterval)
+		}
+	}
+}
+
+func (b *PbAdvBear) txProc() {
+	if !b.openLink() {
+		b.closeLink(pbAdvCloseTimeout)
+		return
+	}
+	for {
+		var pdu []byte
+		select {
+		case pdu = <-b.txChan:
+		case <-b.done:
+			return
+		}
+		b.sendTransaction(pdu)
+	}
+}
+
+func (b *PbAdvBear) sendTransaction(pdu []byte) {
+	defer b.txDone()
+	num := b.txNum
+	b.txNum = (b.txNum + 1) & 0x7F
+	segs := b.segmentation(pdu)
+	// drop acks left over from the previous transaction
+	select {
+	case <-b.ackChan:
+	default:
+	}
+	timeout := time.After(pbAdvTransactionTimeout)
+	for {
+		for _, seg := range segs {
+			b.send(num, seg)
+			if len(segs) > 1 {
+				time.Sleep(pbAdvSegmentInterval)
+			}
+		}
+		select {
+		case ackNum := <-b.ackChan:
+			if ackNum == num || ackNum == pbAdvImplicitAck {
+				return
+			}
+		case <-time.After(pbAdvRetransmitInterval):
+			b.logger.Debugf("retransmit transaction %d", num)
+		case <-timeout:
+			b.logger.Errorf("transaction %d timeout", num)
+			b.closeLink(pbAdvCloseTimeout)
+			return
+		case <-b.done:
+			return
+		}
+	}
+}
+
+func (b *PbAdvBear) rxProc() {
+	for {
+		var pdu []byte
+		select {
+		case pdu = <-b.advChan:
+		case <-b.done:
+			return
+		}
+		if len(pdu) < 6 {
+			continue
+		}
+		b.logger.Debugf("pb-adv rx: % 2x", pdu)
+		transNum := int(pdu[4])
+		genPdu := pdu[5:]
+		switch genPdu[0] & 0x03 {
+		case gpcfBearerControl:
+			b.handleBearerControl(genPdu[0]>>2, genPdu[1:])
+		case gpcfTransactionAck:
+			select {
+			case b.ackChan <- byte(transNum):
+			default:
+			}
+		case gpcfTransactionStart:
+			if len(genPdu) < 4 {
+				continue
+			}
+			if transNum == b.rxLastNum {
+				// the node did not get our ack
+				b.send(byte(transNum), []byte{gpcfTransactionAck})
+				continue
+			}
+			if transNum == b.rx.num {
+				continue
+			}
+			b.rx = pbAdvTransaction{
+				num:    transNum,
+				segN:   int(genPdu[0] >> 2),
+				length: int(binary.BigEndian.Uint16(genPdu[1:3])),
+				fcs:    genPdu[3],
+			}
+			b.rx.pdu = make([]byte, b.rx.length)
+			b.storeSegment(0, genPdu[4:])
+		case gpcfTransactionContinuation:
+			if transNum != b.rx.num || transNum == b.rxLastNum {
+				continue
+			}
+			b.storeSegment(int(genPdu[0]>>2), genPdu[1:])
+		}
+	}
+}
+
+func (b *PbAdvBear) handleBearerControl(opcode byte, params []byte) {
+	switch opcode {
+	case pbAdvLinkAck:
+		select {
+		case b.linkAcked <- true:
+		default:
+		}
+	case pbAdvLinkClose:
+		reason := -1
+		if len(params) > 0 {
+			reason = int(params[0])
+		}
+		b.logger.Infof("pb-adv link %08x closed by node, reason:%d", b.linkId, reason)
+		b.stateLock.Lock()
+		b.opened = false
+		b.stateLock.Unlock()
+		b.unregister()
+		// fail the session now rather than on its timeout
+		provisionLinkClosed(b, reason)
+	}
+}
+
+func (b *PbAdvBear) storeSegment(index int, data []byte) {
+	rx := &b.rx
+	if index > rx.segN {
+		b.logger.Errorf("invalid segment index %d of transaction %d", index, rx.num)
+		return
+	}
+	offset := 0
+	if index > 0 {
+		offset = pbAdvStartMtu + (index-1)*pbAdvContinuationMtu
+	}
+	if offset+len(data) > rx.length {
+		b.logger.Errorf("segment %d of transaction %d exceeds total length", index, rx.num)
+		return
+	}
+	copy(rx.pdu[offset:], data)
+	rx.segRcvd |= 1 << uint(index)
+	if rx.segRcvd != (1<<uint(rx.segN+1))-1 {
+		return
+	}
+	if calcFcs(rx.pdu) != rx.fcs {
+		b.logger.Errorf("fcs check failed for transaction %d", rx.num)
+		b.rx = pbAdvTransaction{num: -1}
+		return
+	}
+	b.send(byte(rx.num), []byte{gpcfTransactionAck})
+	b.rxLastNum = rx.num
+	// a response from the node acknowledges our outstanding transaction as well
+	select {
+	case b.ackChan <- pbAdvImplicitAck:
+	default:
+	}
+	provisionReceive(b, rx.pdu)
+}
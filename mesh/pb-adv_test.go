@@ -0,0 +1,50 @@
+package mesh
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_pbAdvFcs(t *testing.T) {
+	// Provisioning Invite sample: 00 0002 14 0000
+	assert.Equal(t, byte(0x14), calcFcs([]byte{0x00, 0x00}), "they should be equal")
+}
+
+func Test_pbAdvSegmentation(t *testing.T) {
+	b := &PbAdvBear{}
+	segs := b.segmentation([]byte{provInvite, 0x00})
+	assert.Equal(t, [][]byte{{0x00, 0x00, 0x02, 0x14, 0x00, 0x00}}, segs, "they should be equal")
+
+	pdu := make([]byte, 65)
+	pdu[0] = provPublicKey
+	segs = b.segmentation(pdu)
+	assert.Equal(t, 3, len(segs))
+	assert.Equal(t, byte(2<<2|gpcfTransactionStart), segs[0][0])
+	assert.Equal(t, []byte{0x00, 0x41}, segs[0][1:3])
+	assert.Equal(t, 4+pbAdvStartMtu, len(segs[0]))
+	assert.Equal(t, byte(1<<2|gpcfTransactionContinuation), segs[1][0])
+	assert.Equal(t, 1+pbAdvContinuationMtu, len(segs[1]))
+	assert.Equal(t, byte(2<<2|gpcfTransactionContinuation), segs[2][0])
+	assert.Equal(t, 1+65-pbAdvStartMtu-pbAdvContinuationMtu, len(segs[2]))
+}
+
+func Test_pbAdvLinkClose(t *testing.T) {
+	b := &PbAdvBear{UUID: "70cf7c9732a345b691494810d2e9cbf4"}
+	b.SetWriteHandle(func([]byte) error { return nil })
+	s := StartMeshProvision(UnprovisionedDevice{UUID: b.UUID}, b, nil)
+	assert.NotNil(t, s)
+	header := make([]byte, 5)
+	binary.BigEndian.PutUint32(header, b.linkId)
+	pbAdvReceive(append(header, pbAdvLinkAck<<2|gpcfBearerControl))
+	pbAdvReceive(append(header, pbAdvLinkClose<<2|gpcfBearerControl, pbAdvCloseFail))
+	select {
+	case result := <-s.Result():
+		assert.Equal(t, ProvFailLinkClosed, result.Reason)
+		assert.NotNil(t, result.Err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the session is not failed on Link Close")
+	}
+}
@@ -10,36 +10,78 @@ var Types = map[string]reflect.Type{
 	"AckTimeout": reflect.TypeOf((*AckTimeout)(nil)).Elem(),
 	"AdvertisingBear": reflect.TypeOf((*AdvertisingBear)(nil)).Elem(),
 	"AppKey": reflect.TypeOf((*AppKey)(nil)).Elem(),
+	"AuthMethod": reflect.TypeOf((*AuthMethod)(nil)).Elem(),
+	"AuthPolicy": reflect.TypeOf((*AuthPolicy)(nil)).Elem(),
+	"AuthRequest": reflect.TypeOf((*AuthRequest)(nil)).Elem(),
+	"AuthValueHandler": reflect.TypeOf((*AuthValueHandler)(nil)).Elem(),
 	"Bear": reflect.TypeOf((*Bear)(nil)).Elem(),
 	"Capability": reflect.TypeOf((*Capability)(nil)).Elem(),
 	"Composition": reflect.TypeOf((*Composition)(nil)).Elem(),
 	"CompositionElement": reflect.TypeOf((*CompositionElement)(nil)).Elem(),
 	"DevKey": reflect.TypeOf((*DevKey)(nil)).Elem(),
 	"Element": reflect.TypeOf((*Element)(nil)).Elem(),
+	"FaultyNode": reflect.TypeOf((*FaultyNode)(nil)).Elem(),
 	"Features": reflect.TypeOf((*Features)(nil)).Elem(),
+	"FriendshipInfo": reflect.TypeOf((*FriendshipInfo)(nil)).Elem(),
 	"GattProxyBear": reflect.TypeOf((*GattProxyBear)(nil)).Elem(),
 	"Group": reflect.TypeOf((*Group)(nil)).Elem(),
+	"HealthFault": reflect.TypeOf((*HealthFault)(nil)).Elem(),
+	"HealthFaultRecord": reflect.TypeOf((*HealthFaultRecord)(nil)).Elem(),
+	"HeartbeatEvent": reflect.TypeOf((*HeartbeatEvent)(nil)).Elem(),
+	"HeartbeatInfo": reflect.TypeOf((*HeartbeatInfo)(nil)).Elem(),
 	"LightCtlState": reflect.TypeOf((*LightCtlState)(nil)).Elem(),
 	"LightCtlTemperatureState": reflect.TypeOf((*LightCtlTemperatureState)(nil)).Elem(),
+	"LightHslState": reflect.TypeOf((*LightHslState)(nil)).Elem(),
+	"LightXylState": reflect.TypeOf((*LightXylState)(nil)).Elem(),
 	"LightnessState": reflect.TypeOf((*LightnessState)(nil)).Elem(),
+	"LowPowerStatus": reflect.TypeOf((*LowPowerStatus)(nil)).Elem(),
 	"Mesh": reflect.TypeOf((*Mesh)(nil)).Elem(),
 	"Model": reflect.TypeOf((*Model)(nil)).Elem(),
-	"Net": reflect.TypeOf((*Net)(nil)).Elem(),
 	"NetKey": reflect.TypeOf((*NetKey)(nil)).Elem(),
 	"NetworkMessage": reflect.TypeOf((*NetworkMessage)(nil)).Elem(),
 	"Node": reflect.TypeOf((*Node)(nil)).Elem(),
 	"NodeKeyBinding": reflect.TypeOf((*NodeKeyBinding)(nil)).Elem(),
 	"OnOffState": reflect.TypeOf((*OnOffState)(nil)).Elem(),
+	"OnboardingAppKey": reflect.TypeOf((*OnboardingAppKey)(nil)).Elem(),
+	"OnboardingProfile": reflect.TypeOf((*OnboardingProfile)(nil)).Elem(),
+	"OnboardingPublication": reflect.TypeOf((*OnboardingPublication)(nil)).Elem(),
+	"OnboardingRelay": reflect.TypeOf((*OnboardingRelay)(nil)).Elem(),
+	"OnboardingReport": reflect.TypeOf((*OnboardingReport)(nil)).Elem(),
+	"OnboardingStep": reflect.TypeOf((*OnboardingStep)(nil)).Elem(),
+	"OnboardingSubscription": reflect.TypeOf((*OnboardingSubscription)(nil)).Elem(),
+	"PbAdvBear": reflect.TypeOf((*PbAdvBear)(nil)).Elem(),
 	"ProvNode": reflect.TypeOf((*ProvNode)(nil)).Elem(),
 	"ProvisionData": reflect.TypeOf((*ProvisionData)(nil)).Elem(),
+	"ProvisionFailReason": reflect.TypeOf((*ProvisionFailReason)(nil)).Elem(),
+	"ProvisionResult": reflect.TypeOf((*ProvisionResult)(nil)).Elem(),
+	"ProvisioningSession": reflect.TypeOf((*ProvisioningSession)(nil)).Elem(),
+	"PublicKeySource": reflect.TypeOf((*PublicKeySource)(nil)).Elem(),
+	"RelayStats": reflect.TypeOf((*RelayStats)(nil)).Elem(),
 	"RemainingTime": reflect.TypeOf((*RemainingTime)(nil)).Elem(),
+	"RplStats": reflect.TypeOf((*RplStats)(nil)).Elem(),
+	"Scene": reflect.TypeOf((*Scene)(nil)).Elem(),
+	"SceneState": reflect.TypeOf((*SceneState)(nil)).Elem(),
+	"SchedulerEntry": reflect.TypeOf((*SchedulerEntry)(nil)).Elem(),
+	"SchedulerState": reflect.TypeOf((*SchedulerState)(nil)).Elem(),
 	"SegmentAckMessage": reflect.TypeOf((*SegmentAckMessage)(nil)).Elem(),
+	"SensorCadence": reflect.TypeOf((*SensorCadence)(nil)).Elem(),
+	"SensorColumn": reflect.TypeOf((*SensorColumn)(nil)).Elem(),
+	"SensorDescriptor": reflect.TypeOf((*SensorDescriptor)(nil)).Elem(),
+	"SensorPropertyInfo": reflect.TypeOf((*SensorPropertyInfo)(nil)).Elem(),
+	"SensorSetting": reflect.TypeOf((*SensorSetting)(nil)).Elem(),
+	"SensorState": reflect.TypeOf((*SensorState)(nil)).Elem(),
+	"SensorValue": reflect.TypeOf((*SensorValue)(nil)).Elem(),
 	"TID": reflect.TypeOf((*TID)(nil)).Elem(),
+	"TimeAuthorityStatus": reflect.TypeOf((*TimeAuthorityStatus)(nil)).Elem(),
+	"TimeState": reflect.TypeOf((*TimeState)(nil)).Elem(),
 	"TpSar": reflect.TypeOf((*TpSar)(nil)).Elem(),
 	"Transition": reflect.TypeOf((*Transition)(nil)).Elem(),
+	"UnprovisionedDevice": reflect.TypeOf((*UnprovisionedDevice)(nil)).Elem(),
 }
 
 var Functions = map[string]reflect.Value{
+	"CctToXy": reflect.ValueOf(CctToXy),
+	"ColorToXyl": reflect.ValueOf(ColorToXyl),
 	"ConfigAppKeyAdd": reflect.ValueOf(ConfigAppKeyAdd),
 	"ConfigAppKeyDelete": reflect.ValueOf(ConfigAppKeyDelete),
 	"ConfigAppKeyGet": reflect.ValueOf(ConfigAppKeyGet),
@@ -53,6 +95,12 @@ var Functions = map[string]reflect.Value{
 	"ConfigFriendSet": reflect.ValueOf(ConfigFriendSet),
 	"ConfigGattProxyGet": reflect.ValueOf(ConfigGattProxyGet),
 	"ConfigGattProxySet": reflect.ValueOf(ConfigGattProxySet),
+	"ConfigHeartbeatPublicationGet": reflect.ValueOf(ConfigHeartbeatPublicationGet),
+	"ConfigHeartbeatPublicationSet": reflect.ValueOf(ConfigHeartbeatPublicationSet),
+	"ConfigHeartbeatSubscriptionGet": reflect.ValueOf(ConfigHeartbeatSubscriptionGet),
+	"ConfigHeartbeatSubscriptionSet": reflect.ValueOf(ConfigHeartbeatSubscriptionSet),
+	"ConfigKeyRefreshPhaseGet": reflect.ValueOf(ConfigKeyRefreshPhaseGet),
+	"ConfigKeyRefreshPhaseSet": reflect.ValueOf(ConfigKeyRefreshPhaseSet),
 	"ConfigLowPowerNodePollTimeoutGet": reflect.ValueOf(ConfigLowPowerNodePollTimeoutGet),
 	"ConfigModelAppBind": reflect.ValueOf(ConfigModelAppBind),
 	"ConfigModelAppUnbind": reflect.ValueOf(ConfigModelAppUnbind),
@@ -77,6 +125,7 @@ var Functions = map[string]reflect.Value{
 	"ConfigSigModelSubscriptionGet": reflect.ValueOf(ConfigSigModelSubscriptionGet),
 	"ConfigVendorModelAppGet": reflect.ValueOf(ConfigVendorModelAppGet),
 	"ConfigVendorModelSubscriptionGet": reflect.ValueOf(ConfigVendorModelSubscriptionGet),
+	"EvictNode": reflect.ValueOf(EvictNode),
 	"GenericLevelGet": reflect.ValueOf(GenericLevelGet),
 	"GenericLevelSet": reflect.ValueOf(GenericLevelSet),
 	"GenericLevelSetUnacknowledged": reflect.ValueOf(GenericLevelSetUnacknowledged),
@@ -84,8 +133,26 @@ var Functions = map[string]reflect.Value{
 	"GenericOnOffSet": reflect.ValueOf(GenericOnOffSet),
 	"GenericOnOffSetUnacknowledged": reflect.ValueOf(GenericOnOffSetUnacknowledged),
 	"GetDb": reflect.ValueOf(GetDb),
+	"GetFaultyNodes": reflect.ValueOf(GetFaultyNodes),
+	"GetFriendships": reflect.ValueOf(GetFriendships),
+	"GetHeartbeats": reflect.ValueOf(GetHeartbeats),
+	"GetLowPowerStatus": reflect.ValueOf(GetLowPowerStatus),
 	"GetNode": reflect.ValueOf(GetNode),
+	"GetRelayStats": reflect.ValueOf(GetRelayStats),
+	"GetRplStats": reflect.ValueOf(GetRplStats),
+	"GetScenes": reflect.ValueOf(GetScenes),
+	"GetSensorProperties": reflect.ValueOf(GetSensorProperties),
+	"GetTimeAuthorityStatus": reflect.ValueOf(GetTimeAuthorityStatus),
+	"HealthAttentionGet": reflect.ValueOf(HealthAttentionGet),
+	"HealthAttentionSet": reflect.ValueOf(HealthAttentionSet),
+	"HealthFaultClear": reflect.ValueOf(HealthFaultClear),
+	"HealthFaultGet": reflect.ValueOf(HealthFaultGet),
+	"HealthFaultTest": reflect.ValueOf(HealthFaultTest),
+	"HealthPeriodGet": reflect.ValueOf(HealthPeriodGet),
+	"HealthPeriodSet": reflect.ValueOf(HealthPeriodSet),
+	"HexToHsl": reflect.ValueOf(HexToHsl),
 	"Init": reflect.ValueOf(Init),
+	"IsKnownNetworkId": reflect.ValueOf(IsKnownNetworkId),
 	"LightCtlDefaultGet": reflect.ValueOf(LightCtlDefaultGet),
 	"LightCtlDefaultSet": reflect.ValueOf(LightCtlDefaultSet),
 	"LightCtlGet": reflect.ValueOf(LightCtlGet),
@@ -94,6 +161,30 @@ var Functions = map[string]reflect.Value{
 	"LightCtlTemperatureRangeGet": reflect.ValueOf(LightCtlTemperatureRangeGet),
 	"LightCtlTemperatureRangeSet": reflect.ValueOf(LightCtlTemperatureRangeSet),
 	"LightCtlTemperatureSet": reflect.ValueOf(LightCtlTemperatureSet),
+	"LightHslDefaultGet": reflect.ValueOf(LightHslDefaultGet),
+	"LightHslDefaultSet": reflect.ValueOf(LightHslDefaultSet),
+	"LightHslGet": reflect.ValueOf(LightHslGet),
+	"LightHslHueGet": reflect.ValueOf(LightHslHueGet),
+	"LightHslHueSet": reflect.ValueOf(LightHslHueSet),
+	"LightHslHueSetUnacknowledged": reflect.ValueOf(LightHslHueSetUnacknowledged),
+	"LightHslRangeGet": reflect.ValueOf(LightHslRangeGet),
+	"LightHslRangeSet": reflect.ValueOf(LightHslRangeSet),
+	"LightHslSaturationGet": reflect.ValueOf(LightHslSaturationGet),
+	"LightHslSaturationSet": reflect.ValueOf(LightHslSaturationSet),
+	"LightHslSaturationSetUnacknowledged": reflect.ValueOf(LightHslSaturationSetUnacknowledged),
+	"LightHslSet": reflect.ValueOf(LightHslSet),
+	"LightHslSetColor": reflect.ValueOf(LightHslSetColor),
+	"LightHslSetUnacknowledged": reflect.ValueOf(LightHslSetUnacknowledged),
+	"LightHslTargetGet": reflect.ValueOf(LightHslTargetGet),
+	"LightXylDefaultGet": reflect.ValueOf(LightXylDefaultGet),
+	"LightXylDefaultSet": reflect.ValueOf(LightXylDefaultSet),
+	"LightXylGet": reflect.ValueOf(LightXylGet),
+	"LightXylRangeGet": reflect.ValueOf(LightXylRangeGet),
+	"LightXylRangeSet": reflect.ValueOf(LightXylRangeSet),
+	"LightXylSet": reflect.ValueOf(LightXylSet),
+	"LightXylSetColor": reflect.ValueOf(LightXylSetColor),
+	"LightXylSetUnacknowledged": reflect.ValueOf(LightXylSetUnacknowledged),
+	"LightXylTargetGet": reflect.ValueOf(LightXylTargetGet),
 	"LightnessDefaultGet": reflect.ValueOf(LightnessDefaultGet),
 	"LightnessDefaultSet": reflect.ValueOf(LightnessDefaultSet),
 	"LightnessGet": reflect.ValueOf(LightnessGet),
@@ -102,16 +193,78 @@ var Functions = map[string]reflect.Value{
 	"LightnessRangeGet": reflect.ValueOf(LightnessRangeGet),
 	"LightnessRangeSet": reflect.ValueOf(LightnessRangeSet),
 	"LightnessSet": reflect.ValueOf(LightnessSet),
+	"LoadOnboardingProfile": reflect.ValueOf(LoadOnboardingProfile),
+	"LowPowerSubscriptionAdd": reflect.ValueOf(LowPowerSubscriptionAdd),
+	"LowPowerSubscriptionRemove": reflect.ValueOf(LowPowerSubscriptionRemove),
 	"OnClose": reflect.ValueOf(OnClose),
+	"OnboardNode": reflect.ValueOf(OnboardNode),
+	"ProxyConnected": reflect.ValueOf(ProxyConnected),
+	"ProxyFilterAdd": reflect.ValueOf(ProxyFilterAdd),
+	"ProxyFilterRemove": reflect.ValueOf(ProxyFilterRemove),
+	"ProxySetFilterType": reflect.ValueOf(ProxySetFilterType),
 	"RefreshNetKey": reflect.ValueOf(RefreshNetKey),
 	"ResetNode": reflect.ValueOf(ResetNode),
+	"ResolveNodeIdentity": reflect.ValueOf(ResolveNodeIdentity),
+	"ResumeKeyRefresh": reflect.ValueOf(ResumeKeyRefresh),
+	"RgbToHsl": reflect.ValueOf(RgbToHsl),
+	"RgbToXyl": reflect.ValueOf(RgbToXyl),
+	"SceneDelete": reflect.ValueOf(SceneDelete),
+	"SceneDeleteUnacknowledged": reflect.ValueOf(SceneDeleteUnacknowledged),
+	"SceneGet": reflect.ValueOf(SceneGet),
+	"SceneRecall": reflect.ValueOf(SceneRecall),
+	"SceneRecallByName": reflect.ValueOf(SceneRecallByName),
+	"SceneRecallUnacknowledged": reflect.ValueOf(SceneRecallUnacknowledged),
+	"SceneRegisterGet": reflect.ValueOf(SceneRegisterGet),
+	"SceneRemove": reflect.ValueOf(SceneRemove),
+	"SceneStore": reflect.ValueOf(SceneStore),
+	"SceneStoreUnacknowledged": reflect.ValueOf(SceneStoreUnacknowledged),
+	"SchedulerActionGet": reflect.ValueOf(SchedulerActionGet),
+	"SchedulerActionSet": reflect.ValueOf(SchedulerActionSet),
+	"SchedulerActionSetUnacknowledged": reflect.ValueOf(SchedulerActionSetUnacknowledged),
+	"SchedulerActionsGet": reflect.ValueOf(SchedulerActionsGet),
+	"SchedulerActionsSet": reflect.ValueOf(SchedulerActionsSet),
+	"SchedulerGet": reflect.ValueOf(SchedulerGet),
+	"SensorCadenceGet": reflect.ValueOf(SensorCadenceGet),
+	"SensorCadenceSet": reflect.ValueOf(SensorCadenceSet),
+	"SensorColumnGet": reflect.ValueOf(SensorColumnGet),
+	"SensorDescriptorGet": reflect.ValueOf(SensorDescriptorGet),
+	"SensorGet": reflect.ValueOf(SensorGet),
+	"SensorSeriesGet": reflect.ValueOf(SensorSeriesGet),
+	"SensorSettingGet": reflect.ValueOf(SensorSettingGet),
+	"SensorSettingSet": reflect.ValueOf(SensorSettingSet),
+	"SensorSettingsGet": reflect.ValueOf(SensorSettingsGet),
+	"SetAuthValueHandler": reflect.ValueOf(SetAuthValueHandler),
+	"SetFriend": reflect.ValueOf(SetFriend),
+	"SetHeartbeatEventHandler": reflect.ValueOf(SetHeartbeatEventHandler),
+	"SetHostTAIUTCDelta": reflect.ValueOf(SetHostTAIUTCDelta),
+	"SetIvUpdateClock": reflect.ValueOf(SetIvUpdateClock),
 	"SetNetworkBear": reflect.ValueOf(SetNetworkBear),
+	"SetNetworkTransmit": reflect.ValueOf(SetNetworkTransmit),
 	"SetNode": reflect.ValueOf(SetNode),
-	"SetProvisionBear": reflect.ValueOf(SetProvisionBear),
+	"SetProvisionAuthPolicy": reflect.ValueOf(SetProvisionAuthPolicy),
+	"SetPublicKeySource": reflect.ValueOf(SetPublicKeySource),
+	"SetRelay": reflect.ValueOf(SetRelay),
+	"SetSceneName": reflect.ValueOf(SetSceneName),
+	"StartIvUpdate": reflect.ValueOf(StartIvUpdate),
+	"StartLowPower": reflect.ValueOf(StartLowPower),
 	"StartMeshNetwork": reflect.ValueOf(StartMeshNetwork),
 	"StartMeshProvision": reflect.ValueOf(StartMeshProvision),
+	"StartTimeAuthority": reflect.ValueOf(StartTimeAuthority),
+	"StopLowPower": reflect.ValueOf(StopLowPower),
 	"StopMeshNetwork": reflect.ValueOf(StopMeshNetwork),
 	"StopMeshProvision": reflect.ValueOf(StopMeshProvision),
+	"StopTimeAuthority": reflect.ValueOf(StopTimeAuthority),
+	"TAIUTCDeltaGet": reflect.ValueOf(TAIUTCDeltaGet),
+	"TAIUTCDeltaSet": reflect.ValueOf(TAIUTCDeltaSet),
+	"TimeGet": reflect.ValueOf(TimeGet),
+	"TimeRoleGet": reflect.ValueOf(TimeRoleGet),
+	"TimeRoleSet": reflect.ValueOf(TimeRoleSet),
+	"TimeSet": reflect.ValueOf(TimeSet),
+	"TimeSetHost": reflect.ValueOf(TimeSetHost),
+	"TimeZoneGet": reflect.ValueOf(TimeZoneGet),
+	"TimeZoneSet": reflect.ValueOf(TimeZoneSet),
+	"XyToCct": reflect.ValueOf(XyToCct),
+	"XylToRgb": reflect.ValueOf(XylToRgb),
 }
 
 var Variables = map[string]reflect.Value{
@@ -120,6 +273,10 @@ var Variables = map[string]reflect.Value{
 var Consts = map[string]reflect.Value{
 	"ADV_BEAR": reflect.ValueOf(ADV_BEAR),
 	"ALL_NODES_ADDRESS": reflect.ValueOf(ALL_NODES_ADDRESS),
+	"AuthInputOob": reflect.ValueOf(AuthInputOob),
+	"AuthNoOob": reflect.ValueOf(AuthNoOob),
+	"AuthOutputOob": reflect.ValueOf(AuthOutputOob),
+	"AuthStaticOob": reflect.ValueOf(AuthStaticOob),
 	"BEACON": reflect.ValueOf(BEACON),
 	"BT_LE_ADV_BEACON": reflect.ValueOf(BT_LE_ADV_BEACON),
 	"BT_LE_ADV_NETWORK": reflect.ValueOf(BT_LE_ADV_NETWORK),
@@ -129,6 +286,8 @@ var Consts = map[string]reflect.Value{
 	"ConfigServer": reflect.ValueOf(ConfigServer),
 	"FIRST": reflect.ValueOf(FIRST),
 	"FRIENDS_ADDRESS": reflect.ValueOf(FRIENDS_ADDRESS),
+	"FRIEND_DISABLED": reflect.ValueOf(FRIEND_DISABLED),
+	"FRIEND_ENABLED": reflect.ValueOf(FRIEND_ENABLED),
 	"GATT_BEAR": reflect.ValueOf(GATT_BEAR),
 	"GROUP_ADDRESS_HIGH": reflect.ValueOf(GROUP_ADDRESS_HIGH),
 	"GROUP_ADDRESS_LOW": reflect.ValueOf(GROUP_ADDRESS_LOW),
@@ -154,6 +313,12 @@ var Consts = map[string]reflect.Value{
 	"GenericPowerOnOffSetupServer": reflect.ValueOf(GenericPowerOnOffSetupServer),
 	"GenericPropertyClient": reflect.ValueOf(GenericPropertyClient),
 	"GenericUserPropertyServer": reflect.ValueOf(GenericUserPropertyServer),
+	"HEARTBEAT_FEATURE_FRIEND": reflect.ValueOf(HEARTBEAT_FEATURE_FRIEND),
+	"HEARTBEAT_FEATURE_LOW_POWER": reflect.ValueOf(HEARTBEAT_FEATURE_LOW_POWER),
+	"HEARTBEAT_FEATURE_PROXY": reflect.ValueOf(HEARTBEAT_FEATURE_PROXY),
+	"HEARTBEAT_FEATURE_RELAY": reflect.ValueOf(HEARTBEAT_FEATURE_RELAY),
+	"HEARTBEAT_MISSED": reflect.ValueOf(HEARTBEAT_MISSED),
+	"HEARTBEAT_RECOVERED": reflect.ValueOf(HEARTBEAT_RECOVERED),
 	"HealthServer": reflect.ValueOf(HealthServer),
 	"LAST": reflect.ValueOf(LAST),
 	"LightCTLClient": reflect.ValueOf(LightCTLClient),
@@ -179,8 +344,50 @@ var Consts = map[string]reflect.Value{
 	"NODLC": reflect.ValueOf(NODLC),
 	"PROVISION": reflect.ValueOf(PROVISION),
 	"PROXIES_ADDRESS": reflect.ValueOf(PROXIES_ADDRESS),
+	"PROXY_ADD_ADDRESSES": reflect.ValueOf(PROXY_ADD_ADDRESSES),
 	"PROXY_CONFIG": reflect.ValueOf(PROXY_CONFIG),
+	"PROXY_FILTER_BLACKLIST": reflect.ValueOf(PROXY_FILTER_BLACKLIST),
+	"PROXY_FILTER_STATUS": reflect.ValueOf(PROXY_FILTER_STATUS),
+	"PROXY_FILTER_WHITELIST": reflect.ValueOf(PROXY_FILTER_WHITELIST),
+	"PROXY_REMOVE_ADDRESSES": reflect.ValueOf(PROXY_REMOVE_ADDRESSES),
+	"PROXY_SET_FILTER_TYPE": reflect.ValueOf(PROXY_SET_FILTER_TYPE),
+	"ProvErrCannotAssignAddresses": reflect.ValueOf(ProvErrCannotAssignAddresses),
+	"ProvErrConfirmationFailed": reflect.ValueOf(ProvErrConfirmationFailed),
+	"ProvErrDecryptionFailed": reflect.ValueOf(ProvErrDecryptionFailed),
+	"ProvErrInvalidFormat": reflect.ValueOf(ProvErrInvalidFormat),
+	"ProvErrInvalidPdu": reflect.ValueOf(ProvErrInvalidPdu),
+	"ProvErrOutOfResources": reflect.ValueOf(ProvErrOutOfResources),
+	"ProvErrProhibited": reflect.ValueOf(ProvErrProhibited),
+	"ProvErrUnexpectedError": reflect.ValueOf(ProvErrUnexpectedError),
+	"ProvErrUnexpectedPdu": reflect.ValueOf(ProvErrUnexpectedPdu),
+	"ProvFailCanceled": reflect.ValueOf(ProvFailCanceled),
+	"ProvFailLinkClosed": reflect.ValueOf(ProvFailLinkClosed),
+	"ProvFailPdu": reflect.ValueOf(ProvFailPdu),
+	"ProvFailTimeout": reflect.ValueOf(ProvFailTimeout),
 	"RELAYS_ADDRESS": reflect.ValueOf(RELAYS_ADDRESS),
+	"RELAY_DISABLED": reflect.ValueOf(RELAY_DISABLED),
+	"RELAY_ENABLED": reflect.ValueOf(RELAY_ENABLED),
+	"SCHEDULER_ACTION_NO_ACTION": reflect.ValueOf(SCHEDULER_ACTION_NO_ACTION),
+	"SCHEDULER_ACTION_SCENE_RECALL": reflect.ValueOf(SCHEDULER_ACTION_SCENE_RECALL),
+	"SCHEDULER_ACTION_TURN_OFF": reflect.ValueOf(SCHEDULER_ACTION_TURN_OFF),
+	"SCHEDULER_ACTION_TURN_ON": reflect.ValueOf(SCHEDULER_ACTION_TURN_ON),
+	"SCHEDULER_ALL_MONTHS": reflect.ValueOf(SCHEDULER_ALL_MONTHS),
+	"SCHEDULER_ANY_DAY": reflect.ValueOf(SCHEDULER_ANY_DAY),
+	"SCHEDULER_ANY_HOUR": reflect.ValueOf(SCHEDULER_ANY_HOUR),
+	"SCHEDULER_ANY_MINUTE": reflect.ValueOf(SCHEDULER_ANY_MINUTE),
+	"SCHEDULER_ANY_SECOND": reflect.ValueOf(SCHEDULER_ANY_SECOND),
+	"SCHEDULER_ANY_YEAR": reflect.ValueOf(SCHEDULER_ANY_YEAR),
+	"SCHEDULER_ENTRIES": reflect.ValueOf(SCHEDULER_ENTRIES),
+	"SCHEDULER_EVERY_15_MINUTES": reflect.ValueOf(SCHEDULER_EVERY_15_MINUTES),
+	"SCHEDULER_EVERY_15_SECONDS": reflect.ValueOf(SCHEDULER_EVERY_15_SECONDS),
+	"SCHEDULER_EVERY_20_MINUTES": reflect.ValueOf(SCHEDULER_EVERY_20_MINUTES),
+	"SCHEDULER_EVERY_20_SECONDS": reflect.ValueOf(SCHEDULER_EVERY_20_SECONDS),
+	"SCHEDULER_EVERY_DAY": reflect.ValueOf(SCHEDULER_EVERY_DAY),
+	"SCHEDULER_RANDOM_HOUR": reflect.ValueOf(SCHEDULER_RANDOM_HOUR),
+	"SCHEDULER_RANDOM_MINUTE": reflect.ValueOf(SCHEDULER_RANDOM_MINUTE),
+	"SCHEDULER_RANDOM_SECOND": reflect.ValueOf(SCHEDULER_RANDOM_SECOND),
+	"SCHEDULER_WEEKDAYS": reflect.ValueOf(SCHEDULER_WEEKDAYS),
+	"SCHEDULER_WEEKEND": reflect.ValueOf(SCHEDULER_WEEKEND),
 	"SEGMENT_SIZE": reflect.ValueOf(SEGMENT_SIZE),
 	"STATUS_SUCCESS": reflect.ValueOf(STATUS_SUCCESS),
 	"SceneClient": reflect.ValueOf(SceneClient),
@@ -192,6 +399,10 @@ var Consts = map[string]reflect.Value{
 	"SensorClient": reflect.ValueOf(SensorClient),
 	"SensorServer": reflect.ValueOf(SensorServer),
 	"SensorSetupServer": reflect.ValueOf(SensorSetupServer),
+	"TIME_ROLE_AUTHORITY": reflect.ValueOf(TIME_ROLE_AUTHORITY),
+	"TIME_ROLE_CLIENT": reflect.ValueOf(TIME_ROLE_CLIENT),
+	"TIME_ROLE_NONE": reflect.ValueOf(TIME_ROLE_NONE),
+	"TIME_ROLE_RELAY": reflect.ValueOf(TIME_ROLE_RELAY),
 	"TimeClient": reflect.ValueOf(TimeClient),
 	"TimeServer": reflect.ValueOf(TimeServer),
 	"TimeSetupServer": reflect.ValueOf(TimeSetupServer),
@@ -6,13 +6,16 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"encoding/binary"
+	"fmt"
 	"math/big"
+	"sync"
 	"time"
 
 	"github.com/aead/ecdh"
 
 	meshCrypto "ble-mesh/mesh/crypto"
 	"ble-mesh/utils"
+	"ble-mesh/utils/errors"
 )
 
 type Capability struct {
@@ -37,9 +40,20 @@ type ProvNode struct {
 	confirmation     []byte
 	confirmationKey  []byte
 	confirmationSalt []byte
+	auth             *AuthRequest
+	oobPublicKey     []byte
 	node             *Node
 }
 
+// UnprovisionedDevice is the device as advertised in its beacon
+type UnprovisionedDevice struct {
+	UUID string
+	// OOB Information of the beacon
+	OOB uint16
+	// hash of the URI of the OOB information, 0 if the beacon has none
+	UriHash uint32
+}
+
 type ProvisionData struct {
 	NetKey      []byte
 	NetKeyIndex uint
@@ -48,12 +62,36 @@ type ProvisionData struct {
 	UnicastAddr uint
 }
 
-type Net struct {
-	localNode  *ProvNode
-	remoteNode *ProvNode
+type ProvisionFailReason byte
+
+type ProvisionResult struct {
+	UUID string
+	// the provisioned node, nil if provisioning failed
+	Node *Node
+	// the pdu type the provisioner was waiting for when it failed
+	State  byte
+	Reason ProvisionFailReason
+	// the error code of the Provisioning Failed pdu, only set if Reason is ProvFailPdu
+	ErrorCode byte
+	// the error code comes from a Provisioning Failed pdu of the device
+	ReportedByDevice bool
+	Err              error
 }
 
-const useNonOob bool = true
+type ProvisioningSession struct {
+	UUID               string
+	device             UnprovisionedDevice
+	bear               Bear
+	provChan           chan []byte
+	linkClosed         chan int
+	done               chan bool
+	stopOnce           *sync.Once
+	localNode          *ProvNode
+	remoteNode         *ProvNode
+	confirmationInputs []byte
+	result             chan *ProvisionResult
+	stoppedCb          func(*ProvisionResult)
+}
 
 const (
 	provInvite = iota
@@ -68,72 +106,299 @@ const (
 	provFailed
 )
 
+/* Provisioning error codes */
+const (
+	ProvErrProhibited = iota
+	ProvErrInvalidPdu
+	ProvErrInvalidFormat
+	ProvErrUnexpectedPdu
+	ProvErrConfirmationFailed
+	ProvErrOutOfResources
+	ProvErrDecryptionFailed
+	ProvErrUnexpectedError
+	ProvErrCannotAssignAddresses
+)
+
+/* Provisioning fail reasons */
+const (
+	// a Provisioning Failed pdu was sent or received
+	ProvFailPdu ProvisionFailReason = iota + 1
+	// the link timed out locally, no pdu was exchanged
+	ProvFailTimeout
+	// the provisioning was canceled locally
+	ProvFailCanceled
+	// the device closed the link, Err carries its reason
+	ProvFailLinkClosed
+)
+
+// pdus received but not handled by the session yet, the bearer never waits
+// for the session to keep acking and handling Link Close
+const provRxQueueSize = 8
+
+var provTimeout = 60 * time.Second
+
+var provPduNames = map[int]string{
+	provInvite:        "Invite",
+	provCapabilities:  "Capabilities",
+	provStart:         "Start",
+	provPublicKey:     "Public Key",
+	provInputComplete: "Input Complete",
+	provConfirmation:  "Confirmation",
+	provRandom:        "Random",
+	provData:          "Data",
+	provComplete:      "Complete",
+	provFailed:        "Failed",
+}
+
+var provErrorNames = map[byte]string{
+	ProvErrProhibited:            "Prohibited",
+	ProvErrInvalidPdu:            "Invalid PDU",
+	ProvErrInvalidFormat:         "Invalid Format",
+	ProvErrUnexpectedPdu:         "Unexpected PDU",
+	ProvErrConfirmationFailed:    "Confirmation Failed",
+	ProvErrOutOfResources:        "Out of Resources",
+	ProvErrDecryptionFailed:      "Decryption Failed",
+	ProvErrUnexpectedError:       "Unexpected Error",
+	ProvErrCannotAssignAddresses: "Cannot Assign Addresses",
+}
+
 var (
-	provChan           chan []byte
-	net                Net
-	confirmationInputs []byte
-	loggerProv         = utils.CreateLogger("Provision")
+	// active sessions by their bearer
+	provSessions     = map[Bear]*ProvisioningSession{}
+	provSessionsLock = new(sync.Mutex)
+	// unicast address ranges assigned to the sessions not finished yet
+	provAddrReserved = map[*ProvisioningSession][2]uint{}
+	provAddrLock     = new(sync.Mutex)
+	loggerProv       = utils.CreateLogger("Provision")
 )
 
 var expectedLen = map[int]int{
-	provCapabilities: 11,
-	provPublicKey:    64,
-	provConfirmation: 16,
-	provRandom:       16,
-	provComplete:     0,
-	provFailed:       1,
+	provCapabilities:  11,
+	provPublicKey:     64,
+	provInputComplete: 0,
+	provConfirmation:  16,
+	provRandom:        16,
+	provComplete:      0,
+	provFailed:        1,
+}
+
+func provisionReceive(b Bear, proxyPdu []byte) {
+	provSessionsLock.Lock()
+	s, ok := provSessions[b]
+	provSessionsLock.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case s.provChan <- proxyPdu:
+	case <-s.done:
+	default:
+		loggerProv.Errorf("provision rx queue of %s is full, drop pdu: % 2x", s.UUID, proxyPdu)
+	}
+}
+
+// provisionLinkClosed is called by the bearer when the device closes the link,
+// reason is -1 if the device did not give one
+func provisionLinkClosed(b Bear, reason int) {
+	provSessionsLock.Lock()
+	s, ok := provSessions[b]
+	provSessionsLock.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case s.linkClosed <- reason:
+	default:
+	}
+}
+
+func newProvisioningSession(dev UnprovisionedDevice, bear Bear, provStopped func(*ProvisionResult)) *ProvisioningSession {
+	s := &ProvisioningSession{
+		UUID:       dev.UUID,
+		device:     dev,
+		bear:       bear,
+		provChan:   make(chan []byte, provRxQueueSize),
+		linkClosed: make(chan int, 1),
+		done:       make(chan bool),
+		stopOnce:   new(sync.Once),
+		result:     make(chan *ProvisionResult, 1),
+		stoppedCb:  provStopped,
+	}
+	s.remoteNode = &ProvNode{}
+	s.remoteNode.node = &Node{}
+	s.remoteNode.node.UUID = dev.UUID
+	return s
+}
+
+func (s *ProvisioningSession) start() {
+	s.bear.Start()
+	go s.provProc()
+}
+
+// Stop cancels the provisioning, the result is still delivered
+func (s *ProvisioningSession) Stop() {
+	s.stopOnce.Do(func() { close(s.done) })
+}
+
+// Result returns the channel receiving the result once the provisioning is finished
+func (s *ProvisioningSession) Result() <-chan *ProvisionResult {
+	return s.result
+}
+
+func (s *ProvisioningSession) finish(result *ProvisionResult) {
+	provSessionsLock.Lock()
+	delete(provSessions, s.bear)
+	provSessionsLock.Unlock()
+	releaseUnicastAddr(s)
+	s.Stop()
+	if b, ok := s.bear.(*PbAdvBear); ok && result.Err != nil {
+		b.closeReason = pbAdvCloseFail
+	}
+	s.bear.Stop()
+	if s.stoppedCb != nil {
+		s.stoppedCb(result)
+	}
+	s.result <- result
+}
+
+// allocUnicastAddr assigns addresses to the elements of the device, skipping
+// the ranges reserved by the other sessions
+func allocUnicastAddr(s *ProvisioningSession, numElements uint) (uint, bool) {
+	provAddrLock.Lock()
+	defer provAddrLock.Unlock()
+	addr := calcUnicastAddr(s.UUID)
+	if n, err := findNodeByAddr(addr); err == nil && n.UUID == s.UUID {
+		// the device was provisioned before and keeps its address
+		provAddrReserved[s] = [2]uint{addr, addr + numElements - 1}
+		return addr, true
+	}
+	for _, r := range provAddrReserved {
+		if addr <= r[1] {
+			addr = r[1] + 1
+		}
+	}
+	last := addr + numElements - 1
+	if !isUnicastAddr(addr) || !isUnicastAddr(last) ||
+		(meshDb.HighAddress != 0 && last > meshDb.HighAddress) {
+		return addr, false
+	}
+	provAddrReserved[s] = [2]uint{addr, last}
+	return addr, true
 }
 
-func provisionReceive(proxyPdu []byte) {
-	provChan <- proxyPdu
+func releaseUnicastAddr(s *ProvisioningSession) {
+	provAddrLock.Lock()
+	delete(provAddrReserved, s)
+	provAddrLock.Unlock()
 }
 
-func provProc() {
-	defer onProvisionFinished()
+func (r *ProvisionResult) fail(state int, code byte, reportedByDevice bool, err error) {
+	r.abort(state, ProvFailPdu, err)
+	r.ErrorCode = code
+	r.ReportedByDevice = reportedByDevice
+}
+
+// abort records a failure without Provisioning Failed pdu, Err carries the cause
+func (r *ProvisionResult) abort(state int, reason ProvisionFailReason, err error) {
+	r.State = byte(state)
+	r.Reason = reason
+	r.Err = err
+	loggerProv.Errorf("provision failed while waiting for %s pdu, error: %s", provPduNames[state], err)
+}
+
+func provFailedError(code byte, format string, args ...interface{}) error {
+	return errors.ProvisionFailed.New().AddContextF("%s: %s", provErrorNames[code], fmt.Sprintf(format, args...))
+}
+
+func (s *ProvisioningSession) provProc() {
+	result := &ProvisionResult{UUID: s.UUID}
+	defer func() { s.finish(result) }()
 	state := provInvite
-	confirmationInputs := make([]byte, 0)
+	s.confirmationInputs = make([]byte, 0)
 	pduOut := genProvInvite()
-	confirmationInputs = append(confirmationInputs, pduOut[1:]...)
-	provBear.SendProvPdu(pduOut)
+	s.confirmationInputs = append(s.confirmationInputs, pduOut[1:]...)
+	s.bear.SendProvPdu(pduOut)
 	state = provCapabilities
+	// public key pdu of the device built from its oob public key
+	var oobPdu []byte
+	fail := func(code byte, format string, args ...interface{}) {
+		result.fail(state, code, false, provFailedError(code, format, args...))
+	}
 	for {
-		provPdu, more := <-provChan
-		if !more {
-			return
+		var provPdu []byte
+		if oobPdu != nil {
+			provPdu, oobPdu = oobPdu, nil
+			loggerProv.Debugf("provision pdu from oob: % 2x", provPdu)
+		} else {
+			select {
+			case provPdu = <-s.provChan:
+			case <-s.done:
+				result.abort(state, ProvFailCanceled, errors.ProvisionCanceled.New())
+				return
+			case reason := <-s.linkClosed:
+				result.abort(state, ProvFailLinkClosed, errors.ProvisionLinkClosed.New().AddContextF("reason:%d", reason))
+				return
+			case <-time.After(provTimeout):
+				result.abort(state, ProvFailTimeout,
+					errors.Timeout.New().AddContextF("no %s pdu received in %s", provPduNames[state], provTimeout))
+				return
+			}
+			loggerProv.Debugf("provision pdu RX: % 2x", provPdu)
+		}
+		if len(provPdu) == 0 || provPdu[0] > provFailed {
+			fail(ProvErrInvalidPdu, "unknown pdu: % 2x", provPdu)
+			goto failed
 		}
-		loggerProv.Debugf("provision pdu RX: % 2x", provPdu)
 		pduType := int(provPdu[0])
 		data := provPdu[1:]
-		if len(data) != expectedLen[pduType] {
-			loggerProv.Errorf("unexpected pdu length, pdu type:%d", pduType)
+		if expected, ok := expectedLen[pduType]; !ok || state != pduType && pduType != provFailed {
+			fail(ProvErrUnexpectedPdu, "received a %s pdu", provPduNames[pduType])
+			goto failed
+		} else if len(data) != expected {
+			fail(ProvErrInvalidFormat, "length of %s pdu is %d", provPduNames[pduType], len(data))
 			goto failed
 		}
 		if pduType == provFailed {
-			loggerProv.Errorf("provision failed, error code:%d", data[0])
-			goto failed
+			code := data[0]
+			result.fail(state, code, true, errors.ProvisionFailed.New().AddContextF("reported by device: %s", provErrorNames[code]))
+			return
 		}
 
 		switch state {
-		case provInvite:
-			state = provCapabilities
 		case provCapabilities:
-			if state != pduType {
-				loggerProv.Errorf("expect a provCapabilities pdu, but recevied a %d", pduType)
-				goto failed
-			}
 			cap := &Capability{}
 			err := utils.ReadStructFromBuffer(data, cap)
-			if err != nil {
-				loggerProv.Errorf("decoding error while processing capability, error:%s", err)
+			if err != nil || cap.NumElements == 0 {
+				fail(ProvErrInvalidFormat, "decoding error while processing capability, error:%v", err)
+				goto failed
+			}
+			if cap.Algorithms&0x01 == 0 {
+				fail(ProvErrUnexpectedError, "FIPS P-256 Elliptic Curve is not supported")
 				goto failed
 			}
 
-			confirmationInputs = append(confirmationInputs, provPdu[1:]...)
+			s.confirmationInputs = append(s.confirmationInputs, provPdu[1:]...)
 
-			pduOut = genProvStart()
-			confirmationInputs = append(confirmationInputs, pduOut[1:]...)
-			provBear.SendProvPdu(pduOut)
-			time.Sleep(time.Second)
+			s.remoteNode.cap = cap
+			s.localNode = &ProvNode{}
+			s.localNode.auth, err = chooseAuthMethod(cap)
+			if err != nil {
+				// keep the auth error so the caller can tell it from a protocol error
+				result.fail(state, ProvErrUnexpectedError, false, err)
+				goto failed
+			}
+			s.localNode.auth.UUID = s.remoteNode.node.UUID
+			loggerProv.Infof("auth method:%d, action:%d, size:%d",
+				s.localNode.auth.Method, s.localNode.auth.Action, s.localNode.auth.Size)
+			s.remoteNode.oobPublicKey = getOobPublicKey(&s.device, cap)
+
+			pduOut = s.genProvStart()
+			s.confirmationInputs = append(s.confirmationInputs, pduOut[1:]...)
+			s.bear.SendProvPdu(pduOut)
+			if b, ok := s.bear.(*PbAdvBear); ok {
+				// the device has to get Start before our Public Key
+				b.waitAcked(pbAdvTransactionTimeout)
+			}
 
 			state = provPublicKey
 
@@ -141,77 +406,93 @@ func provProc() {
 
 			priKey, pubKey, err := p256.GenerateKey(rand.Reader)
 			if err != nil {
-				loggerProv.Errorf("Failed to generate private/public key pair: %s\n", err)
+				fail(ProvErrOutOfResources, "failed to generate private/public key pair: %s", err)
+				goto failed
+			}
+			s.localNode.pubKey = pubKey
+			s.localNode.priKey = priKey
+			s.localNode.p256 = p256
+			pduOut = s.genProvPublicKey()
+			s.confirmationInputs = append(s.confirmationInputs, pduOut[1:]...)
+			s.bear.SendProvPdu(pduOut)
+			if s.remoteNode.oobPublicKey != nil {
+				// the device will not send its public key
+				oobPdu = append([]byte{provPublicKey}, s.remoteNode.oobPublicKey...)
 			}
-			provPubKey, _ := pubKey.(ecdh.Point)
-			confirmationInputs = append(
-				append(confirmationInputs, provPubKey.X.Bytes()...),
-				provPubKey.Y.Bytes()...)
-
-			net.remoteNode.cap = cap
-			net.localNode = &ProvNode{}
-			net.localNode.pubKey = pubKey
-			net.localNode.priKey = priKey
-			net.localNode.p256 = p256
-			provBear.SendProvPdu(genProvPublicKey())
 		case provPublicKey:
+			if err := validatePublicKey(data); err != nil {
+				fail(ProvErrInvalidFormat, "%s", err)
+				goto failed
+			}
 			x := new(big.Int).SetBytes(data[:32])
 			y := new(big.Int).SetBytes(data[32:])
-			net.remoteNode.pubKey = &ecdh.Point{X: x, Y: y}
-			ecdhSecret := net.localNode.p256.ComputeSecret(net.localNode.priKey, net.remoteNode.pubKey)
-			confirmationInputs = append(confirmationInputs, data...)
-			confirmationSalt, _ := meshCrypto.S1(confirmationInputs)
+			s.remoteNode.pubKey = &ecdh.Point{X: x, Y: y}
+			ecdhSecret := padBytes(s.localNode.p256.ComputeSecret(s.localNode.priKey, s.remoteNode.pubKey), 32)
+			s.confirmationInputs = append(s.confirmationInputs, data...)
+			confirmationSalt, _ := meshCrypto.S1(s.confirmationInputs)
 			confirmationKey, _ := meshCrypto.K1(ecdhSecret, confirmationSalt, []byte("prck"))
-			authValue := make([]byte, 16, 16)
-			randoms := make([]byte, 16, 16)
-			rand.Read(randoms)
-			net.localNode.ecdhSecret = ecdhSecret
-			net.localNode.confirmationSalt = confirmationSalt
-			net.localNode.confirmationKey = confirmationKey
-			net.localNode.authValue = authValue
-			net.localNode.random = randoms
-			net.localNode.confirmation, _ = meshCrypto.AES_CMAC(confirmationKey, append(randoms, authValue...))
+			s.localNode.ecdhSecret = ecdhSecret
+			s.localNode.confirmationSalt = confirmationSalt
+			s.localNode.confirmationKey = confirmationKey
 
 			loggerProv.Debugf("ecdhSecret: %x", ecdhSecret)
-			loggerProv.Debugf("confirmationInputs: %x", confirmationInputs)
+			loggerProv.Debugf("confirmationInputs: %x", s.confirmationInputs)
 			loggerProv.Debugf("confirmationSalt: %x", confirmationSalt)
 			loggerProv.Debugf("confirmationKey: %x", confirmationKey)
-			loggerProv.Debugf("randoms: %x", randoms)
-			loggerProv.Debugf("confirmation: %x", net.localNode.confirmation)
 			loggerProv.Debugf("node public key, x:%x, y:%x", x, y)
 
-			provBear.SendProvPdu(genProvConfirmation())
+			authValue, err := getAuthValue(s.localNode.auth)
+			if err != nil {
+				fail(ProvErrUnexpectedError, "failed to get auth value, error:%s", err)
+				goto failed
+			}
+			s.localNode.authValue = authValue
+			if s.localNode.auth.Method == AuthInputOob {
+				// wait for the user to input the value on the device
+				state = provInputComplete
+				continue
+			}
+			s.sendProvConfirmation()
+			state = provConfirmation
+		case provInputComplete:
+			s.sendProvConfirmation()
 			state = provConfirmation
 		case provConfirmation:
 			loggerProv.Debugf("node confirmation: %x", data)
-			net.remoteNode.confirmation = data
-			provBear.SendProvPdu(genProvRandom())
+			s.remoteNode.confirmation = data
+			s.bear.SendProvPdu(s.genProvRandom())
 			state = provRandom
 		case provRandom:
 			loggerProv.Debugf("node random: %x", data)
-			net.remoteNode.random = data
-			calcConfirmation, _ := meshCrypto.AES_CMAC(net.localNode.confirmationKey, append(data, net.localNode.authValue...))
-			if bytes.Equal(calcConfirmation, net.remoteNode.confirmation) {
+			s.remoteNode.random = data
+			calcConfirmation, _ := meshCrypto.AES_CMAC(s.localNode.confirmationKey, append(data, s.localNode.authValue...))
+			if bytes.Equal(calcConfirmation, s.remoteNode.confirmation) {
 				loggerProv.Infof("provision confirmation successful")
 			} else {
-				loggerProv.Infof("provision confirmation failed")
+				fail(ProvErrConfirmationFailed, "confirmation of device: %x, calculated: %x",
+					s.remoteNode.confirmation, calcConfirmation)
 				goto failed
 			}
 			provisioningSalt, _ := meshCrypto.S1(
 				append(
-					append(net.localNode.confirmationSalt, net.localNode.random...),
-					net.remoteNode.random...))
-			sessionKey, _ := meshCrypto.K1(net.localNode.ecdhSecret, provisioningSalt, []byte("prsk"))
-			sessionNonce, _ := meshCrypto.K1(net.localNode.ecdhSecret, provisioningSalt, []byte("prsn"))
-			devKey, _ := meshCrypto.K1(net.localNode.ecdhSecret, provisioningSalt, []byte("prdk"))
+					append(s.localNode.confirmationSalt, s.localNode.random...),
+					s.remoteNode.random...))
+			sessionKey, _ := meshCrypto.K1(s.localNode.ecdhSecret, provisioningSalt, []byte("prsk"))
+			sessionNonce, _ := meshCrypto.K1(s.localNode.ecdhSecret, provisioningSalt, []byte("prsn"))
+			devKey, _ := meshCrypto.K1(s.localNode.ecdhSecret, provisioningSalt, []byte("prdk"))
 			//Provisioning Data = Network Key || Key Index || Flags || IV Index || Unicast Address
 
 			netKey, _ := findNetKeyByIndex(0)
 			if netKey == nil {
-				loggerProv.Error("netkey 000 does not exist")
-				return
+				fail(ProvErrUnexpectedError, "netkey 000 does not exist")
+				goto failed
+			}
+			unicastAddr, ok := allocUnicastAddr(s, uint(s.remoteNode.cap.NumElements))
+			if !ok {
+				fail(ProvErrCannotAssignAddresses, "no room for %d elements from address %04x",
+					s.remoteNode.cap.NumElements, unicastAddr)
+				goto failed
 			}
-			unicastAddr := calcUnicastAddr(net.remoteNode.node.UUID)
 			b := make([]byte, 2)
 			binary.LittleEndian.PutUint16(b, uint16(netKey.Index))
 			//Key Refresh Flag    0: Key Refresh Phase 0      1: Key Refresh Phase 2
@@ -225,70 +506,97 @@ func provProc() {
 
 			enc, tag, _ := meshCrypto.AES_CCM(sessionKey, sessionNonce[len(sessionNonce)-13:], provData, 8)
 			loggerProv.Infof("sending provision data...")
-			provBear.SendProvPdu(genProvData(enc, tag))
-
-			net.remoteNode.node.UnicastAddress = unicastAddr
-			net.remoteNode.node.DeviceKey = DevKey{}
-			net.remoteNode.node.DeviceKey.Bytes = devKey
-			net.remoteNode.node.DeviceKey.Aid = 0
-			net.remoteNode.node.NodeIdentityStates = map[uint]uint{}
-			net.remoteNode.node.BindedKeys = []NodeKeyBinding{
+			s.bear.SendProvPdu(genProvData(enc, tag))
+
+			s.remoteNode.node.UnicastAddress = unicastAddr
+			s.remoteNode.node.DeviceKey = DevKey{}
+			s.remoteNode.node.DeviceKey.Bytes = devKey
+			s.remoteNode.node.DeviceKey.Aid = 0
+			s.remoteNode.node.NodeIdentityStates = map[uint]uint{}
+			s.remoteNode.node.BindedKeys = []NodeKeyBinding{
 				NodeKeyBinding{NetKeyIndex: netKey.Index, BindedAppKeyIds: []uint{}},
 			}
+			// reserve the addresses of all elements until the composition data is fetched
+			s.remoteNode.node.Elements = []*Element{}
+			for i := 0; i < int(s.remoteNode.cap.NumElements); i++ {
+				s.remoteNode.node.Elements = append(s.remoteNode.node.Elements, &Element{
+					Node:           s.remoteNode.node,
+					ElementIndex:   i,
+					UnicastAddress: unicastAddr + uint(i),
+					Models:         []*Model{},
+				})
+			}
 			state = provComplete
 		case provComplete:
 			loggerProv.Infof("provision successful")
-			meshDb.Nodes[net.remoteNode.node.UnicastAddress] = net.remoteNode.node
-			writeNodeToDb(net.remoteNode.node)
+			provAddrLock.Lock()
+			meshDb.Nodes[s.remoteNode.node.UnicastAddress] = s.remoteNode.node
+			provAddrLock.Unlock()
+			// the address may be used by a node reset before
+			rplReset(s.remoteNode.node.UnicastAddress, len(s.remoteNode.node.Elements))
+			writeNodeToDb(s.remoteNode.node)
+			result.Node = s.remoteNode.node
 			return
 		}
 
 	}
 
 failed:
-	loggerProv.Errorf("error happened during provision")
+	s.bear.SendProvPdu(genProvFailed(result.ErrorCode))
 }
 
-func startProvision(uuid string) {
-	net.remoteNode = &ProvNode{}
-	net.remoteNode.node = &Node{}
-	net.remoteNode.node.UUID = uuid
-	provChan = make(chan []byte)
-	go provProc()
+func (s *ProvisioningSession) sendProvConfirmation() {
+	randoms := make([]byte, 16, 16)
+	rand.Read(randoms)
+	s.localNode.random = randoms
+	s.localNode.confirmation, _ = meshCrypto.AES_CMAC(s.localNode.confirmationKey,
+		append(randoms, s.localNode.authValue...))
+	loggerProv.Debugf("randoms: %x", randoms)
+	loggerProv.Debugf("confirmation: %x", s.localNode.confirmation)
+	s.bear.SendProvPdu(s.genProvConfirmation())
 }
 
-func stopProvision() {
-	close(provChan)
+// padBytes left pads b with zeros, big.Int.Bytes() drops the leading zeros
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
 }
 
 func genProvInvite() []byte {
 	return []byte{provInvite, 10}
 }
 
-func genProvStart() []byte {
+func (s *ProvisioningSession) genProvStart() []byte {
 	var algorithm byte
 	var publicKey byte
-	var authMethod byte
-	var authAction byte
-	var authSize byte
-	return []byte{provStart, algorithm, publicKey, authMethod, authAction, authSize}
+	if s.remoteNode.oobPublicKey != nil {
+		publicKey = 1
+	}
+	auth := s.localNode.auth
+	return []byte{provStart, algorithm, publicKey, byte(auth.Method), auth.Action, auth.Size}
 }
 
-func genProvPublicKey() []byte {
-	pubKey := net.localNode.pubKey.(ecdh.Point)
+func (s *ProvisioningSession) genProvPublicKey() []byte {
+	pubKey := s.localNode.pubKey.(ecdh.Point)
 	return append(
-		append([]byte{provPublicKey}, pubKey.X.Bytes()...),
-		pubKey.Y.Bytes()...)
+		append([]byte{provPublicKey}, padBytes(pubKey.X.Bytes(), 32)...),
+		padBytes(pubKey.Y.Bytes(), 32)...)
 }
 
-func genProvConfirmation() []byte {
-	return append([]byte{provConfirmation}, net.localNode.confirmation...)
+func (s *ProvisioningSession) genProvConfirmation() []byte {
+	return append([]byte{provConfirmation}, s.localNode.confirmation...)
 }
 
-func genProvRandom() []byte {
-	return append([]byte{provRandom}, net.localNode.random...)
+func (s *ProvisioningSession) genProvRandom() []byte {
+	return append([]byte{provRandom}, s.localNode.random...)
 }
 
 func genProvData(data, mic []byte) []byte {
 	return append(append([]byte{provData}, data...), mic...)
 }
+
+func genProvFailed(code byte) []byte {
+	return []byte{provFailed, code}
+}
@@ -0,0 +1,237 @@
+package mesh
+
+import (
+	"ble-mesh/utils/errors"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"math/big"
+	"strconv"
+)
+
+type (
+	AuthMethod byte
+
+	AuthRequest struct {
+		UUID   string
+		Method AuthMethod
+		Action byte
+		Size   byte
+		// for input OOB, the value generated by the provisioner which has to be
+		// entered on the device
+		Value string
+	}
+
+	// AuthValueHandler returns the auth value of a provisioning session:
+	// a hex string of 16 bytes for static OOB, the number or the string output
+	// by the device for output OOB. For input OOB, it is only used to show
+	// req.Value to the user and the returned value is ignored.
+	AuthValueHandler func(req *AuthRequest) (string, error)
+
+	// PublicKeySource returns the OOB public key (X || Y, 64 bytes) of the device,
+	// e.g. read from a QR code, a file or the URI matching dev.UriHash
+	PublicKeySource func(dev *UnprovisionedDevice) ([]byte, error)
+
+	AuthPolicy struct {
+		// acceptable auth methods in order of preference, No OOB has to be
+		// listed explicitly to be accepted
+		Methods []AuthMethod
+	}
+)
+
+const (
+	AuthNoOob AuthMethod = iota
+	AuthStaticOob
+	AuthOutputOob
+	AuthInputOob
+)
+
+/* Output OOB actions */
+const (
+	outputOobBlink = iota
+	outputOobBeep
+	outputOobVibrate
+	outputOobNumeric
+	outputOobAlphanumeric
+)
+
+/* Input OOB actions */
+const (
+	inputOobPush = iota
+	inputOobTwist
+	inputOobNumeric
+	inputOobAlphanumeric
+)
+
+const alphanumericChars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+var (
+	authPolicy = AuthPolicy{
+		Methods: []AuthMethod{AuthStaticOob, AuthOutputOob, AuthInputOob},
+	}
+	authValueHandler AuthValueHandler
+	publicKeySource  PublicKeySource
+
+	// preferred actions, the ones exchanging a value come first
+	outputOobActions = []byte{outputOobNumeric, outputOobAlphanumeric, outputOobBlink, outputOobBeep, outputOobVibrate}
+	inputOobActions  = []byte{inputOobNumeric, inputOobAlphanumeric, inputOobPush, inputOobTwist}
+)
+
+func SetProvisionAuthPolicy(policy AuthPolicy) {
+	authPolicy = policy
+}
+
+func SetAuthValueHandler(h AuthValueHandler) {
+	authValueHandler = h
+}
+
+func SetPublicKeySource(s PublicKeySource) {
+	publicKeySource = s
+}
+
+func validatePublicKey(key []byte) error {
+	if len(key) != 64 {
+		return errors.InvalidPublicKey.New().AddContextF("length of public key is %d", len(key))
+	}
+	x := new(big.Int).SetBytes(key[:32])
+	y := new(big.Int).SetBytes(key[32:])
+	if !elliptic.P256().IsOnCurve(x, y) {
+		return errors.InvalidPublicKey.New().AddContextF("public key is not on P-256: %x", key)
+	}
+	return nil
+}
+
+// getOobPublicKey fetches the device public key from the PublicKeySource
+// if the device supports OOB public key, nil is returned otherwise
+func getOobPublicKey(dev *UnprovisionedDevice, cap *Capability) []byte {
+	if cap.PublicKeyType&0x01 == 0 || publicKeySource == nil {
+		return nil
+	}
+	key, err := publicKeySource(dev)
+	if err == nil {
+		err = validatePublicKey(key)
+	}
+	if err != nil {
+		loggerProv.Warnf("cannot use oob public key of %s, fall back to the key from the bearer, error:%s", dev.UUID, err)
+		return nil
+	}
+	return key
+}
+
+func chooseAction(supported uint16, preferred []byte) (byte, bool) {
+	for _, a := range preferred {
+		if supported&(1<<a) != 0 {
+			return a, true
+		}
+	}
+	return 0, false
+}
+
+// chooseAuthMethod selects the first method of the policy which is supported by the device.
+// Methods other than No OOB need an AuthValueHandler. The provisioning fails if none matches,
+// the device is never provisioned with No OOB unless the policy allows it.
+func chooseAuthMethod(cap *Capability) (*AuthRequest, error) {
+	for _, m := range authPolicy.Methods {
+		req := &AuthRequest{Method: m}
+		if m != AuthNoOob && authValueHandler == nil {
+			continue
+		}
+		switch m {
+		case AuthNoOob:
+			return req, nil
+		case AuthStaticOob:
+			if cap.StaticOobType&0x01 != 0 {
+				return req, nil
+			}
+		case AuthOutputOob:
+			if cap.OutputOobType == 0 {
+				continue
+			}
+			if action, ok := chooseAction(cap.OutputOobAction, outputOobActions); ok {
+				req.Action = action
+				req.Size = cap.OutputOobType
+				return req, nil
+			}
+		case AuthInputOob:
+			if cap.InputOobType == 0 {
+				continue
+			}
+			if action, ok := chooseAction(cap.InputOobAction, inputOobActions); ok {
+				req.Action = action
+				req.Size = cap.InputOobType
+				return req, nil
+			}
+		}
+	}
+	return nil, errors.NoAcceptableAuthMethod.New().AddContextF("capability: %+v", *cap)
+}
+
+func isAlphanumericAction(req *AuthRequest) bool {
+	return (req.Method == AuthOutputOob && req.Action == outputOobAlphanumeric) ||
+		(req.Method == AuthInputOob && req.Action == inputOobAlphanumeric)
+}
+
+// genInputOobValue generates a random value of req.Size digits or characters
+func genInputOobValue(req *AuthRequest) string {
+	if req.Action == inputOobAlphanumeric {
+		value := make([]byte, req.Size)
+		for i := range value {
+			n, _ := rand.Int(rand.Reader, big.NewInt(int64(len(alphanumericChars))))
+			value[i] = alphanumericChars[n.Int64()]
+		}
+		return string(value)
+	}
+	max := uint64(1)
+	for i := byte(0); i < req.Size; i++ {
+		max *= 10
+	}
+	// push and twist actions need at least one operation
+	n, _ := rand.Int(rand.Reader, new(big.Int).SetUint64(max-1))
+	return strconv.FormatUint(n.Uint64()+1, 10)
+}
+
+// authValueFromString converts the value to the 16 bytes AuthValue
+func authValueFromString(req *AuthRequest, value string) ([]byte, error) {
+	authValue := make([]byte, 16)
+	switch {
+	case req.Method == AuthNoOob:
+	case req.Method == AuthStaticOob:
+		b, err := hex.DecodeString(value)
+		if err != nil || len(b) != 16 {
+			return nil, errors.InvalidAuthValue.New().AddContextF("static oob must be 16 bytes in hex: %s", value)
+		}
+		copy(authValue, b)
+	case isAlphanumericAction(req):
+		if len(value) == 0 || len(value) > int(req.Size) {
+			return nil, errors.InvalidAuthValue.New().AddContextF("expect at most %d characters: %s", req.Size, value)
+		}
+		copy(authValue, value)
+	default:
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, errors.InvalidAuthValue.New().AddContextF("expect a number: %s", value)
+		}
+		binary.BigEndian.PutUint64(authValue[8:], n)
+	}
+	return authValue, nil
+}
+
+// getAuthValue asks the AuthValueHandler for the auth value of the session
+func getAuthValue(req *AuthRequest) ([]byte, error) {
+	if req.Method == AuthNoOob {
+		return make([]byte, 16), nil
+	}
+	if req.Method == AuthInputOob {
+		req.Value = genInputOobValue(req)
+		if _, err := authValueHandler(req); err != nil {
+			return nil, err
+		}
+		return authValueFromString(req, req.Value)
+	}
+	value, err := authValueHandler(req)
+	if err != nil {
+		return nil, err
+	}
+	return authValueFromString(req, value)
+}
@@ -0,0 +1,64 @@
+package mesh
+
+import (
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_chooseAuthMethod(t *testing.T) {
+	defer SetProvisionAuthPolicy(authPolicy)
+	defer SetAuthValueHandler(authValueHandler)
+	SetAuthValueHandler(func(req *AuthRequest) (string, error) { return "", nil })
+	noOob := &Capability{NumElements: 1, Algorithms: 1}
+	// No OOB is not accepted by default
+	_, err := chooseAuthMethod(noOob)
+	assert.NotNil(t, err)
+	req, err := chooseAuthMethod(&Capability{NumElements: 1, Algorithms: 1, StaticOobType: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, AuthStaticOob, req.Method)
+	SetProvisionAuthPolicy(AuthPolicy{Methods: []AuthMethod{AuthStaticOob, AuthNoOob}})
+	req, err = chooseAuthMethod(noOob)
+	assert.Nil(t, err)
+	assert.Equal(t, AuthNoOob, req.Method)
+}
+
+func Test_validatePublicKey(t *testing.T) {
+	_, x, y, _ := elliptic.GenerateKey(elliptic.P256(), rand.Reader)
+	key := append(padBytes(x.Bytes(), 32), padBytes(y.Bytes(), 32)...)
+	assert.Nil(t, validatePublicKey(key))
+	assert.NotNil(t, validatePublicKey(key[:63]))
+	assert.NotNil(t, validatePublicKey(append(key, 0)))
+	offCurve := append([]byte{}, key...)
+	offCurve[63] ^= 0x01
+	assert.NotNil(t, validatePublicKey(offCurve))
+}
+
+func Test_getOobPublicKey(t *testing.T) {
+	defer SetPublicKeySource(publicKeySource)
+	_, x, y, _ := elliptic.GenerateKey(elliptic.P256(), rand.Reader)
+	key := append(padBytes(x.Bytes(), 32), padBytes(y.Bytes(), 32)...)
+	dev := &UnprovisionedDevice{UUID: "70cf7c9732a345b691494810d2e9cbf4", UriHash: 0x12345678}
+	oobKey := &Capability{PublicKeyType: 1}
+
+	SetPublicKeySource(nil)
+	assert.Nil(t, getOobPublicKey(dev, oobKey))
+
+	var got *UnprovisionedDevice
+	SetPublicKeySource(func(d *UnprovisionedDevice) ([]byte, error) {
+		got = d
+		return key, nil
+	})
+	assert.Equal(t, key, getOobPublicKey(dev, oobKey))
+	assert.Equal(t, uint32(0x12345678), got.UriHash)
+	// the device does not support oob public key
+	assert.Nil(t, getOobPublicKey(dev, &Capability{}))
+
+	SetPublicKeySource(func(d *UnprovisionedDevice) ([]byte, error) { return nil, errors.New("not found") })
+	assert.Nil(t, getOobPublicKey(dev, oobKey))
+	SetPublicKeySource(func(d *UnprovisionedDevice) ([]byte, error) { return key[:32], nil })
+	assert.Nil(t, getOobPublicKey(dev, oobKey))
+}
@@ -0,0 +1,118 @@
+package mesh
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeProvBear records the provisioning pdus sent to the device
+type fakeProvBear struct {
+	sent chan []byte
+}
+
+func (b *fakeProvBear) Start()                                   {}
+func (b *fakeProvBear) Stop()                                    {}
+func (b *fakeProvBear) OnPduReceived(pdu []byte)                 {}
+func (b *fakeProvBear) SetWriteHandle(handle func([]byte) error) {}
+func (b *fakeProvBear) SetMTU(mtu uint)                          {}
+func (b *fakeProvBear) SendNetPdu(pdu []byte)                    {}
+func (b *fakeProvBear) SendBeaconPdu(pdu []byte)                 {}
+func (b *fakeProvBear) SendProvPdu(pdu []byte)                   { b.sent <- pdu }
+
+func Test_provisionFailures(t *testing.T) {
+	defer func(timeout time.Duration) { provTimeout = timeout }(provTimeout)
+	provTimeout = 100 * time.Millisecond
+	tests := []struct {
+		name string
+		// what the device does after the Invite pdu
+		device           func(s *ProvisioningSession, b *fakeProvBear)
+		reason           ProvisionFailReason
+		errorCode        byte
+		reportedByDevice bool
+		// Provisioning Failed pdu sent to the device, nil if none
+		failedPdu []byte
+	}{
+		{"timeout", func(s *ProvisioningSession, b *fakeProvBear) {},
+			ProvFailTimeout, 0, false, nil},
+		{"unexpected pdu", func(s *ProvisioningSession, b *fakeProvBear) {
+			provisionReceive(b, append([]byte{provConfirmation}, make([]byte, 16)...))
+		}, ProvFailPdu, ProvErrUnexpectedPdu, false, []byte{provFailed, ProvErrUnexpectedPdu}},
+		{"invalid format", func(s *ProvisioningSession, b *fakeProvBear) {
+			provisionReceive(b, append([]byte{provCapabilities}, make([]byte, 10)...))
+		}, ProvFailPdu, ProvErrInvalidFormat, false, []byte{provFailed, ProvErrInvalidFormat}},
+		{"failed by device", func(s *ProvisioningSession, b *fakeProvBear) {
+			provisionReceive(b, []byte{provFailed, ProvErrOutOfResources})
+		}, ProvFailPdu, ProvErrOutOfResources, true, nil},
+		{"canceled", func(s *ProvisioningSession, b *fakeProvBear) {
+			s.Stop()
+		}, ProvFailCanceled, 0, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &fakeProvBear{sent: make(chan []byte, 8)}
+			s := StartMeshProvision(UnprovisionedDevice{UUID: "70cf7c9732a345b691494810d2e9cbf4"}, b, nil)
+			assert.Equal(t, byte(provInvite), (<-b.sent)[0])
+			tt.device(s, b)
+			var result *ProvisionResult
+			select {
+			case result = <-s.Result():
+			case <-time.After(time.Second):
+				t.Fatal("no provision result")
+			}
+			assert.Nil(t, result.Node)
+			assert.NotNil(t, result.Err)
+			assert.Equal(t, byte(provCapabilities), result.State)
+			assert.Equal(t, tt.reason, result.Reason)
+			assert.Equal(t, tt.errorCode, result.ErrorCode)
+			assert.Equal(t, tt.reportedByDevice, result.ReportedByDevice)
+			if tt.failedPdu == nil {
+				assert.Equal(t, 0, len(b.sent))
+			} else {
+				assert.Equal(t, tt.failedPdu, <-b.sent)
+			}
+		})
+	}
+}
+
+func Test_allocUnicastAddrConcurrent(t *testing.T) {
+	meshDb = &Mesh{Nodes: map[uint]*Node{}, LowAddress: 0x0100, HighAddress: 0x0120}
+	provAddrLock.Lock()
+	provAddrReserved = map[*ProvisioningSession][2]uint{}
+	provAddrLock.Unlock()
+	ranges := make(chan [2]uint, 8)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s := newProvisioningSession(UnprovisionedDevice{UUID: fmt.Sprintf("dev%d", i)}, &fakeProvBear{}, nil)
+			numElements := uint(i%3 + 1)
+			addr, ok := allocUnicastAddr(s, numElements)
+			assert.True(t, ok)
+			ranges <- [2]uint{addr, addr + numElements - 1}
+		}(i)
+	}
+	wg.Wait()
+	close(ranges)
+	sorted := [][2]uint{}
+	for r := range ranges {
+		sorted = append(sorted, r)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i][0] < sorted[j][0] })
+	assert.Equal(t, uint(0x0100), sorted[0][0])
+	for i := 1; i < len(sorted); i++ {
+		assert.True(t, sorted[i][0] > sorted[i-1][1], "ranges %04x and %04x overlap", sorted[i-1], sorted[i])
+	}
+	// 15 addresses are reserved, the rest is not enough
+	s := newProvisioningSession(UnprovisionedDevice{UUID: "dev8"}, &fakeProvBear{}, nil)
+	_, ok := allocUnicastAddr(s, 0x20)
+	assert.False(t, ok)
+	provAddrLock.Lock()
+	provAddrReserved = map[*ProvisioningSession][2]uint{}
+	provAddrLock.Unlock()
+}
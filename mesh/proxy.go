@@ -63,6 +63,10 @@ func (b *GattProxyBear) SendProvPdu(pdu []byte) {
 	b.proxySend(byte(PROVISION), pdu)
 }
 
+func (b *GattProxyBear) SendBeaconPdu(pdu []byte) {
+	b.proxySend(byte(BEACON), pdu)
+}
+
 func (b *GattProxyBear) segmentation(proxyType byte, pdu []byte) [][]byte {
 	list := [][]byte{}
 	genSeg := func(header byte, body []byte) []byte { return append([]byte{byte(header<<6 | proxyType)}, body...) }
@@ -123,10 +127,12 @@ func (b *GattProxyBear) assemblyProc() {
 func (b *GattProxyBear) sendMessageToUpperLayer(msgType byte, data []byte) {
 	switch msgType {
 	case PROVISION:
-		provisionReceive(data)
+		provisionReceive(b, data)
 	case NETWORK:
 		networkReceive(data)
 	case BEACON:
 		meshBeaconReceive(data)
+	case PROXY_CONFIG:
+		proxyConfigReceive(data)
 	}
 }
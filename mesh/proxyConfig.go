@@ -0,0 +1,210 @@
+package mesh
+
+import (
+	"ble-mesh/mesh/def"
+	"ble-mesh/utils"
+	"ble-mesh/utils/errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	PROXY_SET_FILTER_TYPE      = 0x00
+	PROXY_ADD_ADDRESSES        = 0x01
+	PROXY_REMOVE_ADDRESSES     = 0x02
+	PROXY_FILTER_STATUS        = 0x03
+	PROXY_FILTER_WHITELIST     = 0x00
+	PROXY_FILTER_BLACKLIST     = 0x01
+	proxyConfigTimeout         = 5 * time.Second
+	proxyFilterAddressesPerMsg = 8
+)
+
+var (
+	loggerProxy = utils.CreateLogger("ProxyConfig")
+	// serializes the requests, every one of them is answered by a filter status
+	proxyConfigLock   sync.Mutex
+	proxyFilterStatus = make(chan *def.FilterStatusMessageFormat, 1)
+	// the addresses added to the filter of the proxy besides our unicast address and the groups,
+	// they are added again when another proxy is connected
+	proxyFilterExtra = map[uint]bool{}
+	proxyFilterLock  sync.Mutex
+)
+
+// proxyConfigNetKey returns the key of the subnet the proxy configuration messages are sent on
+func proxyConfigNetKey() (*NetKey, error) {
+	if netKey, ok := meshDb.NetKeys[0]; ok {
+		return netKey, nil
+	}
+	for _, netKey := range meshDb.NetKeys {
+		return netKey, nil
+	}
+	return nil, errors.InvalidNetKeyIndex.New().AddContext("no net key")
+}
+
+func connectedProxy() (*GattProxyBear, error) {
+	b, ok := netBear.(*GattProxyBear)
+	if !ok || b.writeCb == nil {
+		return nil, errors.ProxyNotConnected.New().AddContextF("bearer: %T", netBear)
+	}
+	return b, nil
+}
+
+// proxyConfigSendPdu sends an encrypted proxy configuration pdu to the connected proxy node
+func proxyConfigSendPdu(pdu []byte) error {
+	b, err := connectedProxy()
+	if err != nil {
+		return err
+	}
+	b.proxySend(byte(PROXY_CONFIG), pdu)
+	return nil
+}
+
+// proxyConfigReceive decrypts the proxy configuration pdu received from the proxy node
+func proxyConfigReceive(pdu []byte) {
+	msg, err := networkDecryptByNid(pdu, NetworkMessage{proxyConfig: true})
+	if err != nil {
+		loggerProxy.Error(err)
+		return
+	}
+	if len(msg.plain) == 0 {
+		return
+	}
+	opcode, params := msg.plain[0], msg.plain[1:]
+	switch opcode {
+	case PROXY_FILTER_STATUS:
+		status := &def.FilterStatusMessageFormat{}
+		if len(params) != 3 {
+			loggerProxy.Error(errors.DataLengthCheckFailed.New().AddContextF("filter status: % 2x", params))
+			return
+		}
+		if err := utils.UnpackStructBE(params, status); err != nil {
+			loggerProxy.Error(err)
+			return
+		}
+		loggerProxy.Infof("filter status: type %d, %d addresses", status.FilterType, status.ListSize)
+		select {
+		case proxyFilterStatus <- status:
+		default:
+		}
+	default:
+		loggerProxy.Warnf("proxy configuration opcode %02x not supported", opcode)
+	}
+}
+
+// proxyConfigSend sends the proxy configuration message and waits for the filter status
+func proxyConfigSend(opcode uint, params []byte) (*def.FilterStatusMessageFormat, error) {
+	proxyConfigLock.Lock()
+	defer proxyConfigLock.Unlock()
+	if _, err := connectedProxy(); err != nil {
+		return nil, err
+	}
+	netKey, err := proxyConfigNetKey()
+	if err != nil {
+		return nil, err
+	}
+	// drop a late status of a previous request
+	select {
+	case <-proxyFilterStatus:
+	default:
+	}
+	ivIndex := txIvIndex()
+	tpTxChan <- &NetworkMessage{
+		ivi:         ivIndex & 0x01,
+		nid:         netKey.txKey().Nid,
+		ctl:         1,
+		ttl:         0,
+		src:         meshDb.UnicastAddress,
+		dst:         UNASSIGNED_ADDRESS,
+		plain:       append([]byte{byte(opcode)}, params...),
+		ivIndex:     ivIndex,
+		netKey:      netKey,
+		proxyConfig: true,
+	}
+	select {
+	case status := <-proxyFilterStatus:
+		return status, nil
+	case <-time.After(proxyConfigTimeout):
+		return nil, errors.Timeout.New().AddContextF("proxy configuration opcode %02x", opcode)
+	}
+}
+
+func proxyFilterAddresses(opcode uint, addrs []uint) (*def.FilterStatusMessageFormat, error) {
+	var status *def.FilterStatusMessageFormat
+	// keep the messages unsegmented
+	for i := 0; i < len(addrs); i += proxyFilterAddressesPerMsg {
+		end := i + proxyFilterAddressesPerMsg
+		if end > len(addrs) {
+			end = len(addrs)
+		}
+		params := []byte{}
+		for _, addr := range addrs[i:end] {
+			params = append(params, byte(addr>>8), byte(addr))
+		}
+		var err error
+		if status, err = proxyConfigSend(opcode, params); err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
+
+// ProxySetFilterType sets the filter type of the connected proxy node, the filter list is cleared
+func ProxySetFilterType(filterType uint) error {
+	_, err := proxyConfigSend(PROXY_SET_FILTER_TYPE, []byte{byte(filterType)})
+	return err
+}
+
+// ProxyFilterAdd adds the address to the filter of the proxy node,
+// the address is kept in the whitelist when the proxy is reconnected
+func ProxyFilterAdd(addr uint) error {
+	proxyFilterLock.Lock()
+	proxyFilterExtra[addr] = true
+	proxyFilterLock.Unlock()
+	_, err := proxyFilterAddresses(PROXY_ADD_ADDRESSES, []uint{addr})
+	return err
+}
+
+func ProxyFilterRemove(addr uint) error {
+	proxyFilterLock.Lock()
+	delete(proxyFilterExtra, addr)
+	proxyFilterLock.Unlock()
+	_, err := proxyFilterAddresses(PROXY_REMOVE_ADDRESSES, []uint{addr})
+	return err
+}
+
+// proxyWhitelist returns our unicast address and every group we care about
+func proxyWhitelist() []uint {
+	set := map[uint]bool{meshDb.UnicastAddress: true}
+	for addr := range meshDb.Groups {
+		set[addr] = true
+	}
+	proxyFilterLock.Lock()
+	for addr := range proxyFilterExtra {
+		set[addr] = true
+	}
+	proxyFilterLock.Unlock()
+	addrs := []uint{}
+	for addr := range set {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
+
+// ProxyConnected sets up the whitelist filter of a newly connected proxy node.
+// A proxy forwards all the mesh traffic until a filter is set.
+func ProxyConnected() error {
+	if err := ProxySetFilterType(PROXY_FILTER_WHITELIST); err != nil {
+		return err
+	}
+	addrs := proxyWhitelist()
+	status, err := proxyFilterAddresses(PROXY_ADD_ADDRESSES, addrs)
+	if err != nil {
+		return err
+	}
+	if status.ListSize != uint(len(addrs)) {
+		loggerProxy.Warnf("proxy filter holds %d of %d addresses", status.ListSize, len(addrs))
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package mesh
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_genProxyNonce(t *testing.T) {
+	nonce, err := genProxyNonce(0x0001, 0x000001, 0x12345678)
+	assert.Nil(t, err)
+	assert.Equal(t, "03000000010001000012345678", hex.EncodeToString(nonce))
+}
+
+func Test_proxyConfigPack(t *testing.T) {
+	netKey := createNetKeyS("7dd7364cd842ad18c17c2b820c84c3d6", 0)
+	meshDb = &Mesh{NetKeys: map[uint]*NetKey{0: netKey}, IVindex: 0x12345678}
+	msg := &NetworkMessage{ctl: 1, seq: 1, src: 0x0001, dst: UNASSIGNED_ADDRESS, plain: []byte{PROXY_SET_FILTER_TYPE, PROXY_FILTER_BLACKLIST},
+		ivIndex: 0x12345678, netKey: netKey, proxyConfig: true}
+	pdu, err := networkPack(msg)
+	assert.Nil(t, err)
+	// dst, opcode, filter type and the 64-bit NetMIC
+	assert.Len(t, pdu, 1+6+2+2+8)
+
+	rx, err := networkDecryptByNid(pdu, NetworkMessage{proxyConfig: true})
+	assert.Nil(t, err)
+	assert.Equal(t, msg.plain, rx.plain)
+	assert.Equal(t, uint(1), rx.seq)
+
+	// a network pdu is not a proxy configuration message
+	msg.proxyConfig = false
+	pdu, _ = networkPack(msg)
+	_, err = networkDecryptByNid(pdu, NetworkMessage{proxyConfig: true})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,125 @@
+package mesh
+
+import (
+	"ble-mesh/utils/errors"
+	"container/list"
+	"sync"
+	"time"
+)
+
+type (
+	// RelayStats counts the network pdus handled by the relay feature
+	RelayStats struct {
+		Relayed uint64
+		// advertisements sent including the retransmissions
+		Transmissions uint64
+		// already relayed, e.g. the same message relayed by another node
+		Duplicated uint64
+		// TTL less than 2
+		TtlExpired uint64
+	}
+
+	relayCacheEntry struct {
+		src     uint
+		seq     uint
+		ivIndex uint
+	}
+)
+
+const (
+	RELAY_DISABLED = 0x00
+	RELAY_ENABLED  = 0x01
+)
+
+var (
+	relayLock  sync.Mutex
+	relayStats RelayStats
+	// the messages relayed recently, identified by src, seq and iv index since
+	// the pdu changes with the TTL on every hop
+	relayCache = list.New()
+)
+
+// SetRelay sets the relay feature of the host node, the pdus are retransmitted
+// retransmitCount times with an interval of (retransmitIntervalSteps + 1) * 10 ms
+func SetRelay(relay, retransmitCount, retransmitIntervalSteps uint) error {
+	if relay > RELAY_ENABLED || retransmitCount > 0x07 || retransmitIntervalSteps > 0x1f {
+		return errors.WrongRelaySetting.New().AddContextF("relay:%d, count:%d, steps:%d", relay, retransmitCount, retransmitIntervalSteps)
+	}
+	meshDb.Relay = relay
+	meshDb.RelayRetransmitCount = retransmitCount
+	meshDb.RelayRetransmitIntervalSteps = retransmitIntervalSteps
+	writeMeshToDb()
+	return nil
+}
+
+func GetRelayStats() RelayStats {
+	relayLock.Lock()
+	defer relayLock.Unlock()
+	return relayStats
+}
+
+// relayCached adds the message to the relay cache, it returns true if it is already there
+func relayCached(msg *NetworkMessage) bool {
+	entry := relayCacheEntry{src: msg.src, seq: msg.seq, ivIndex: msg.ivIndex}
+	for e := relayCache.Front(); e != nil; e = e.Next() {
+		if e.Value.(relayCacheEntry) == entry {
+			return true
+		}
+	}
+	if relayCache.Len() > cacheSize {
+		relayCache.Remove(relayCache.Front())
+	}
+	relayCache.PushBack(entry)
+	return false
+}
+
+// relay retransmits the network pdu with TTL decremented if it is not
+// addressed to us only
+func relay(msg *NetworkMessage) {
+	if meshDb.Relay != RELAY_ENABLED || msg.dst == meshDb.UnicastAddress {
+		return
+	}
+	// the proxy node relays the messages of the gatt link itself
+	if _, ok := netBear.(*GattProxyBear); ok {
+		return
+	}
+	relayLock.Lock()
+	defer relayLock.Unlock()
+	if msg.ttl < 2 {
+		relayStats.TtlExpired++
+		return
+	}
+	if relayCached(msg) {
+		relayStats.Duplicated++
+		return
+	}
+	relayed := *msg
+	relayed.ttl--
+	// during key refresh the pdu is relayed with the key it was received with,
+	// the pdus of low power nodes with the master credentials
+	key := msg.rxKey
+	if key == nil {
+		key = msg.netKey.txKey()
+	}
+	pdu, err := networkPackWithKey(&relayed, key)
+	if err != nil {
+		loggerNet.Error(err)
+		return
+	}
+	relayStats.Relayed++
+	loggerNet.Debugf("relay src:%04x, dst:%04x, seq:%06x, ttl:%d", relayed.src, relayed.dst, relayed.seq, relayed.ttl)
+	go relayTransmit(pdu, meshDb.RelayRetransmitCount, meshDb.RelayRetransmitIntervalSteps)
+}
+
+func relayTransmit(pdu []byte, retransmitCount, retransmitIntervalSteps uint) {
+	interval := time.Duration(retransmitIntervalSteps+1) * 10 * time.Millisecond
+	for i := uint(0); i <= retransmitCount; i++ {
+		if i > 0 {
+			time.Sleep(interval)
+		}
+		netBear.SendNetPdu(pdu)
+		relayLock.Lock()
+		relayStats.Transmissions++
+		relayLock.Unlock()
+	}
+}
@@ -0,0 +1,85 @@
+package mesh
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_relay(t *testing.T) {
+	relayCache.Init()
+	relayStats = RelayStats{}
+	netKey := createNetKey(0)
+	meshDb = &Mesh{NetKeys: map[uint]*NetKey{0: netKey}, UnicastAddress: 0x0001, IVindex: 1,
+		Relay: RELAY_ENABLED, RelayRetransmitCount: 1}
+	sent := make(chan []byte, 10)
+	bear := &AdvertisingBear{}
+	bear.Start()
+	bear.SetWriteHandle(func(packet []byte) error {
+		sent <- packet[2:]
+		return nil
+	})
+	netBear = bear
+
+	msg := &NetworkMessage{ttl: 5, seq: 1, src: 0x0100, dst: 0xc000, plain: []byte{1, 2, 3, 4}, ivIndex: 1, netKey: netKey}
+	relay(msg)
+	for i := 0; i < 2; i++ {
+		select {
+		case pdu := <-sent:
+			rx, err := networkDecryptByNid(pdu, NetworkMessage{})
+			assert.Nil(t, err)
+			assert.Equal(t, uint(4), rx.ttl)
+			assert.Equal(t, msg.plain, rx.plain)
+		case <-time.After(time.Second):
+			t.Fatal("pdu not relayed")
+		}
+	}
+
+	// the same message from another relay
+	msg.ttl = 3
+	relay(msg)
+	// addressed to us
+	msg.seq, msg.dst = 2, 0x0001
+	relay(msg)
+	msg.seq, msg.dst, msg.ttl = 3, 0xc000, 1
+	relay(msg)
+	assert.Equal(t, RelayStats{Relayed: 1, Transmissions: 2, Duplicated: 1, TtlExpired: 1}, GetRelayStats())
+	assert.Len(t, sent, 0)
+}
+
+func Test_relayKeyRefresh(t *testing.T) {
+	relayCache.Init()
+	oldKey := createNetKey(0)
+	newKey := createNetKey(0)
+	newKey.OldKey = oldKey
+	oldKey.NewKey = newKey
+	newKey.KeyRefreshPhase = 1
+	meshDb = &Mesh{NetKeys: map[uint]*NetKey{0: newKey}, UnicastAddress: 0x0001, IVindex: 1, Relay: RELAY_ENABLED}
+	sent := make(chan []byte, 10)
+	bear := &AdvertisingBear{}
+	bear.Start()
+	bear.SetWriteHandle(func(packet []byte) error {
+		sent <- packet[2:]
+		return nil
+	})
+	netBear = bear
+
+	for i, key := range []*NetKey{oldKey, newKey} {
+		msg := &NetworkMessage{ttl: 5, seq: uint(i + 1), src: 0x0100, dst: 0xc000, plain: []byte{1, 2, 3, 4}, ivIndex: 1}
+		pdu, err := networkPackWithKey(msg, key)
+		assert.Nil(t, err)
+		rx, err := networkDecryptByNid(pdu, NetworkMessage{})
+		assert.Nil(t, err)
+		assert.Equal(t, key, rx.rxKey)
+		relay(rx)
+		select {
+		case pdu := <-sent:
+			// phase 1 transmits with the old key, but the pdu of a node
+			// already using the new key is relayed with the new key
+			assert.Equal(t, key.Nid, uint(pdu[0]&0x7F))
+		case <-time.After(time.Second):
+			t.Fatal("pdu not relayed")
+		}
+	}
+}
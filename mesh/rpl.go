@@ -0,0 +1,139 @@
+package mesh
+
+import (
+	"ble-mesh/mesh/db"
+	"ble-mesh/utils"
+	"ble-mesh/utils/errors"
+	"os"
+	"path"
+	"sync"
+)
+
+type (
+	rplEntry struct {
+		ivIndex uint
+		// SeqAuth of the last accepted message, the SEQ of an unsegmented one
+		seq uint
+		// the segments of the message are still being received
+		partial bool
+	}
+
+	// RplStats counts the messages checked by the replay protection list
+	RplStats struct {
+		Accepted uint64
+		// same IV index and a SeqAuth not greater than the last accepted one
+		Replayed uint64
+		// IV index older than the last accepted one
+		StaleIvIndex uint64
+		Entries      int
+	}
+)
+
+var (
+	// replay protection list, key: source address
+	rpl      = map[uint]*rplEntry{}
+	rplLock  sync.Mutex
+	rplStats RplStats
+	rplDirty bool
+)
+
+func rplPath() string {
+	return path.Join(confDir, "rpl.json")
+}
+
+func loadRpl() {
+	raw := []db.RplEntry{}
+	if _, err := os.Stat(rplPath()); os.IsNotExist(err) {
+		return
+	}
+	if err := db.ReadFromDb(rplPath(), &raw); err != nil {
+		loggerNet.Errorf("failed to read replay protection list, error: %s", err)
+		return
+	}
+	rplLock.Lock()
+	defer rplLock.Unlock()
+	for _, e := range raw {
+		rpl[utils.HexStringToUint(e.Src)] = &rplEntry{ivIndex: e.IVindex, seq: e.Seq}
+	}
+}
+
+// writeRplToDb writes the list if it changed. It is called before an accepted
+// message is delivered, so only a crash during the write itself can lose the
+// last entry, the file is not synced to the disk either.
+func writeRplToDb() {
+	rplLock.Lock()
+	defer rplLock.Unlock()
+	if !rplDirty {
+		return
+	}
+	raw := []db.RplEntry{}
+	for src, e := range rpl {
+		raw = append(raw, db.RplEntry{Src: utils.UintToHexString(src), IVindex: e.ivIndex, Seq: e.seq})
+	}
+	if err := db.WriteToDb(rplPath(), raw); err != nil {
+		loggerNet.Errorf("failed to write replay protection list, error: %s", err)
+		return
+	}
+	rplDirty = false
+}
+
+// rplCheck rejects the message if its SeqAuth is not greater than the last
+// accepted one from the same source, the entry is updated otherwise. The
+// segments of the message being received share its SeqAuth, they are accepted
+// in any order until rplComplete is called.
+func rplCheck(src, seqAuth, ivIndex uint, segmented bool) error {
+	rplLock.Lock()
+	defer rplLock.Unlock()
+	e, ok := rpl[src]
+	if ok {
+		if ivIndex < e.ivIndex {
+			rplStats.StaleIvIndex++
+			return errors.MessageReplayed.New().AddContextF("src:%04x, iv index %d < %d", src, ivIndex, e.ivIndex)
+		}
+		if ivIndex == e.ivIndex && seqAuth == e.seq && segmented && e.partial {
+			rplStats.Accepted++
+			return nil
+		}
+		if ivIndex == e.ivIndex && seqAuth <= e.seq {
+			rplStats.Replayed++
+			return errors.MessageReplayed.New().AddContextF("src:%04x, seq %06x <= %06x", src, seqAuth, e.seq)
+		}
+	} else {
+		e = &rplEntry{}
+		rpl[src] = e
+	}
+	e.ivIndex, e.seq, e.partial = ivIndex, seqAuth, segmented
+	rplStats.Accepted++
+	rplDirty = true
+	return nil
+}
+
+// rplComplete marks the message as received, its segments are replays from now on
+func rplComplete(src, seqAuth, ivIndex uint) {
+	rplLock.Lock()
+	defer rplLock.Unlock()
+	if e, ok := rpl[src]; ok && e.ivIndex == ivIndex && e.seq == seqAuth {
+		e.partial = false
+	}
+}
+
+// rplReset removes the entries of the elements of a node, its sequence numbers
+// start over when the address is assigned again
+func rplReset(addr uint, numElements int) {
+	rplLock.Lock()
+	defer rplLock.Unlock()
+	for i := 0; i < numElements; i++ {
+		if _, ok := rpl[addr+uint(i)]; ok {
+			delete(rpl, addr+uint(i))
+			rplDirty = true
+		}
+	}
+}
+
+func GetRplStats() RplStats {
+	rplLock.Lock()
+	defer rplLock.Unlock()
+	stats := rplStats
+	stats.Entries = len(rpl)
+	return stats
+}
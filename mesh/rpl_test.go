@@ -0,0 +1,38 @@
+package mesh
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_rplCheck(t *testing.T) {
+	rpl = map[uint]*rplEntry{}
+	rplStats = RplStats{}
+	assert.Nil(t, rplCheck(0x0100, 10, 1, false))
+	assert.NotNil(t, rplCheck(0x0100, 10, 1, false))
+	assert.NotNil(t, rplCheck(0x0100, 9, 1, false))
+	assert.Nil(t, rplCheck(0x0100, 11, 1, false))
+	assert.Nil(t, rplCheck(0x0101, 1, 1, false))
+	// new IV index, SEQ starts over
+	assert.Nil(t, rplCheck(0x0100, 0, 2, false))
+	assert.NotNil(t, rplCheck(0x0100, 100, 1, false))
+	rplReset(0x0100, 2)
+	assert.Nil(t, rplCheck(0x0100, 0, 1, false))
+	assert.Equal(t, RplStats{Accepted: 5, Replayed: 2, StaleIvIndex: 1, Entries: 1}, GetRplStats())
+}
+
+func Test_rplCheckSegments(t *testing.T) {
+	rpl = map[uint]*rplEntry{}
+	rplStats = RplStats{}
+	// the segments of the message with SeqAuth 20 arrive out of order
+	assert.Nil(t, rplCheck(0x0100, 20, 1, true))
+	assert.Nil(t, rplCheck(0x0100, 20, 1, true))
+	assert.NotNil(t, rplCheck(0x0100, 19, 1, true))
+	// an unsegmented message with the same SEQ is a replay
+	assert.NotNil(t, rplCheck(0x0100, 20, 1, false))
+	rplComplete(0x0100, 20, 1)
+	assert.NotNil(t, rplCheck(0x0100, 20, 1, true))
+	assert.Nil(t, rplCheck(0x0100, 21, 1, false))
+	assert.Equal(t, RplStats{Accepted: 3, Replayed: 3, Entries: 1}, GetRplStats())
+}
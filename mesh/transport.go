@@ -4,6 +4,7 @@ import (
 	"ble-mesh/mesh/crypto"
 	"ble-mesh/utils"
 	"ble-mesh/utils/errors"
+	"sync"
 	"time"
 
 	funk "github.com/thoas/go-funk"
@@ -14,6 +15,7 @@ var (
 	tpTxChan       chan *NetworkMessage
 	segAckChan     chan *SegmentAckMessage
 	segMsgTimeouts map[*NetworkMessage]*AckTimeout
+	segMsgLock     sync.Mutex
 	loggerTp       = utils.CreateLogger("tp")
 	tpSars         map[uint64]*TpSar
 )
@@ -40,6 +42,15 @@ type (
 	}
 
 	onAckReceived func(ack *SegmentAckMessage)
+
+	// upperTpPdu is an access message encrypted when its first pdu is sent,
+	// the SEQ of this pdu is SeqAuth. It is only used by the tx scheduler.
+	upperTpPdu struct {
+		encrypt func(seqAuth uint) ([][]byte, error)
+		seqAuth uint
+		// the lower transport pdus, one per segment
+		pdus [][]byte
+	}
 )
 
 func (s *TpSar) getRecvdFlags() uint {
@@ -138,7 +149,8 @@ func tpHandleAccessMessageRx(netMsg *NetworkMessage) error {
 			tpSar.cipher = tpSar.cipher[:SEGMENT_SIZE*int(segN)+len(segment)]
 		}
 		if len(tpSar.recvdSegs) == int(segN+1) {
-			// all received
+			// all received, the segments sent again are replays now
+			rplComplete(netMsg.src, seqAuth, netMsg.ivIndex)
 			loggerTp.Debugf("TP assembly: %v", tpSar.cipher)
 			accessPdu, err = tpDecryptMessage(tpSar.cipher, netMsg.src, netMsg.dst, seqAuth, netMsg.ivIndex, szmic, akf, aid)
 			if err != nil {
@@ -189,6 +201,13 @@ func tpHandleControlMessageRx(netMsg *NetworkMessage) error {
 			}
 			loggerTp.Debugf("received ACK: %+#v", ackMsg)
 			segAckChan <- ackMsg
+		} else if opcode == heartbeatOpcode {
+			return heartbeatReceive(netMsg, netMsg.plain[1:])
+		} else {
+			if err := lpnControlReceive(netMsg, opcode, netMsg.plain[1:]); err != nil {
+				return err
+			}
+			return friendControlReceive(netMsg, opcode, netMsg.plain[1:])
 		}
 	}
 	return nil
@@ -197,20 +216,25 @@ func tpHandleControlMessageRx(netMsg *NetworkMessage) error {
 func tpDecryptMessage(cipher []byte, src, dst, seq, ivIndex, szmic, akf, aid uint) ([]byte, error) {
 	for _, key := range findAppKeyByAid(aid) {
 		function := genApplicationNonce
+		// during key refresh, the message may be encrypted with the old key
+		keys := key.rxKeys(aid)
 		if akf == 0 {
 			if node, _ := findNodeByAddr(src); node != nil {
-				key = &node.DeviceKey.AppKey
+				keys = []*AppKey{&node.DeviceKey.AppKey}
 				function = genDeviceNonce
 			}
 		}
-		if key != nil && key.Bytes != nil {
+		for _, k := range keys {
+			if k.Bytes == nil {
+				continue
+			}
 			tagSize := 4
 			if szmic == 1 {
 				tagSize = 8
 			}
 			nonce, err := function(src, seq, ivIndex, szmic, dst)
 			accessPlain, err := crypto.AES_CCM_Decrypt(
-				key.Bytes,
+				k.Bytes,
 				nonce,
 				cipher,
 				tagSize)
@@ -234,18 +258,20 @@ func seqAuth(seq, seqZero uint) uint {
 	return seqAuth
 }
 
-func transportTxProc() {
-	for {
-		netMsg, more := <-tpTxChan
-		if !more {
-			return
+// lowerPdu returns the lower transport pdu of the segment sent with SEQ seq
+func (u *upperTpPdu) lowerPdu(segO int, seq uint) ([]byte, error) {
+	if u.pdus == nil {
+		pdus, err := u.encrypt(seq)
+		if err != nil {
+			return nil, err
 		}
-		time.Sleep(time.Millisecond * 10)
-		netMsg.seq = meshDb.SequenceNumber
-		meshDb.SequenceNumber++
-		networkSend(netMsg)
+		u.seqAuth, u.pdus = seq, pdus
 	}
-
+	// SeqZero is the 13 lsb of SeqAuth
+	if seq-u.seqAuth > 0x1FFF {
+		return nil, errors.TransportSarFailed.New().AddContextF("SEQ %06x too far from SeqAuth %06x", seq, u.seqAuth)
+	}
+	return u.pdus[segO], nil
 }
 
 func transportSegmentTimeoutProc() {
@@ -256,6 +282,7 @@ func transportSegmentTimeoutProc() {
 			if !more {
 				return
 			}
+			segMsgLock.Lock()
 			for netMsg, meta := range segMsgTimeouts {
 				mask := uint(1) << uint(meta.segO)
 				if netMsg.dst == ack.src {
@@ -273,8 +300,10 @@ func transportSegmentTimeoutProc() {
 					}
 				}
 			}
+			segMsgLock.Unlock()
 		case <-to.C:
 		}
+		segMsgLock.Lock()
 		for netMsg, meta := range segMsgTimeouts {
 			if time.Now().After(meta.timeOut) {
 				if meta.remainingTries == 0 {
@@ -287,6 +316,7 @@ func transportSegmentTimeoutProc() {
 				meta.timeOut = time.Now().Add(meta.duration)
 			}
 		}
+		segMsgLock.Unlock()
 	}
 }
 
@@ -314,8 +344,10 @@ func tpSendAccessMsg(akf uint, appKey *AppKey, netKey *NetKey, payload []byte, d
 	if appKey == nil || netKey == nil {
 		return errors.NilPointer.New()
 	}
+	// the old keys are still used to transmit in phase 1 of key refresh
+	appKey, netKey = appKey.txKey(), netKey.txKey()
 	var szmic, seg, ctl uint
-	seq := meshDb.SequenceNumber
+	ivIndex := txIvIndex()
 	function := genDeviceNonce
 	if akf == 1 {
 		function = genApplicationNonce
@@ -324,36 +356,45 @@ func tpSendAccessMsg(akf uint, appKey *AppKey, netKey *NetKey, payload []byte, d
 	if szmic == 1 {
 		transMic = 8
 	}
-	nonce, err := function(
-		meshDb.UnicastAddress,
-		seq,
-		meshDb.IVindex,
-		szmic,
-		dst,
-	)
-	if err != nil {
-		return err
-	}
-	cipher, mic, err := crypto.AES_CCM(appKey.Bytes, nonce, payload, transMic)
-	if err != nil {
-		return err
-	}
-	upperTpPdu := append(cipher, mic...)
-	ivIndex := meshDb.IVindex
-	if meshDb.IVupdate == 1 {
-		ivIndex--
-	}
-	if len(upperTpPdu) > MAX_TRANSPORT_PDU {
-		msgs := []*NetworkMessage{}
+	if len(payload)+transMic > MAX_TRANSPORT_PDU {
 		seg = 1
-		segments := funk.Chunk(upperTpPdu, SEGMENT_SIZE).([][]byte)
+	}
+	segN := (len(payload)+transMic+SEGMENT_SIZE-1)/SEGMENT_SIZE - 1
+	// SEQ of the nonce is the SEQ of an unsegmented message or SeqAuth of a
+	// segmented one, it is assigned by the tx scheduler
+	upper := &upperTpPdu{encrypt: func(seq uint) ([][]byte, error) {
+		nonce, err := function(
+			meshDb.UnicastAddress,
+			seq,
+			ivIndex,
+			szmic,
+			dst,
+		)
+		if err != nil {
+			return nil, err
+		}
+		cipher, mic, err := crypto.AES_CCM(appKey.Bytes, nonce, payload, transMic)
+		if err != nil {
+			return nil, err
+		}
+		upperTpPdu := append(cipher, mic...)
+		if seg == 0 {
+			pdu, err := utils.PackBE("1,1,6,B8", seg, akf, appKey.Aid, upperTpPdu)
+			return [][]byte{pdu}, err
+		}
+		pdus := [][]byte{}
 		seqZero := seq & 0x1fff
-		segN := len(segments) - 1
-		for segO, segment := range segments {
+		for segO, segment := range funk.Chunk(upperTpPdu, SEGMENT_SIZE).([][]byte) {
 			pdu, err := utils.PackBE("1,1,6,1,13,5,5,B8", seg, akf, appKey.Aid, szmic, seqZero, segO, segN, segment)
 			if err != nil {
-				return err
+				return nil, err
 			}
+			pdus = append(pdus, pdu)
+		}
+		return pdus, nil
+	}}
+	if seg == 1 {
+		for segO := 0; segO <= segN; segO++ {
 			netMsg := &NetworkMessage{
 				ivi:     ivIndex & 0x01,
 				nid:     netKey.Nid,
@@ -361,16 +402,17 @@ func tpSendAccessMsg(akf uint, appKey *AppKey, netKey *NetKey, payload []byte, d
 				ttl:     ttl,
 				src:     meshDb.UnicastAddress,
 				dst:     dst,
-				plain:   pdu,
 				ivIndex: ivIndex,
 				netKey:  netKey,
 				ackFunc: ackFunc,
+				upper:   upper,
+				segO:    segO,
 			}
-			msgs = append(msgs, netMsg)
 			go func(index int) {
 				time.Sleep(time.Duration(200*index) * time.Millisecond)
 				tpTxChan <- netMsg
 				duration := time.Duration(550+50*ttl) * time.Millisecond
+				segMsgLock.Lock()
 				segMsgTimeouts[netMsg] = &AckTimeout{
 					remainingTries: 3,
 					segO:           index,
@@ -378,24 +420,20 @@ func tpSendAccessMsg(akf uint, appKey *AppKey, netKey *NetKey, payload []byte, d
 					timeOut:        time.Now().Add(duration),
 					fullmask:       (1 << uint(segN)) - 1,
 				}
+				segMsgLock.Unlock()
 			}(segO)
 		}
 	} else {
-		pdu, err := utils.PackBE("1,1,6,B8", seg, akf, appKey.Aid, upperTpPdu)
-		if err != nil {
-			return err
-		}
 		netMsg := &NetworkMessage{
 			ivi:     ivIndex & 0x01,
 			nid:     netKey.Nid,
 			ctl:     ctl,
 			ttl:     ttl,
-			seq:     seq,
 			src:     meshDb.UnicastAddress,
 			dst:     dst,
-			plain:   pdu,
 			ivIndex: ivIndex,
 			netKey:  netKey,
+			upper:   upper,
 		}
 		tpTxChan <- netMsg
 	}
@@ -419,10 +457,8 @@ func tpSendSegAck(key *NetKey, src, dst, seqZero, recvdFlags uint) error {
 	if err != nil {
 		return err
 	}
-	ivIndex := meshDb.IVindex
-	if meshDb.IVupdate == 1 {
-		ivIndex--
-	}
+	ivIndex := txIvIndex()
+	key = key.txKey()
 	/* We don't ACK segments as a Low Power Node */
 
 	/* If we are acking our LPN Friend, queue, don't send */
@@ -442,6 +478,28 @@ func tpSendSegAck(key *NetKey, src, dst, seqZero, recvdFlags uint) error {
 	return nil
 }
 
+// tpSendControlMsg sends an unsegmented transport control message
+func tpSendControlMsg(netKey *NetKey, opcode uint, params []byte, dst, ttl uint) error {
+	pdu, err := utils.PackBE("1,7,B8", 0, opcode, params)
+	if err != nil {
+		return err
+	}
+	ivIndex := txIvIndex()
+	loggerTp.Debugf("TP CTL TX: % 2x", pdu)
+	tpTxChan <- &NetworkMessage{
+		ivi:     ivIndex & 0x01,
+		nid:     netKey.txKey().Nid,
+		ctl:     1,
+		ttl:     ttl,
+		src:     meshDb.UnicastAddress,
+		dst:     dst,
+		plain:   pdu,
+		ivIndex: ivIndex,
+		netKey:  netKey,
+	}
+	return nil
+}
+
 func startTransport() {
 	tpSars = map[uint64]*TpSar{}
 	tpRxChan = make(chan *NetworkMessage)
@@ -449,7 +507,7 @@ func startTransport() {
 	segAckChan = make(chan *SegmentAckMessage)
 	segMsgTimeouts = map[*NetworkMessage]*AckTimeout{}
 	go transportRxProc()
-	go transportTxProc()
+	go netTxProc()
 	go transportSegmentTimeoutProc()
 }
 
@@ -34,10 +34,34 @@ const (
 	AddressAlreadyInSubscriptionList
 	WrongTTLSetting
 	WrongGattProxySetting
+	WrongRelaySetting
+	WrongNetworkTransmitSetting
+	WrongFriendSetting
 	InvalidResponse
+	MessageReplayed
+	IvUpdateNotAllowed
+	KeyRefreshFailed
+	InvalidKeyRefreshPhase
+	ProxyNotConnected
+
+	//Provision
+	NoAcceptableAuthMethod
+	InvalidAuthValue
+	InvalidPublicKey
+	ProvisionFailed
+	ProvisionCanceled
+	ProvisionLinkClosed
 
 	CannotSetRangeMin
 	CannotSetRangeMax
+	SensorPropertyNotSupported
+	AcknowledgedMessageToGroup
+	SceneRegisterFull
+	SceneNotFound
+	WrongTimeSetting
+	InvalidSchedulerEntry
+	InvalidColor
+	InvalidOnboardingProfile
 
 	//BitString
 	WrongFormatOfBitString
@@ -74,12 +98,35 @@ var errorDict = map[ErrorType]string{
 	AddressAlreadyInSubscriptionList: "address already in subscription list",
 	WrongTTLSetting:                  "wrong ttl setting",
 	WrongGattProxySetting:            "wrong gatt proxy setting",
+	WrongRelaySetting:                "wrong relay setting",
+	WrongNetworkTransmitSetting:      "wrong network transmit setting",
+	WrongFriendSetting:               "wrong friend setting",
 	InvalidResponse:                  "invalid response, request is failed",
+	MessageReplayed:                  "message replayed",
+	IvUpdateNotAllowed:               "iv update is not allowed now",
+	KeyRefreshFailed:                 "key refresh failed",
+	InvalidKeyRefreshPhase:           "invalid key refresh phase",
+	ProxyNotConnected:                "no proxy node is connected",
 	Timeout:                          "timeout happens",
 	DataLengthCheckFailed:            "data length check failed",
 
-	CannotSetRangeMin: "Cannot Set Range Min",
-	CannotSetRangeMax: "Cannot Set Range Max",
+	NoAcceptableAuthMethod: "no auth method of the policy is supported by the device",
+	InvalidAuthValue:       "invalid auth value",
+	InvalidPublicKey:       "invalid public key",
+	ProvisionFailed:        "provision failed",
+	ProvisionCanceled:      "provision is canceled",
+	ProvisionLinkClosed:    "provisioning link is closed by the device",
+
+	CannotSetRangeMin:          "Cannot Set Range Min",
+	CannotSetRangeMax:          "Cannot Set Range Max",
+	SensorPropertyNotSupported: "sensor property not supported",
+	AcknowledgedMessageToGroup: "acknowledged message can not be sent to a group",
+	SceneRegisterFull:          "scene register full",
+	SceneNotFound:              "scene not found",
+	WrongTimeSetting:           "wrong time setting",
+	InvalidSchedulerEntry:      "invalid scheduler entry",
+	InvalidColor:               "invalid color",
+	InvalidOnboardingProfile:   "invalid onboarding profile",
 
 	WrongFormatOfBitString:      "format of BitString is wrong",
 	LengthMismatchOfBitString:   "length of data does not match the bitstring when unpacking",
@@ -119,3 +166,9 @@ func (err *MeshError) Error() string {
 func (err *MeshError) ErrorType() ErrorType {
 	return err.errorType
 }
+
+// Is reports whether err is a MeshError of type et
+func (et ErrorType) Is(err error) bool {
+	e, ok := err.(*MeshError)
+	return ok && e != nil && e.errorType == et
+}
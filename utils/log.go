@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 
 	"github.com/sirupsen/logrus"
 )
@@ -13,8 +14,10 @@ const logLevel = logrus.DebugLevel
 const MAXLOGLINES = 100
 
 type (
+	// the loggers of all modules share the stream, lock guards buf and logFile
 	logStream struct {
-		buf []string
+		lock sync.Mutex
+		buf  []string
 	}
 
 	logData struct {
@@ -31,6 +34,8 @@ func (w *logStream) Write(p []byte) (n int, err error) {
 	var data logData
 	json.Unmarshal(p, &data)
 	str := fmt.Sprintf("%s %s %s\n", data.Time, strings.ToUpper(data.Level), data.Msg)
+	w.lock.Lock()
+	defer w.lock.Unlock()
 	os.Stdout.Write([]byte(str))
 	if w.buf == nil {
 		w.buf = []string{}
@@ -52,6 +57,8 @@ func (w *logStream) Write(p []byte) (n int, err error) {
 }
 
 func (w *logStream) Read() string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
 	if w.buf == nil {
 		w.buf = []string{}
 	}